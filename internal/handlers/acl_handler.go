@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+
+	"triple-s/internal/models"
+	"triple-s/internal/services"
+)
+
+// requestACL reads the canned ACL of an upload from the x-amz-acl header. It writes an error
+// response and returns false when the header names an unsupported ACL.
+func requestACL(w http.ResponseWriter, r *http.Request) (string, bool) {
+	acl := r.Header.Get("x-amz-acl")
+	if !services.ValidCannedACL(acl) {
+		WriteXMLResponse(w, http.StatusBadRequest, "Unsupported canned ACL in x-amz-acl header")
+		return "", false
+	}
+	return services.StoredACL(acl), true
+}
+
+// aclFromRequest reads the canned ACL set by a PUT ?acl request, either from the x-amz-acl
+// header or from an AccessControlPolicy body granting the permissions of a canned ACL
+func aclFromRequest(r *http.Request) (string, error) {
+	if acl := r.Header.Get("x-amz-acl"); acl != "" {
+		if !services.ValidCannedACL(acl) {
+			return "", errors.New("unsupported canned ACL " + acl)
+		}
+		return services.StoredACL(acl), nil
+	}
+
+	var policy models.AccessControlPolicy
+	if err := xml.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&policy); err != nil {
+		return "", errors.New("malformed access control policy")
+	}
+	acl, err := services.CannedACL(policy, defaultOwner)
+	if err != nil {
+		return "", err
+	}
+	return services.StoredACL(acl), nil
+}
+
+// HandleGetBucketACL handles GET /{bucket}?acl requests returning the grants of a bucket
+func HandleGetBucketACL(w http.ResponseWriter, r *http.Request, directoryPath string) {
+	localBucket, ok := findTaggedBucket(w, r, directoryPath)
+	if !ok {
+		return
+	}
+	writeXMLResult(w, services.ACLGrants(localBucket.ACL, defaultOwner))
+}
+
+// HandlePutBucketACL handles PUT /{bucket}?acl requests replacing the canned ACL of a bucket
+func HandlePutBucketACL(w http.ResponseWriter, r *http.Request, directoryPath string) {
+	defer r.Body.Close()
+
+	localBucket, ok := findTaggedBucket(w, r, directoryPath)
+	if !ok {
+		return
+	}
+
+	acl, err := aclFromRequest(r)
+	if err != nil {
+		writeErrorResponse(w, "Invalid ACL: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = services.UpdateBucketInfo(directoryPath, localBucket.Name, func(bucket *models.Bucket) {
+		bucket.ACL = acl
+	})
+	if err != nil {
+		writeErrorResponse(w, "Error writing bucket info", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandlerGetObjectACL handles GET ?acl requests returning the grants of an object
+func HandlerGetObjectACL(w http.ResponseWriter, r *http.Request, directoryPath string, bucketName, objectKey string) {
+	localObject, ok := findTaggedObject(w, directoryPath, bucketName, objectKey)
+	if !ok {
+		return
+	}
+	writeXMLResult(w, services.ACLGrants(localObject.ACL, defaultOwner))
+}
+
+// HandlerPutObjectACL handles PUT ?acl requests replacing the canned ACL of an object
+func HandlerPutObjectACL(w http.ResponseWriter, r *http.Request, directoryPath string, bucketName, objectKey string) {
+	defer r.Body.Close()
+
+	defer services.LockObject(directoryPath, bucketName, objectKey)()
+	localObject, ok := findTaggedObject(w, directoryPath, bucketName, objectKey)
+	if !ok {
+		return
+	}
+
+	acl, err := aclFromRequest(r)
+	if err != nil {
+		WriteXMLResponse(w, http.StatusBadRequest, "Invalid ACL: "+err.Error())
+		return
+	}
+
+	localObject.ACL = acl
+	if err := services.PutObjectInfo(directoryPath, bucketName, localObject); err != nil {
+		WriteXMLResponse(w, http.StatusInternalServerError, "Error writing object info: "+err.Error())
+		return
+	}
+	WriteXMLResponse(w, http.StatusOK, "Object ACL updated successfully")
+}
+
+// aclAllows decides whether the canned ACLs of a bucket and object let an anonymous request
+// through. Bucket ACLs govern listing and writing objects, while reading an object depends
+// on the ACL of the object itself.
+func aclAllows(r *http.Request, directoryPath, bucketName, objectKey, action string) bool {
+	switch action {
+	case "s3:ListBucket", "s3:ListBucketVersions", "s3:ListBucketMultipartUploads":
+		localBucket, err := services.ReadBucketInfo(directoryPath, bucketName)
+		return err == nil && services.ACLAllowsPublicRead(localBucket.ACL)
+	case "s3:PutObject", "s3:DeleteObject", "s3:DeleteObjectVersion", "s3:AbortMultipartUpload", "s3:ListMultipartUploadParts":
+		localBucket, err := services.ReadBucketInfo(directoryPath, bucketName)
+		return err == nil && services.ACLAllowsPublicWrite(localBucket.ACL)
+	case "s3:GetObject":
+		localObject, err := services.ReadObjectInfo(directoryPath, bucketName, objectKey)
+		return err == nil && services.ACLAllowsPublicRead(localObject.ACL)
+	case "s3:GetObjectVersion":
+		version, _, err := services.ReadObjectVersion(directoryPath, bucketName, objectKey, storedVersionID(r.URL.Query().Get("versionId")))
+		return err == nil && services.ACLAllowsPublicRead(version.Object.ACL)
+	}
+	return false
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+
+	"triple-s/internal/models"
+	"triple-s/internal/services"
+)
+
+// grantsBody is an AccessControlPolicy document granting permissions to predefined groups,
+// given as group URI and permission pairs
+func grantsBody(pairs ...string) string {
+	policy := models.AccessControlPolicy{Owner: defaultOwner}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		policy.Grants = append(policy.Grants, models.Grant{Grantee: models.Grantee{URI: pairs[i]}, Permission: pairs[i+1]})
+	}
+	data, _ := xml.Marshal(policy)
+	return string(data)
+}
+
+// grantNames renders the grants of an ACL as "grantee:permission" pairs, naming groups by the
+// last element of their URI
+func grantNames(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var policy models.AccessControlPolicy
+	if err := xml.Unmarshal(w.Body.Bytes(), &policy); err != nil {
+		t.Fatalf("decoding ACL %s: %v", w.Body, err)
+	}
+	var names []string
+	for _, grant := range policy.Grants {
+		grantee := grant.Grantee.ID
+		if grant.Grantee.URI != "" {
+			grantee = path.Base(grant.Grantee.URI)
+		}
+		names = append(names, grantee+":"+grant.Permission)
+	}
+	return strings.Join(names, " ")
+}
+
+func TestBucketACL(t *testing.T) {
+	dir := newDataDir(t)
+	createBucket(t, dir, "shared")
+
+	// Each step changes or reads the ACL of the bucket, in order
+	tests := []struct {
+		name    string
+		handler func(http.ResponseWriter, *http.Request, string)
+		method  string
+		bucket  string
+		acl     string
+		body    string
+		want    int
+		grants  string
+	}{
+		{"private by default", HandleGetBucketACL, http.MethodGet, "shared", "", "", http.StatusOK, "triple-s:FULL_CONTROL"},
+		{"canned ACL header", HandlePutBucketACL, http.MethodPut, "shared", "public-read", "", http.StatusOK, ""},
+		{"public read", HandleGetBucketACL, http.MethodGet, "shared", "", "", http.StatusOK, "triple-s:FULL_CONTROL AllUsers:READ"},
+		{"grants of a canned ACL", HandlePutBucketACL, http.MethodPut, "shared", "", grantsBody(services.AllUsersGroup, "READ", services.AllUsersGroup, "WRITE"), http.StatusOK, ""},
+		{"public read and write", HandleGetBucketACL, http.MethodGet, "shared", "", "", http.StatusOK, "triple-s:FULL_CONTROL AllUsers:READ AllUsers:WRITE"},
+		{"authenticated read", HandlePutBucketACL, http.MethodPut, "shared", "", grantsBody(services.AuthenticatedUsersGroup, "READ"), http.StatusOK, ""},
+		{"authenticated users", HandleGetBucketACL, http.MethodGet, "shared", "", "", http.StatusOK, "triple-s:FULL_CONTROL AuthenticatedUsers:READ"},
+		{"grants of no canned ACL", HandlePutBucketACL, http.MethodPut, "shared", "", grantsBody(services.AllUsersGroup, "WRITE"), http.StatusBadRequest, ""},
+		{"unknown canned ACL", HandlePutBucketACL, http.MethodPut, "shared", "everyone", "", http.StatusBadRequest, ""},
+		{"malformed document", HandlePutBucketACL, http.MethodPut, "shared", "", "<AccessControlPolicy>", http.StatusBadRequest, ""},
+		{"back to private", HandlePutBucketACL, http.MethodPut, "shared", "private", "", http.StatusOK, ""},
+		{"private again", HandleGetBucketACL, http.MethodGet, "shared", "", "", http.StatusOK, "triple-s:FULL_CONTROL"},
+		{"missing bucket", HandleGetBucketACL, http.MethodGet, "missing", "", "", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, "/"+test.bucket+"?acl", strings.NewReader(test.body))
+		if test.acl != "" {
+			r.Header.Set("x-amz-acl", test.acl)
+		}
+		w := httptest.NewRecorder()
+		test.handler(w, r, dir)
+		if w.Code != test.want {
+			t.Errorf("%s: %d, want %d: %s", test.name, w.Code, test.want, w.Body)
+			continue
+		}
+		if test.grants != "" {
+			if got := grantNames(t, w); got != test.grants {
+				t.Errorf("%s: grants %q, want %q", test.name, got, test.grants)
+			}
+		}
+	}
+}
+
+func TestObjectACL(t *testing.T) {
+	dir := newDataDir(t)
+	createBucket(t, dir, "shared")
+	if w := putObjectWith(dir, "shared", "photo.jpg", "data", http.Header{"X-Amz-Acl": {"public-read"}}); w.Code != http.StatusOK {
+		t.Fatalf("PUT with a canned ACL: %d %s", w.Code, w.Body)
+	}
+	if w := putObjectWith(dir, "shared", "bad", "data", http.Header{"X-Amz-Acl": {"everyone"}}); w.Code != http.StatusBadRequest {
+		t.Errorf("PUT with an unknown canned ACL: %d", w.Code)
+	}
+
+	// Each step changes or reads the ACL of the object, in order
+	tests := []struct {
+		name    string
+		handler objectHandlerFunc
+		method  string
+		key     string
+		body    string
+		want    int
+		grants  string
+	}{
+		{"ACL set on upload", HandlerGetObjectACL, http.MethodGet, "photo.jpg", "", http.StatusOK, "triple-s:FULL_CONTROL AllUsers:READ"},
+		{"make private", HandlerPutObjectACL, http.MethodPut, "photo.jpg", grantsBody(), http.StatusOK, ""},
+		{"private", HandlerGetObjectACL, http.MethodGet, "photo.jpg", "", http.StatusOK, "triple-s:FULL_CONTROL"},
+		{"grants of no canned ACL", HandlerPutObjectACL, http.MethodPut, "photo.jpg", grantsBody(services.AuthenticatedUsersGroup, "WRITE"), http.StatusBadRequest, ""},
+		{"missing object", HandlerGetObjectACL, http.MethodGet, "missing.jpg", "", http.StatusNotFound, ""},
+		{"set on a missing object", HandlerPutObjectACL, http.MethodPut, "missing.jpg", grantsBody(), http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		w := callObjectHandler(test.handler, dir, test.method, "shared", test.key, "acl", test.body)
+		if w.Code != test.want {
+			t.Errorf("%s: %d, want %d: %s", test.name, w.Code, test.want, w.Body)
+			continue
+		}
+		if test.grants != "" {
+			if got := grantNames(t, w); got != test.grants {
+				t.Errorf("%s: grants %q, want %q", test.name, got, test.grants)
+			}
+		}
+	}
+	if w := getObject(dir, "shared", "photo.jpg", nil); w.Code != http.StatusOK || w.Body.String() != "data" {
+		t.Errorf("object changed by its ACL: %d %q", w.Code, w.Body)
+	}
+}
+
+func TestACLPublicAccess(t *testing.T) {
+	dir := newDataDir(t)
+	for bucketName, acl := range map[string]string{"private": "", "readable": "public-read", "writable": "public-read-write"} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPut, "/"+bucketName, nil)
+		r.Header.Set("x-amz-acl", acl)
+		HandlePutBuckets(w, r, dir)
+		if w.Code != http.StatusOK {
+			t.Fatalf("creating %s: %d %s", bucketName, w.Code, w.Body)
+		}
+	}
+	putObject(t, dir, "private", "secret.txt", "data")
+	putObjectWith(dir, "readable", "photo.jpg", "data", http.Header{"X-Amz-Acl": {"public-read"}})
+	putObjectWith(dir, "readable", "draft.jpg", "data", http.Header{"X-Amz-Acl": {"authenticated-read"}})
+
+	// Requests are unsigned while authentication is enforced, so only the ACLs can allow them
+	tests := []struct {
+		name    string
+		method  string
+		bucket  string
+		key     string
+		allowed bool
+	}{
+		{"list a private bucket", http.MethodGet, "private", "", false},
+		{"read a private object", http.MethodGet, "private", "secret.txt", false},
+		{"list a public bucket", http.MethodGet, "readable", "", true},
+		{"read a public object", http.MethodGet, "readable", "photo.jpg", true},
+		{"read an object for authenticated users", http.MethodGet, "readable", "draft.jpg", false},
+		{"read a missing object", http.MethodGet, "readable", "missing.jpg", false},
+		{"write to a readable bucket", http.MethodPut, "readable", "new.jpg", false},
+		{"write to a writable bucket", http.MethodPut, "writable", "new.jpg", true},
+		{"delete from a writable bucket", http.MethodDelete, "writable", "new.jpg", true},
+		{"change the ACL of a writable bucket", http.MethodPut, "writable", "", false},
+		{"delete a public bucket", http.MethodDelete, "readable", "", false},
+	}
+	for _, test := range tests {
+		target := "/" + test.bucket
+		if test.key != "" {
+			target += "/" + test.key
+		} else if test.method == http.MethodPut {
+			target += "?acl"
+		}
+		r := withIdentity(httptest.NewRequest(test.method, target, nil), Identity{AuthEnforced: true})
+		if got := Authorize(httptest.NewRecorder(), r, dir, test.bucket, test.key); got != test.allowed {
+			t.Errorf("%s: allowed %v, want %v", test.name, got, test.allowed)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"net/http"
+
+	"triple-s/internal/models"
+	"triple-s/internal/services"
+)
+
+// HandleListUsers handles GET /users requests on the admin API
+func HandleListUsers(w http.ResponseWriter, r *http.Request, directoryPath string) {
+	users, err := services.ListUsers(directoryPath)
+	if err != nil {
+		writeErrorResponse(w, "Error reading users", http.StatusInternalServerError)
+		return
+	}
+	writeXMLResult(w, models.ListUsersResult{Users: users})
+}
+
+// HandleCreateUser handles PUT /users/{user} requests on the admin API
+func HandleCreateUser(w http.ResponseWriter, r *http.Request, directoryPath, userName string) {
+	if !ValidateUserName(userName) {
+		writeErrorResponse(w, "User names must be 1-64 letters, digits or +=,.@_- characters", http.StatusBadRequest)
+		return
+	}
+
+	user, err := services.CreateUser(directoryPath, userName)
+	if err == services.ErrUserExists {
+		writeErrorResponse(w, "User already exists", http.StatusConflict)
+		return
+	} else if err != nil {
+		writeErrorResponse(w, "Error writing users", http.StatusInternalServerError)
+		return
+	}
+	writeXMLResult(w, user)
+}
+
+// HandleDeleteUser handles DELETE /users/{user} requests on the admin API, removing the
+// user and every access key attached to it
+func HandleDeleteUser(w http.ResponseWriter, r *http.Request, directoryPath, userName string) {
+	err := services.DeleteUser(directoryPath, userName)
+	if err == services.ErrUserNotFound {
+		writeErrorResponse(w, "User does not exist", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeErrorResponse(w, "Error writing users", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleListAccessKeys handles GET /keys and GET /users/{user}/keys requests on the admin API.
+// Secrets are never included.
+func HandleListAccessKeys(w http.ResponseWriter, r *http.Request, directoryPath, userName string) {
+	if userName != "" {
+		if _, err := services.ReadUser(directoryPath, userName); err == services.ErrUserNotFound {
+			writeErrorResponse(w, "User does not exist", http.StatusNotFound)
+			return
+		}
+	}
+
+	keys, err := services.ListAccessKeys(directoryPath, userName)
+	if err != nil {
+		writeErrorResponse(w, "Error reading access keys", http.StatusInternalServerError)
+		return
+	}
+	writeXMLResult(w, models.ListAccessKeysResult{AccessKeys: keys})
+}
+
+// HandleCreateAccessKey handles POST /users/{user}/keys requests on the admin API. The
+// response is the only time the secret of the new key is returned.
+func HandleCreateAccessKey(w http.ResponseWriter, r *http.Request, directoryPath, userName string) {
+	key, err := services.CreateAccessKey(directoryPath, userName)
+	if err == services.ErrUserNotFound {
+		writeErrorResponse(w, "User does not exist", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeErrorResponse(w, "Error writing access keys", http.StatusInternalServerError)
+		return
+	}
+	writeXMLResult(w, key)
+}
+
+// HandleUpdateAccessKey handles POST /keys/{id}?enable, ?disable and ?rotate requests on the
+// admin API. Rotation disables the key and returns a replacement with its secret.
+func HandleUpdateAccessKey(w http.ResponseWriter, r *http.Request, directoryPath, accessKeyID string) {
+	query := r.URL.Query()
+
+	var key models.AccessKey
+	var err error
+	switch {
+	case query.Has("enable"):
+		key, err = services.SetAccessKeyStatus(directoryPath, accessKeyID, services.AccessKeyActive)
+	case query.Has("disable"):
+		key, err = services.SetAccessKeyStatus(directoryPath, accessKeyID, services.AccessKeyInactive)
+	case query.Has("rotate"):
+		key, err = services.RotateAccessKey(directoryPath, accessKeyID)
+	default:
+		writeErrorResponse(w, "Expected one of the enable, disable or rotate parameters", http.StatusBadRequest)
+		return
+	}
+
+	if err == services.ErrAccessKeyNotFound {
+		writeErrorResponse(w, "Access key does not exist", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeErrorResponse(w, "Error writing access keys", http.StatusInternalServerError)
+		return
+	}
+	writeXMLResult(w, key)
+}
+
+// HandleDeleteAccessKey handles DELETE /keys/{id} requests on the admin API
+func HandleDeleteAccessKey(w http.ResponseWriter, r *http.Request, directoryPath, accessKeyID string) {
+	err := services.DeleteAccessKey(directoryPath, accessKeyID)
+	if err == services.ErrAccessKeyNotFound {
+		writeErrorResponse(w, "Access key does not exist", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeErrorResponse(w, "Error writing access keys", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
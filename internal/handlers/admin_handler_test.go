@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"triple-s/internal/models"
+	"triple-s/internal/services"
+)
+
+// adminHandlerFunc is the signature shared by the admin handlers naming a user or an access key
+type adminHandlerFunc func(http.ResponseWriter, *http.Request, string, string)
+
+// callAdminHandler sends an admin API request naming a user or an access key to a handler
+func callAdminHandler(handler adminHandlerFunc, dir, method, target, name string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(method, target, nil), dir, name)
+	return w
+}
+
+// createAccessKey creates an access key for a user through the admin API
+func createAccessKey(t *testing.T, dir, userName string) models.AccessKey {
+	t.Helper()
+	w := callAdminHandler(HandleCreateAccessKey, dir, http.MethodPost, "/users/"+userName+"/keys", userName)
+	var key models.AccessKey
+	if w.Code != http.StatusOK || xml.Unmarshal(w.Body.Bytes(), &key) != nil || key.SecretAccessKey == "" {
+		t.Fatalf("creating an access key for %s: %d %s", userName, w.Code, w.Body)
+	}
+	return key
+}
+
+// signedStatus returns the status of a request signed with an access key
+func signedStatus(dir string, key models.AccessKey) int {
+	r := httptest.NewRequest(http.MethodGet, "/photos/cat.jpg", nil)
+	signRequest(r, key.AccessKeyID, key.SecretAccessKey, services.UnsignedPayload, time.Now())
+	w := httptest.NewRecorder()
+	Authenticate(dir, identityHandler).ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestAdminUsers(t *testing.T) {
+	dir := newDataDir(t)
+
+	// Each step changes or reads the users, in order
+	tests := []struct {
+		name    string
+		handler adminHandlerFunc
+		method  string
+		user    string
+		want    int
+	}{
+		{"create a user", HandleCreateUser, http.MethodPut, "alice", http.StatusOK},
+		{"create the user again", HandleCreateUser, http.MethodPut, "alice", http.StatusConflict},
+		{"create a second user", HandleCreateUser, http.MethodPut, "bob", http.StatusOK},
+		{"invalid name", HandleCreateUser, http.MethodPut, "bad/name", http.StatusBadRequest},
+		{"keys of a user", HandleListAccessKeys, http.MethodGet, "alice", http.StatusOK},
+		{"keys of a missing user", HandleListAccessKeys, http.MethodGet, "carol", http.StatusNotFound},
+		{"key for a missing user", HandleCreateAccessKey, http.MethodPost, "carol", http.StatusNotFound},
+		{"delete a user", HandleDeleteUser, http.MethodDelete, "bob", http.StatusNoContent},
+		{"delete the user again", HandleDeleteUser, http.MethodDelete, "bob", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		if w := callAdminHandler(test.handler, dir, test.method, "/users/"+test.user, test.user); w.Code != test.want {
+			t.Errorf("%s: %d, want %d: %s", test.name, w.Code, test.want, w.Body)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	HandleListUsers(w, httptest.NewRequest(http.MethodGet, "/users", nil), dir)
+	var result models.ListUsersResult
+	if w.Code != http.StatusOK || xml.Unmarshal(w.Body.Bytes(), &result) != nil || len(result.Users) != 1 || result.Users[0].Name != "alice" {
+		t.Errorf("listing users: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestAdminAccessKeys(t *testing.T) {
+	dir := newDataDir(t)
+	for _, userName := range []string{"alice", "bob"} {
+		if w := callAdminHandler(HandleCreateUser, dir, http.MethodPut, "/users/"+userName, userName); w.Code != http.StatusOK {
+			t.Fatalf("creating %s: %d %s", userName, w.Code, w.Body)
+		}
+	}
+	key := createAccessKey(t, dir, "alice")
+	other := createAccessKey(t, dir, "bob")
+	if signedStatus(dir, key) != http.StatusOK {
+		t.Fatal("a new access key cannot sign requests")
+	}
+
+	// Each step updates the key and checks whether it still signs requests, in order
+	tests := []struct {
+		name   string
+		query  string
+		id     string
+		want   int
+		status string
+		signs  bool
+	}{
+		{"disable", "disable", key.AccessKeyID, http.StatusOK, services.AccessKeyInactive, false},
+		{"enable", "enable", key.AccessKeyID, http.StatusOK, services.AccessKeyActive, true},
+		{"no action", "", key.AccessKeyID, http.StatusBadRequest, "", true},
+		{"unknown key", "disable", "AKIAUNKNOWN", http.StatusNotFound, "", true},
+		{"rotate", "rotate", key.AccessKeyID, http.StatusOK, services.AccessKeyActive, false},
+	}
+	var rotated models.AccessKey
+	for _, test := range tests {
+		w := callAdminHandler(HandleUpdateAccessKey, dir, http.MethodPost, "/keys/"+test.id+"?"+test.query, test.id)
+		if w.Code != test.want {
+			t.Errorf("%s: %d, want %d: %s", test.name, w.Code, test.want, w.Body)
+			continue
+		}
+		if w.Code == http.StatusOK {
+			var updated models.AccessKey
+			if err := xml.Unmarshal(w.Body.Bytes(), &updated); err != nil || updated.Status != test.status {
+				t.Errorf("%s: %s, want status %s", test.name, w.Body, test.status)
+			}
+			if test.query == "rotate" {
+				rotated = updated
+			}
+		}
+		if signs := signedStatus(dir, key) == http.StatusOK; signs != test.signs {
+			t.Errorf("%s: key signs requests %v, want %v", test.name, signs, test.signs)
+		}
+	}
+	if rotated.AccessKeyID == key.AccessKeyID || signedStatus(dir, rotated) != http.StatusOK {
+		t.Errorf("replacement key %q does not sign requests", rotated.AccessKeyID)
+	}
+
+	// Listings never include secrets
+	w := callAdminHandler(HandleListAccessKeys, dir, http.MethodGet, "/users/alice/keys", "alice")
+	var result models.ListAccessKeysResult
+	if w.Code != http.StatusOK || xml.Unmarshal(w.Body.Bytes(), &result) != nil || len(result.AccessKeys) != 2 {
+		t.Fatalf("listing the keys of alice: %d %s", w.Code, w.Body)
+	}
+	for _, listed := range result.AccessKeys {
+		if listed.SecretAccessKey != "" || listed.UserName != "alice" {
+			t.Errorf("listed key %+v", listed)
+		}
+	}
+
+	// Deleting a user removes its keys, leaving the keys of other users
+	if w := callAdminHandler(HandleDeleteUser, dir, http.MethodDelete, "/users/alice", "alice"); w.Code != http.StatusNoContent {
+		t.Fatalf("deleting alice: %d %s", w.Code, w.Body)
+	}
+	if signedStatus(dir, rotated) != http.StatusForbidden || signedStatus(dir, other) != http.StatusOK {
+		t.Error("deleting a user did not remove exactly its keys")
+	}
+	if w := callAdminHandler(HandleDeleteAccessKey, dir, http.MethodDelete, "/keys/"+other.AccessKeyID, other.AccessKeyID); w.Code != http.StatusNoContent {
+		t.Errorf("deleting a key: %d %s", w.Code, w.Body)
+	}
+	if w := callAdminHandler(HandleDeleteAccessKey, dir, http.MethodDelete, "/keys/"+other.AccessKeyID, other.AccessKeyID); w.Code != http.StatusNotFound {
+		t.Errorf("deleting a key again: %d %s", w.Code, w.Body)
+	}
+	if signedStatus(dir, other) != http.StatusForbidden {
+		t.Error("a deleted key still signs requests")
+	}
+}
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"context"
+	"encoding/xml"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"triple-s/internal/services"
+)
+
+// S3Error is the error document of the Amazon S3 API, which SDKs decode to report
+// authentication failures
+type S3Error struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string   `xml:"Code"`
+	Message  string   `xml:"Message"`
+	Resource string   `xml:"Resource,omitempty"`
+}
+
+// writeS3Error writes an S3 error document with the given status, code and message
+func writeS3Error(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	xmlData, err := xml.MarshalIndent(S3Error{Code: code, Message: message, Resource: r.URL.Path}, "", "  ")
+	if err != nil {
+		log.Println("Error generating XML response:", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header))
+	w.Write(xmlData)
+}
+
+// writeAuthError reports a failed signature verification with the matching S3 error code
+func writeAuthError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case services.ErrAccessKeyNotFound:
+		writeS3Error(w, r, http.StatusForbidden, "InvalidAccessKeyId", "The AWS access key Id you provided does not exist in our records.")
+	case services.ErrSignatureMismatch:
+		writeS3Error(w, r, http.StatusForbidden, "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided. Check your key and signing method.")
+	case services.ErrRequestTimeSkewed:
+		writeS3Error(w, r, http.StatusForbidden, "RequestTimeTooSkewed", "The difference between the request time and the server's time is too large.")
+	case services.ErrMissingContentSHA256:
+		writeS3Error(w, r, http.StatusBadRequest, "InvalidRequest", "Missing required header for this request: x-amz-content-sha256")
+	case services.ErrContentSHA256Mismatch:
+		writeS3Error(w, r, http.StatusBadRequest, "XAmzContentSHA256Mismatch", "The provided 'x-amz-content-sha256' header does not match what was computed.")
+	case services.ErrMalformedChunk:
+		writeS3Error(w, r, http.StatusBadRequest, "IncompleteBody", "The aws-chunked request body could not be decoded.")
+	case services.ErrMalformedAuthorization:
+		writeS3Error(w, r, http.StatusBadRequest, "AuthorizationHeaderMalformed", "The authorization header is malformed.")
+	case services.ErrMalformedQueryAuth:
+		writeS3Error(w, r, http.StatusBadRequest, "AuthorizationQueryParametersError", "The X-Amz-* query parameters of the presigned URL are malformed.")
+	case services.ErrRequestExpired:
+		writeS3Error(w, r, http.StatusForbidden, "AccessDenied", "Request has expired")
+	default:
+		log.Println("Error verifying request signature:", err)
+		writeS3Error(w, r, http.StatusInternalServerError, "InternalError", "We encountered an internal error. Please try again.")
+	}
+}
+
+// Identity is the requester established by Authenticate. Unsigned requests have an empty
+// access key ID and are only allowed what bucket policies grant to everyone while
+// authentication is enforced.
+type Identity struct {
+	AccessKeyID  string
+	UserName     string
+	AuthEnforced bool
+}
+
+// identityContextKey is the request context key holding the Identity of the requester
+type identityContextKey struct{}
+
+// Anonymous reports whether the request was not signed
+func (identity Identity) Anonymous() bool {
+	return identity.AccessKeyID == ""
+}
+
+// RequestIdentity returns the requester of a request passed through Authenticate
+func RequestIdentity(r *http.Request) Identity {
+	identity, _ := r.Context().Value(identityContextKey{}).(Identity)
+	return identity
+}
+
+// withIdentity passes the requester on to the wrapped handler
+func withIdentity(r *http.Request, identity Identity) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), identityContextKey{}, identity))
+}
+
+// Authenticate wraps a handler with Signature Version 4 verification when authentication is
+// enforced, see services.AuthEnabled. Unsigned requests are passed on as anonymous so that
+// bucket policies can decide whether to allow them.
+func Authenticate(directoryPath string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !services.AuthEnabled(directoryPath) {
+			next.ServeHTTP(w, withIdentity(r, Identity{}))
+			return
+		}
+
+		header := r.Header.Get("Authorization")
+		if header == "" && r.URL.Query().Has("X-Amz-Signature") {
+			authenticatePresigned(w, r, directoryPath, next)
+			return
+		}
+		if header == "" {
+			next.ServeHTTP(w, withIdentity(r, Identity{AuthEnforced: true}))
+			return
+		}
+		if !strings.HasPrefix(header, services.SigningAlgorithm+" ") {
+			writeS3Error(w, r, http.StatusBadRequest, "InvalidArgument", "Only the AWS4-HMAC-SHA256 signature algorithm is supported")
+			return
+		}
+
+		auth, err := services.ParseAuthorization(header)
+		if err != nil {
+			writeAuthError(w, r, err)
+			return
+		}
+		key, err := services.LookupAccessKey(directoryPath, auth.AccessKeyID)
+		if err != nil {
+			writeAuthError(w, r, err)
+			return
+		}
+		if err := services.VerifyRequest(r, auth, key.SecretAccessKey, time.Now()); err != nil {
+			writeAuthError(w, r, err)
+			return
+		}
+
+		next.ServeHTTP(w, withIdentity(r, Identity{AccessKeyID: key.AccessKeyID, UserName: key.UserName, AuthEnforced: true}))
+	})
+}
+
+// authenticatePresigned verifies a request carrying its signature in presigned URL query
+// parameters, which are accepted for reading, writing and deleting single objects
+func authenticatePresigned(w http.ResponseWriter, r *http.Request, directoryPath string, next http.Handler) {
+	_, objectKey, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	switch r.Method {
+	case http.MethodGet, http.MethodPut, http.MethodHead, http.MethodDelete:
+	default:
+		objectKey = ""
+	}
+	if objectKey == "" {
+		writeS3Error(w, r, http.StatusForbidden, "AccessDenied", "Presigned URLs are only accepted for object GET, PUT, HEAD and DELETE requests")
+		return
+	}
+
+	auth, err := services.ParsePresignedQuery(r.URL.Query())
+	if err != nil {
+		writeAuthError(w, r, err)
+		return
+	}
+	key, err := services.LookupAccessKey(directoryPath, auth.AccessKeyID)
+	if err != nil {
+		writeAuthError(w, r, err)
+		return
+	}
+	if err := services.VerifyPresignedRequest(r, auth, key.SecretAccessKey, time.Now()); err != nil {
+		writeAuthError(w, r, err)
+		return
+	}
+
+	next.ServeHTTP(w, withIdentity(r, Identity{AccessKeyID: key.AccessKeyID, UserName: key.UserName, AuthEnforced: true}))
+}
+
+// bodyVerificationFailed reports a request body that failed its signature or digest check
+// while being read. It writes the error response and returns true in that case.
+func bodyVerificationFailed(w http.ResponseWriter, r *http.Request, err error) bool {
+	switch err {
+	case services.ErrContentSHA256Mismatch, services.ErrSignatureMismatch, services.ErrMalformedChunk:
+		writeAuthError(w, r, err)
+		return true
+	}
+	return false
+}
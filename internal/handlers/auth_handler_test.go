@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"triple-s/internal/services"
+)
+
+// newCredentialsDir returns a data directory whose credentials file enforces authentication,
+// holding an active key AKIAEXAMPLE and a disabled key AKIADISABLED, both with secret "secret"
+func newCredentialsDir(t *testing.T) string {
+	t.Helper()
+	dir := newDataDir(t)
+	credentials := "AKIAEXAMPLE,secret\nAKIADISABLED,secret,inactive\n"
+	if err := os.WriteFile(dir+services.CredentialsFileName, []byte(credentials), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// signRequest signs a request with Signature Version 4 in its Authorization header
+func signRequest(r *http.Request, accessKeyID, secret, payloadHash string, now time.Time) {
+	now = now.UTC()
+	date := now.Format("20060102")
+	scope := date + "/us-east-1/s3/aws4_request"
+	r.Header.Set("X-Amz-Date", now.Format(services.AmzDateFormat))
+	r.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	stringToSign := services.StringToSign(now.Format(services.AmzDateFormat), scope, services.CanonicalRequest(r, signedHeaders, payloadHash))
+	signature := services.Sign(services.SigningKey(secret, date, "us-east-1", "s3"), stringToSign)
+	r.Header.Set("Authorization", services.SigningAlgorithm+" Credential="+accessKeyID+"/"+scope+
+		", SignedHeaders="+strings.Join(signedHeaders, ";")+", Signature="+signature)
+}
+
+// payloadSHA256 is the hex digest declared in x-amz-content-sha256 for a body
+func payloadSHA256(body string) string {
+	digest := sha256.Sum256([]byte(body))
+	return hex.EncodeToString(digest[:])
+}
+
+// identityHandler reads the request body and reports the requester it was passed, as
+// "anonymous" or the access key ID, with a trailing "!" when authentication is enforced
+var identityHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	if _, err := io.ReadAll(r.Body); bodyVerificationFailed(w, r, err) {
+		return
+	}
+	identity := RequestIdentity(r)
+	name := identity.AccessKeyID
+	if identity.Anonymous() {
+		name = "anonymous"
+	}
+	if identity.AuthEnforced {
+		name += "!"
+	}
+	w.Write([]byte(name))
+})
+
+func TestAuthenticate(t *testing.T) {
+	dir := newCredentialsDir(t)
+	now := time.Now()
+
+	tests := []struct {
+		name string
+		// sign signs the request, or leaves it unsigned when nil
+		sign     func(r *http.Request)
+		body     string
+		want     int
+		code     string
+		identity string
+	}{
+		{"unsigned", nil, "", http.StatusOK, "", "anonymous!"},
+		{"signed", func(r *http.Request) { signRequest(r, "AKIAEXAMPLE", "secret", services.UnsignedPayload, now) }, "data", http.StatusOK, "", "AKIAEXAMPLE!"},
+		{"signed body", func(r *http.Request) { signRequest(r, "AKIAEXAMPLE", "secret", payloadSHA256("data"), now) }, "data", http.StatusOK, "", "AKIAEXAMPLE!"},
+		{"wrong secret", func(r *http.Request) { signRequest(r, "AKIAEXAMPLE", "guessed", services.UnsignedPayload, now) }, "", http.StatusForbidden, "SignatureDoesNotMatch", ""},
+		{"unknown key", func(r *http.Request) { signRequest(r, "AKIAUNKNOWN", "secret", services.UnsignedPayload, now) }, "", http.StatusForbidden, "InvalidAccessKeyId", ""},
+		{"disabled key", func(r *http.Request) { signRequest(r, "AKIADISABLED", "secret", services.UnsignedPayload, now) }, "", http.StatusForbidden, "InvalidAccessKeyId", ""},
+		{"skewed clock", func(r *http.Request) {
+			signRequest(r, "AKIAEXAMPLE", "secret", services.UnsignedPayload, now.Add(-time.Hour))
+		}, "", http.StatusForbidden, "RequestTimeTooSkewed", ""},
+		{"tampered header", func(r *http.Request) {
+			signRequest(r, "AKIAEXAMPLE", "secret", services.UnsignedPayload, now)
+			r.Header.Set("X-Amz-Content-Sha256", payloadSHA256(""))
+		}, "", http.StatusForbidden, "SignatureDoesNotMatch", ""},
+		{"body not matching its digest", func(r *http.Request) { signRequest(r, "AKIAEXAMPLE", "secret", payloadSHA256("other"), now) }, "data", http.StatusBadRequest, "XAmzContentSHA256Mismatch", ""},
+		{"missing digest", func(r *http.Request) {
+			signRequest(r, "AKIAEXAMPLE", "secret", services.UnsignedPayload, now)
+			r.Header.Del("X-Amz-Content-Sha256")
+		}, "", http.StatusBadRequest, "InvalidRequest", ""},
+		{"other algorithm", func(r *http.Request) { r.Header.Set("Authorization", "AWS AKIAEXAMPLE:signature") }, "", http.StatusBadRequest, "InvalidArgument", ""},
+		{"malformed header", func(r *http.Request) {
+			r.Header.Set("Authorization", services.SigningAlgorithm+" Credential=AKIAEXAMPLE")
+		}, "", http.StatusBadRequest, "AuthorizationHeaderMalformed", ""},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPut, "/photos/cat.jpg", strings.NewReader(test.body))
+		if test.sign != nil {
+			test.sign(r)
+		}
+		w := httptest.NewRecorder()
+		Authenticate(dir, identityHandler).ServeHTTP(w, r)
+		if w.Code != test.want {
+			t.Errorf("%s: %d, want %d: %s", test.name, w.Code, test.want, w.Body)
+			continue
+		}
+		if test.code == "" {
+			if w.Body.String() != test.identity {
+				t.Errorf("%s: identity %q, want %q", test.name, w.Body, test.identity)
+			}
+			continue
+		}
+		var s3Error S3Error
+		if err := xml.Unmarshal(w.Body.Bytes(), &s3Error); err != nil || s3Error.Code != test.code {
+			t.Errorf("%s: error %s, want %s", test.name, w.Body, test.code)
+		}
+	}
+
+	// Without a credentials file every request is anonymous and unchecked
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/photos/cat.jpg", nil)
+	signRequest(r, "AKIAEXAMPLE", "guessed", services.UnsignedPayload, now)
+	Authenticate(newDataDir(t), identityHandler).ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != "anonymous" {
+		t.Errorf("without credentials: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestAuthenticatePresigned(t *testing.T) {
+	dir := newCredentialsDir(t)
+	now := time.Now()
+
+	// presign returns a presigned URL for an object, signed at the given time
+	presign := func(method, objectKey, accessKeyID string, expires time.Duration, signed time.Time) string {
+		presigned, err := services.PresignURL("http://example.com", method, "photos", objectKey, accessKeyID, "secret", "us-east-1", expires, signed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return presigned
+	}
+	valid := presign(http.MethodGet, "cat.jpg", "AKIAEXAMPLE", time.Hour, now)
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		want   int
+		code   string
+	}{
+		{"valid", http.MethodGet, valid, http.StatusOK, ""},
+		{"valid upload", http.MethodPut, presign(http.MethodPut, "cat.jpg", "AKIAEXAMPLE", time.Hour, now), http.StatusOK, ""},
+		{"expired", http.MethodGet, presign(http.MethodGet, "cat.jpg", "AKIAEXAMPLE", time.Hour, now.Add(-2*time.Hour)), http.StatusForbidden, "AccessDenied"},
+		{"signed in the future", http.MethodGet, presign(http.MethodGet, "cat.jpg", "AKIAEXAMPLE", time.Hour, now.Add(time.Hour)), http.StatusForbidden, "RequestTimeTooSkewed"},
+		{"bad signature", http.MethodGet, strings.Replace(valid, "X-Amz-Signature=", "X-Amz-Signature=0", 1), http.StatusForbidden, "SignatureDoesNotMatch"},
+		{"other method", http.MethodDelete, valid, http.StatusForbidden, "SignatureDoesNotMatch"},
+		{"other object", http.MethodGet, strings.Replace(valid, "/cat.jpg?", "/dog.jpg?", 1), http.StatusForbidden, "SignatureDoesNotMatch"},
+		{"extended expiry", http.MethodGet, strings.Replace(valid, "X-Amz-Expires=3600", "X-Amz-Expires=7200", 1), http.StatusForbidden, "SignatureDoesNotMatch"},
+		{"expiry over seven days", http.MethodGet, strings.Replace(valid, "X-Amz-Expires=3600", "X-Amz-Expires=604801", 1), http.StatusBadRequest, "AuthorizationQueryParametersError"},
+		{"unknown key", http.MethodGet, presign(http.MethodGet, "cat.jpg", "AKIAUNKNOWN", time.Hour, now), http.StatusForbidden, "InvalidAccessKeyId"},
+		{"bucket request", http.MethodGet, strings.Replace(valid, "/photos/cat.jpg?", "/photos?", 1), http.StatusForbidden, "AccessDenied"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		Authenticate(dir, identityHandler).ServeHTTP(w, httptest.NewRequest(test.method, test.url, nil))
+		if w.Code != test.want {
+			t.Errorf("%s: %d, want %d: %s", test.name, w.Code, test.want, w.Body)
+			continue
+		}
+		if test.code == "" {
+			if w.Body.String() != "AKIAEXAMPLE!" {
+				t.Errorf("%s: identity %q", test.name, w.Body)
+			}
+			continue
+		}
+		var s3Error S3Error
+		if err := xml.Unmarshal(w.Body.Bytes(), &s3Error); err != nil || s3Error.Code != test.code {
+			t.Errorf("%s: error %s, want %s", test.name, w.Body, test.code)
+		}
+	}
+}
@@ -1,8 +1,6 @@
 package handlers
 
 import (
-	"encoding/base64"
-	"encoding/csv"
 	"encoding/xml"
 	"log"
 	"net/http"
@@ -10,7 +8,6 @@ import (
 	"strings"
 	"time"
 
-	"triple-s/internal/models"
 	"triple-s/internal/services"
 )
 
@@ -50,6 +47,11 @@ func HandlePutBuckets(w http.ResponseWriter, r *http.Request, directoryPath stri
 		writeErrorResponse(w, "Not a valid bucket name", http.StatusBadRequest)
 		return
 	}
+	acl := r.Header.Get("x-amz-acl")
+	if !services.ValidCannedACL(acl) {
+		writeErrorResponse(w, "Unsupported canned ACL in x-amz-acl header", http.StatusBadRequest)
+		return
+	}
 
 	// Call service to create the bucket and handle errors
 	if err := services.BucketAndFileCreation(directoryPath + bucketName); err != nil {
@@ -58,7 +60,7 @@ func HandlePutBuckets(w http.ResponseWriter, r *http.Request, directoryPath stri
 	}
 
 	// Write bucket info and return XML response, handle errors
-	xmlData, err := services.WriteBucketInfo(bucketName, directoryPath)
+	xmlData, err := services.WriteBucketInfo(bucketName, directoryPath, services.StoredACL(acl))
 	if err != nil {
 		writeErrorResponse(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -74,32 +76,19 @@ func HandlePutBuckets(w http.ResponseWriter, r *http.Request, directoryPath stri
 func HandleDeleteBuckets(w http.ResponseWriter, r *http.Request, directoryPath string) {
 	// Extract bucket name from the URL path
 	bucketName := strings.TrimPrefix(r.URL.Path, "/")
-	if bucketName == "buckets.csv" {
-		w.Write([]byte("Can not delete metadata file"))
-		return
-	}
 	if bucketName == "" {
 		writeErrorResponse(w, "Empty bucket name", http.StatusBadRequest)
 		return
 	}
-
-	// Open the CSV file to read bucket data
-	file, err := os.Open(directoryPath + "buckets.csv")
-	if err != nil {
-		writeErrorResponse(w, "Error reading bucket file", http.StatusInternalServerError)
-		log.Println("Error reading CSV:", err)
+	// Names outside the bucket rules, such as the metadata files and the internal directory
+	// holding the journal, never name a bucket
+	if !ValidateBucketName(bucketName) {
+		writeErrorResponse(w, "Not a valid bucket name", http.StatusBadRequest)
 		return
 	}
-	defer file.Close()
 
-	// Read CSV records
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
-	if err != nil {
-		writeErrorResponse(w, "Error reading CSV data", http.StatusInternalServerError)
-		log.Println("Error reading CSV:", err)
-		return
-	}
+	// Wait for the objects being changed and keep new changes out while removing the bucket
+	defer services.LockBucket(directoryPath, bucketName)()
 
 	// Check if the bucket exists by looking at the directory
 	fileInfo, err := os.Stat(directoryPath + bucketName)
@@ -107,18 +96,7 @@ func HandleDeleteBuckets(w http.ResponseWriter, r *http.Request, directoryPath s
 		writeErrorResponse(w, "Bucket does not exist", http.StatusNotFound)
 		return
 	}
-
-	// Encode modification time
-	encodedModTime := base64.StdEncoding.EncodeToString([]byte(fileInfo.ModTime().Format(time.RFC3339)))
-
-	// Update bucket status in the CSV records
-	for i, record := range records {
-		val, _ := base64.StdEncoding.DecodeString(record[0])
-		if string(val) == bucketName {
-			records[i][3] = "false" // Mark the bucket as inactive
-			records[i][2] = encodedModTime
-		}
-	}
+	modTime := fileInfo.ModTime().Format(time.RFC3339)
 
 	// Delete the bucket directory
 	err = os.RemoveAll(directoryPath + bucketName)
@@ -128,21 +106,10 @@ func HandleDeleteBuckets(w http.ResponseWriter, r *http.Request, directoryPath s
 		return
 	}
 
-	// Open the CSV file to write updated data
-	bucketFile, err := os.OpenFile(directoryPath+"buckets.csv", os.O_RDWR|os.O_TRUNC, 0o644)
-	if err != nil {
-		writeErrorResponse(w, "Error opening bucket file", http.StatusInternalServerError)
-		log.Println("Error opening file for writing:", err)
-		return
-	}
-	defer bucketFile.Close()
-
-	// Write updated records back to the CSV file
-	writer := csv.NewWriter(bucketFile)
-	err = writer.WriteAll(records)
-	if err != nil {
-		writeErrorResponse(w, "Error writing CSV data", http.StatusInternalServerError)
-		log.Println("Error writing CSV:", err)
+	// Mark the bucket as inactive
+	if err := services.Metadata(directoryPath).DeleteBucket(bucketName, modTime); err != nil {
+		writeErrorResponse(w, "Error writing bucket info", http.StatusInternalServerError)
+		log.Println("Error writing bucket info:", err)
 		return
 	}
 
@@ -159,59 +126,16 @@ func HandleGetBuckets(w http.ResponseWriter, r *http.Request, directoryPath stri
 		return
 	}
 
-	// Open the buckets.csv file
-	file, err := os.Open(directoryPath + "buckets.csv")
+	// Read every bucket record, including deleted buckets
+	buckets, err := services.Metadata(directoryPath).ListBuckets()
 	if err != nil {
-		writeErrorResponse(w, "No such bucket", http.StatusConflict)
-		return
-	}
-	defer file.Close()
-
-	// Read all records from the CSV
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
-	if err != nil {
-		writeErrorResponse(w, "Error reading CSV file", http.StatusInternalServerError)
+		writeErrorResponse(w, "Error reading bucket info", http.StatusInternalServerError)
 		return
 	}
 
 	// Set the response type to XML
 	w.Header().Set("Content-Type", "application/xml")
 
-	// Create a slice to hold the bucket models
-	var buckets []models.Bucket
-
-	// Iterate through each record and convert it into a Bucket model
-	for _, record := range records {
-		// Decode base64-encoded fields
-		name, err := base64.StdEncoding.DecodeString(record[0])
-		if err != nil {
-			writeErrorResponse(w, "Error decoding bucket name", http.StatusInternalServerError)
-			return
-		}
-		creationTime, err := base64.StdEncoding.DecodeString(record[1])
-		if err != nil {
-			writeErrorResponse(w, "Error decoding creation time", http.StatusInternalServerError)
-			return
-		}
-		lastModifiedTime, err := base64.StdEncoding.DecodeString(record[2])
-		if err != nil {
-			writeErrorResponse(w, "Error decoding last modified time", http.StatusInternalServerError)
-			return
-		}
-		status := record[3]
-
-		// Create a Bucket model
-		localBucket := models.Bucket{
-			Name:             string(name),
-			CreationTime:     string(creationTime),
-			LastModifiedTime: string(lastModifiedTime),
-			Status:           status,
-		}
-
-		buckets = append(buckets, localBucket)
-	}
-
 	// Marshal the slice of buckets into XML
 	xmlData, err := xml.MarshalIndent(buckets, "", "  ")
 	if err != nil {
@@ -224,3 +148,34 @@ func HandleGetBuckets(w http.ResponseWriter, r *http.Request, directoryPath stri
 	// Write XML data to the response
 	w.Write(xmlData)
 }
+
+// HandleHeadBuckets handles HEAD requests for checking that a bucket exists
+func HandleHeadBuckets(w http.ResponseWriter, r *http.Request, directoryPath string) {
+	// Extract bucket name from the URL path
+	bucketName := strings.TrimPrefix(r.URL.Path, "/")
+	if !ValidateBucketName(bucketName) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// The bucket must have both an active record and a directory
+	localBucket, err := services.ReadBucketInfo(directoryPath, bucketName)
+	if err == services.ErrBucketNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println("Error reading bucket info:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if _, err := os.Stat(directoryPath + bucketName); err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if modTime, err := time.Parse(time.RFC3339, localBucket.LastModifiedTime); err == nil {
+		w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+	w.Header().Set("x-amz-bucket-creation-time", localBucket.CreationTime)
+	w.WriteHeader(http.StatusOK)
+}
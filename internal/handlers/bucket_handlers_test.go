@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"triple-s/internal/services"
+)
+
+// newDataDir returns an empty data directory whose metadata store is open, as at startup
+func newDataDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir() + "/"
+	if err := services.OpenMetadata(dir); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// createBucket creates a bucket through the handler, failing the test if it is refused
+func createBucket(t *testing.T, dir, bucketName string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	HandlePutBuckets(w, httptest.NewRequest(http.MethodPut, "/"+bucketName, nil), dir)
+	if w.Code != http.StatusOK {
+		t.Fatalf("creating bucket %s: %d %s", bucketName, w.Code, w.Body)
+	}
+}
+
+func TestBucketNamesReservedForServerState(t *testing.T) {
+	dir := newDataDir(t)
+	createBucket(t, dir, "photos")
+	if err := os.WriteFile(dir+services.CredentialsFileName, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		want   int
+	}{
+		{http.MethodDelete, "/.triple-s", http.StatusBadRequest},
+		{http.MethodDelete, "/buckets.csv", http.StatusBadRequest},
+		{http.MethodDelete, "/credentials.csv", http.StatusBadRequest},
+		{http.MethodDelete, "/users.csv", http.StatusBadRequest},
+		{http.MethodHead, "/.triple-s", http.StatusBadRequest},
+		{http.MethodHead, "/credentials.csv", http.StatusBadRequest},
+		{http.MethodPut, "/buckets.log", http.StatusBadRequest},
+		{http.MethodHead, "/missing", http.StatusNotFound},
+		{http.MethodHead, "/photos", http.StatusOK},
+		{http.MethodDelete, "/photos", http.StatusOK},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(test.method, test.path, nil)
+		switch test.method {
+		case http.MethodDelete:
+			HandleDeleteBuckets(w, r, dir)
+		case http.MethodHead:
+			HandleHeadBuckets(w, r, dir)
+		case http.MethodPut:
+			HandlePutBuckets(w, r, dir)
+		}
+		if w.Code != test.want {
+			t.Errorf("%s %s: got %d, want %d", test.method, test.path, w.Code, test.want)
+		}
+	}
+
+	// The server state survives the requests aimed at it
+	for _, path := range []string{filepath.Join(dir, services.InternalDirName, "journal.log"), dir + services.CredentialsFileName} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("%s: %v", path, err)
+		}
+	}
+}
+
+func TestHeadBucket(t *testing.T) {
+	dir := newDataDir(t)
+	createBucket(t, dir, "photos")
+	createBucket(t, dir, "removed")
+	w := httptest.NewRecorder()
+	HandleDeleteBuckets(w, httptest.NewRequest(http.MethodDelete, "/removed", nil), dir)
+	if w.Code != http.StatusOK {
+		t.Fatalf("deleting bucket: %d %s", w.Code, w.Body)
+	}
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/photos", http.StatusOK},
+		{"/removed", http.StatusNotFound},
+		{"/missing", http.StatusNotFound},
+		{"/Invalid_Name", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		HandleHeadBuckets(w, httptest.NewRequest(http.MethodHead, test.path, nil), dir)
+		if w.Code != test.want {
+			t.Errorf("HEAD %s: %d, want %d", test.path, w.Code, test.want)
+		}
+		if w.Body.Len() != 0 {
+			t.Errorf("HEAD %s: %d bytes of body", test.path, w.Body.Len())
+		}
+		if w.Code == http.StatusOK && (w.Header().Get("Last-Modified") == "" || w.Header().Get("x-amz-bucket-creation-time") == "") {
+			t.Errorf("HEAD %s: missing bucket dates in %v", test.path, w.Header())
+		}
+	}
+}
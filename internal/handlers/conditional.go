@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"triple-s/internal/models"
+)
+
+// etagMatches reports whether the entity tag appears in an If-Match or If-None-Match header
+// value. Like RFC 9110, If-Match uses the strong comparison, which a weak tag never passes,
+// while If-None-Match uses the weak comparison, which ignores the W/ prefix.
+func etagMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if strings.Trim(candidate, `"`) == strings.Trim(etag, `"`) {
+			return true
+		}
+	}
+	return false
+}
+
+// modifiedSince reports whether the object changed after the time in the header.
+// Unparsable header values yield ok=false so that the condition is ignored.
+func modifiedSince(object models.Object, header string) (modified, ok bool) {
+	since, err := http.ParseTime(header)
+	if err != nil {
+		return false, false
+	}
+	modTime, err := time.Parse(time.RFC3339, object.LastModifiedTime)
+	if err != nil {
+		return false, false
+	}
+	return modTime.Truncate(time.Second).After(since), true
+}
+
+// checkReadPreconditions evaluates the conditional headers of a GET or HEAD request.
+// It returns 0 when the object should be served, 304 when the cached copy is still
+// valid and 412 when a precondition failed.
+func checkReadPreconditions(r *http.Request, object models.Object) int {
+	return evaluateReadConditions(r.Header.Get("If-Match"), r.Header.Get("If-None-Match"),
+		r.Header.Get("If-Modified-Since"), r.Header.Get("If-Unmodified-Since"), object)
+}
+
+// checkCopySourcePreconditions evaluates the x-amz-copy-source-if-* headers of a copy request
+// against the source object. It returns 0 when the copy may proceed and 412 otherwise.
+func checkCopySourcePreconditions(r *http.Request, source models.Object) int {
+	if evaluateReadConditions(r.Header.Get("x-amz-copy-source-if-match"), r.Header.Get("x-amz-copy-source-if-none-match"),
+		r.Header.Get("x-amz-copy-source-if-modified-since"), r.Header.Get("x-amz-copy-source-if-unmodified-since"), source) != 0 {
+		return http.StatusPreconditionFailed
+	}
+	return 0
+}
+
+// evaluateReadConditions applies the read precondition rules to the given header values
+func evaluateReadConditions(ifMatch, ifNoneMatch, ifModifiedSince, ifUnmodifiedSince string, object models.Object) int {
+	etag := objectETag(object)
+
+	if ifMatch != "" {
+		if !etagMatches(ifMatch, etag, false) {
+			return http.StatusPreconditionFailed
+		}
+	} else if ifUnmodifiedSince != "" {
+		if modified, ok := modifiedSince(object, ifUnmodifiedSince); ok && modified {
+			return http.StatusPreconditionFailed
+		}
+	}
+
+	if ifNoneMatch != "" {
+		if etagMatches(ifNoneMatch, etag, true) {
+			return http.StatusNotModified
+		}
+	} else if ifModifiedSince != "" {
+		if modified, ok := modifiedSince(object, ifModifiedSince); ok && !modified {
+			return http.StatusNotModified
+		}
+	}
+
+	return 0
+}
+
+// checkWritePreconditions evaluates the conditional headers of a request that replaces
+// or removes an object. The current object is nil when it does not exist yet.
+// It returns 0 when the write may proceed and 412 otherwise.
+func checkWritePreconditions(r *http.Request, current *models.Object) int {
+	if header := r.Header.Get("If-Match"); header != "" {
+		if current == nil || !etagMatches(header, objectETag(*current), false) {
+			return http.StatusPreconditionFailed
+		}
+	}
+	if header := r.Header.Get("If-None-Match"); header != "" {
+		if current != nil && etagMatches(header, objectETag(*current), true) {
+			return http.StatusPreconditionFailed
+		}
+	}
+
+	if current == nil {
+		return 0
+	}
+	if header := r.Header.Get("If-Unmodified-Since"); header != "" {
+		if modified, ok := modifiedSince(*current, header); ok && modified {
+			return http.StatusPreconditionFailed
+		}
+	}
+	if header := r.Header.Get("If-Modified-Since"); header != "" {
+		if modified, ok := modifiedSince(*current, header); ok && !modified {
+			return http.StatusPreconditionFailed
+		}
+	}
+
+	return 0
+}
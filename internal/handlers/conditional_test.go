@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestConditionalGet(t *testing.T) {
+	dir := newDataDir(t)
+	createBucket(t, dir, "files")
+	put := putObject(t, dir, "files", "notes.txt", "hello")
+	if put.Code != http.StatusOK {
+		t.Fatalf("PUT: %d %s", put.Code, put.Body)
+	}
+	etag := put.Header().Get("ETag")
+	past := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	future := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   int
+	}{
+		{"If-Match current", http.Header{"If-Match": {etag}}, http.StatusOK},
+		{"If-Match any", http.Header{"If-Match": {"*"}}, http.StatusOK},
+		{"If-Match stale", http.Header{"If-Match": {`"stale"`}}, http.StatusPreconditionFailed},
+		{"If-Match weak current", http.Header{"If-Match": {"W/" + etag}}, http.StatusPreconditionFailed},
+		{"If-Match weak and strong current", http.Header{"If-Match": {"W/" + etag + ", " + etag}}, http.StatusOK},
+		{"If-None-Match current", http.Header{"If-None-Match": {etag}}, http.StatusNotModified},
+		{"If-None-Match weak current", http.Header{"If-None-Match": {"W/" + etag}}, http.StatusNotModified},
+		{"If-None-Match stale", http.Header{"If-None-Match": {`"stale"`}}, http.StatusOK},
+		{"If-Modified-Since past", http.Header{"If-Modified-Since": {past}}, http.StatusOK},
+		{"If-Modified-Since future", http.Header{"If-Modified-Since": {future}}, http.StatusNotModified},
+		{"If-Unmodified-Since past", http.Header{"If-Unmodified-Since": {past}}, http.StatusPreconditionFailed},
+		{"If-Unmodified-Since future", http.Header{"If-Unmodified-Since": {future}}, http.StatusOK},
+		{"If-Unmodified-Since malformed", http.Header{"If-Unmodified-Since": {"yesterday"}}, http.StatusOK},
+		// If-Match takes precedence over If-Unmodified-Since, If-None-Match over If-Modified-Since
+		{"If-Match over If-Unmodified-Since", http.Header{"If-Match": {etag}, "If-Unmodified-Since": {past}}, http.StatusOK},
+		{"If-None-Match over If-Modified-Since", http.Header{"If-None-Match": {`"stale"`}, "If-Modified-Since": {future}}, http.StatusOK},
+	}
+	for _, test := range tests {
+		w := getObject(dir, "files", "notes.txt", test.header)
+		if w.Code != test.want {
+			t.Errorf("%s: %d, want %d", test.name, w.Code, test.want)
+		}
+		if w.Code == http.StatusNotModified && (w.Body.Len() != 0 || w.Header().Get("ETag") != etag) {
+			t.Errorf("%s: 304 with %d bytes and ETag %q", test.name, w.Body.Len(), w.Header().Get("ETag"))
+		}
+	}
+}
+
+func TestConditionalPut(t *testing.T) {
+	dir := newDataDir(t)
+	createBucket(t, dir, "files")
+	put := putObject(t, dir, "files", "notes.txt", "hello")
+	if put.Code != http.StatusOK {
+		t.Fatalf("PUT: %d %s", put.Code, put.Body)
+	}
+	etag := put.Header().Get("ETag")
+
+	tests := []struct {
+		name   string
+		key    string
+		header http.Header
+		want   int
+	}{
+		{"create only, key exists", "notes.txt", http.Header{"If-None-Match": {"*"}}, http.StatusPreconditionFailed},
+		{"create only, new key", "new.txt", http.Header{"If-None-Match": {"*"}}, http.StatusOK},
+		{"replace stale", "notes.txt", http.Header{"If-Match": {`"stale"`}}, http.StatusPreconditionFailed},
+		{"replace weak current", "notes.txt", http.Header{"If-Match": {"W/" + etag}}, http.StatusPreconditionFailed},
+		{"unless weak current", "notes.txt", http.Header{"If-None-Match": {"W/" + etag}}, http.StatusPreconditionFailed},
+		{"replace missing", "missing.txt", http.Header{"If-Match": {etag}}, http.StatusPreconditionFailed},
+		{"replace current", "notes.txt", http.Header{"If-Match": {etag}}, http.StatusOK},
+	}
+	for _, test := range tests {
+		if w := putObjectWith(dir, "files", test.key, "updated", test.header); w.Code != test.want {
+			t.Errorf("%s: %d, want %d", test.name, w.Code, test.want)
+		}
+	}
+	if w := getObject(dir, "files", "missing.txt", nil); w.Code != http.StatusNotFound {
+		t.Errorf("a failed conditional PUT stored the object: %d", w.Code)
+	}
+}
@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"triple-s/internal/models"
+	"triple-s/internal/services"
+)
+
+// parseCopySource splits an x-amz-copy-source header of the form "/bucket/key[?versionId=id]"
+// into its parts
+func parseCopySource(header string) (string, string, string, bool) {
+	header, rawQuery, _ := strings.Cut(header, "?")
+	source, err := url.PathUnescape(header)
+	if err != nil {
+		return "", "", "", false
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", "", "", false
+	}
+	bucketName, objectKey, found := strings.Cut(strings.TrimPrefix(source, "/"), "/")
+	if !found || !ValidateBucketName(bucketName) || !ValidateObjectKey(objectKey) {
+		return "", "", "", false
+	}
+	return bucketName, objectKey, query.Get("versionId"), true
+}
+
+// HandlerCopyObject handles PUT requests carrying x-amz-copy-source by copying the source
+// object and its metadata on the server
+func HandlerCopyObject(w http.ResponseWriter, r *http.Request, directoryPath string, bucketName, objectKey string) {
+	defer r.Body.Close()
+
+	if objectKey == "objects.csv" {
+		WriteXMLResponse(w, http.StatusForbidden, "Cannot overwrite metadata file")
+		return
+	}
+
+	sourceBucket, sourceKey, sourceVersion, ok := parseCopySource(r.Header.Get("x-amz-copy-source"))
+	if !ok {
+		WriteXMLResponse(w, http.StatusBadRequest, "Invalid copy source")
+		return
+	}
+
+	directive := r.Header.Get("x-amz-metadata-directive")
+	if directive == "" {
+		directive = "COPY"
+	}
+	if directive != "COPY" && directive != "REPLACE" {
+		WriteXMLResponse(w, http.StatusBadRequest, "Unknown metadata directive")
+		return
+	}
+	taggingDirective := r.Header.Get("x-amz-tagging-directive")
+	if taggingDirective == "" {
+		taggingDirective = "COPY"
+	}
+	if taggingDirective != "COPY" && taggingDirective != "REPLACE" {
+		WriteXMLResponse(w, http.StatusBadRequest, "Unknown tagging directive")
+		return
+	}
+	// Reading the source needs its own permission
+	sourceAction := "s3:GetObject"
+	if sourceVersion != "" {
+		sourceAction = "s3:GetObjectVersion"
+	}
+	if !allowed(r, directoryPath, sourceBucket, sourceKey, sourceAction) {
+		writeS3Error(w, r, http.StatusForbidden, "AccessDenied", "Access Denied")
+		return
+	}
+
+	// Check that both buckets exist
+	if _, err := os.Stat(directoryPath + bucketName); os.IsNotExist(err) {
+		WriteXMLResponse(w, http.StatusNotFound, "Bucket not found")
+		return
+	}
+	if _, err := os.Stat(directoryPath + sourceBucket); os.IsNotExist(err) {
+		WriteXMLResponse(w, http.StatusNotFound, "Source bucket not found")
+		return
+	}
+
+	// Look up the source object, or the requested version of it
+	var source models.Object
+	var sourcePath string
+	if sourceVersion != "" {
+		version, dataPath, err := services.ReadObjectVersion(directoryPath, sourceBucket, sourceKey, storedVersionID(sourceVersion))
+		if err == services.ErrVersionNotFound {
+			WriteXMLResponse(w, http.StatusNotFound, "Source version does not exist")
+			return
+		} else if err != nil {
+			WriteXMLResponse(w, http.StatusInternalServerError, "Cannot read metadata file")
+			return
+		}
+		if version.IsDeleteMarker {
+			WriteXMLResponse(w, http.StatusBadRequest, "The source version is a delete marker")
+			return
+		}
+		source, sourcePath = version.Object, dataPath
+		w.Header().Set("x-amz-copy-source-version-id", apiVersionID(source.VersionID))
+	} else {
+		var err error
+		source, err = services.ReadObjectInfo(directoryPath, sourceBucket, sourceKey)
+		if err == services.ErrObjectNotFound {
+			WriteXMLResponse(w, http.StatusNotFound, "Source object does not exist")
+			return
+		} else if err != nil {
+			WriteXMLResponse(w, http.StatusInternalServerError, "Cannot read metadata file")
+			return
+		}
+		if sourcePath, err = services.ObjectPath(directoryPath, sourceBucket, sourceKey); err != nil {
+			WriteXMLResponse(w, http.StatusBadRequest, "Invalid copy source")
+			return
+		}
+		if source.VersionID != "" {
+			w.Header().Set("x-amz-copy-source-version-id", source.VersionID)
+		}
+	}
+
+	// Copying the current version of an object onto itself is only allowed when replacing its metadata
+	destinationPath, _ := services.ObjectPath(directoryPath, bucketName, objectKey)
+	sameObject := sourcePath == destinationPath
+	if sameObject && directive == "COPY" {
+		WriteXMLResponse(w, http.StatusBadRequest, "This copy request is illegal because it is trying to copy an object to itself without changing the object's metadata")
+		return
+	}
+
+	// Evaluate the copy source conditions and the conditions on the destination
+	if checkCopySourcePreconditions(r, source) != 0 || checkWritePreconditions(r, currentObject(directoryPath, bucketName, objectKey)) != 0 {
+		WriteXMLResponse(w, http.StatusPreconditionFailed, "At least one of the preconditions you specified did not hold")
+		return
+	}
+
+	now := time.Now().Truncate(time.Second)
+	localObject := source
+	localObject.ObjectKey = objectKey
+	localObject.LastModifiedTime = now.Format(time.RFC3339)
+	if directive == "REPLACE" {
+		metadata, ok := requestMetadata(w, r)
+		if !ok {
+			return
+		}
+		localObject.ContentType = r.Header.Get("Content-Type")
+		localObject.Metadata = metadata
+	}
+	if taggingDirective == "REPLACE" {
+		tags, ok := requestTags(w, r)
+		if !ok {
+			return
+		}
+		localObject.Tags = tags
+	}
+	// Like Amazon S3, the copy gets the ACL of the request rather than the one of its source
+	acl, ok := requestACL(w, r)
+	if !ok {
+		return
+	}
+	localObject.ACL = acl
+
+	// Evaluate the conditions on the destination again now that no other request can change it
+	defer services.LockObject(directoryPath, bucketName, objectKey)()
+	if status := checkWritePreconditions(r, currentObject(directoryPath, bucketName, objectKey)); status != 0 {
+		WriteXMLResponse(w, status, "At least one of the preconditions you specified did not hold")
+		return
+	}
+
+	versioning, err := services.BucketVersioning(directoryPath, bucketName)
+	if err != nil {
+		WriteXMLResponse(w, http.StatusInternalServerError, "Error reading bucket info")
+		return
+	}
+	objectPath, ok := prepareObjectPath(w, directoryPath, bucketName, objectKey)
+	if !ok {
+		return
+	}
+
+	// Copy the data even onto itself, so that a versioned bucket keeps the replaced version and
+	// the size and ETag describe the data actually copied
+	staged, err := services.StageObject(directoryPath, bucketName)
+	if err != nil {
+		WriteXMLResponse(w, http.StatusInternalServerError, "Error copying object data")
+		return
+	}
+	defer staged.Discard()
+	etag, err := copyObjectFile(sourcePath, staged)
+	if err != nil {
+		WriteXMLResponse(w, http.StatusInternalServerError, "Error copying object data")
+		return
+	}
+	localObject.ETag = etag
+	localObject.Size = staged.Size()
+
+	localObject.VersionID = services.NextVersionID(versioning)
+	err = services.ReplaceObject(directoryPath, bucketName, objectKey, versioning, func() (models.Object, error) {
+		return localObject, staged.Commit(objectPath)
+	})
+	if err != nil {
+		writeCommitError(w, err, "Error copying object: "+err.Error())
+		return
+	}
+
+	if versioning != "" {
+		w.Header().Set("x-amz-version-id", apiVersionID(localObject.VersionID))
+	}
+
+	result := models.CopyObjectResult{
+		LastModified: now.UTC().Format(listTimeFormat),
+		ETag:         objectETag(localObject),
+	}
+	writeXMLResult(w, result)
+}
+
+// copyObjectFile copies the content of an object file and returns the MD5 of the copy
+func copyObjectFile(sourcePath string, dst io.Writer) (string, error) {
+	sourceFile, err := os.Open(sourcePath)
+	if err != nil {
+		return "", err
+	}
+	defer sourceFile.Close()
+
+	hash := md5.New()
+	if _, err := io.Copy(io.MultiWriter(dst, hash), sourceFile); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
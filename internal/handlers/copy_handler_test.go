@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// copyObject copies an object through the handler with the given request headers
+func copyObject(dir, bucketName, objectKey string, header http.Header) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPut, "/"+bucketName+"/"+objectKey, nil)
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	HandlerCopyObject(w, r, dir, bucketName, objectKey)
+	return w
+}
+
+func TestCopyObject(t *testing.T) {
+	dir := newDataDir(t)
+	createBucket(t, dir, "source")
+	createBucket(t, dir, "target")
+	header := http.Header{"Content-Type": {"text/plain"}, "X-Amz-Meta-Color": {"blue"}}
+	put := putObjectWith(dir, "source", "notes.txt", "hello", header)
+	if put.Code != http.StatusOK {
+		t.Fatalf("PUT: %d %s", put.Code, put.Body)
+	}
+
+	tests := []struct {
+		name        string
+		bucket, key string
+		header      http.Header
+		want        int
+		contentType string
+		color       string
+	}{
+		{"copy metadata", "target", "copy.txt", http.Header{"X-Amz-Copy-Source": {"/source/notes.txt"}}, http.StatusOK, "text/plain", "blue"},
+		{"escaped source", "target", "escaped.txt", http.Header{"X-Amz-Copy-Source": {"source/notes%2Etxt"}}, http.StatusOK, "text/plain", "blue"},
+		{"replace metadata", "target", "replaced.txt", http.Header{
+			"X-Amz-Copy-Source":        {"/source/notes.txt"},
+			"X-Amz-Metadata-Directive": {"REPLACE"},
+			"Content-Type":             {"text/markdown"},
+			"X-Amz-Meta-Color":         {"red"},
+		}, http.StatusOK, "text/markdown", "red"},
+		{"onto itself without new metadata", "source", "notes.txt", http.Header{"X-Amz-Copy-Source": {"/source/notes.txt"}}, http.StatusBadRequest, "", ""},
+		{"onto itself with new metadata", "source", "notes.txt", http.Header{
+			"X-Amz-Copy-Source":        {"/source/notes.txt"},
+			"X-Amz-Metadata-Directive": {"REPLACE"},
+			"X-Amz-Meta-Color":         {"green"},
+		}, http.StatusOK, "application/octet-stream", "green"},
+		{"unknown directive", "target", "x", http.Header{"X-Amz-Copy-Source": {"/source/notes.txt"}, "X-Amz-Metadata-Directive": {"MERGE"}}, http.StatusBadRequest, "", ""},
+		{"malformed source", "target", "x", http.Header{"X-Amz-Copy-Source": {"/source"}}, http.StatusBadRequest, "", ""},
+		{"missing source", "target", "x", http.Header{"X-Amz-Copy-Source": {"/source/missing"}}, http.StatusNotFound, "", ""},
+		{"missing source bucket", "target", "x", http.Header{"X-Amz-Copy-Source": {"/nobucket/notes.txt"}}, http.StatusNotFound, "", ""},
+		{"missing target bucket", "nobucket", "x", http.Header{"X-Amz-Copy-Source": {"/source/notes.txt"}}, http.StatusNotFound, "", ""},
+		{"source changed", "target", "x", http.Header{"X-Amz-Copy-Source": {"/source/notes.txt"}, "X-Amz-Copy-Source-If-Match": {`"stale"`}}, http.StatusPreconditionFailed, "", ""},
+	}
+	for _, test := range tests {
+		w := copyObject(dir, test.bucket, test.key, test.header)
+		if w.Code != test.want {
+			t.Errorf("%s: %d, want %d: %s", test.name, w.Code, test.want, w.Body)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		get := getObject(dir, test.bucket, test.key, nil)
+		if get.Code != http.StatusOK || get.Body.String() != "hello" {
+			t.Errorf("%s: GET copy: %d %q", test.name, get.Code, get.Body)
+		}
+		if got := get.Header().Get("Content-Type"); got != test.contentType {
+			t.Errorf("%s: Content-Type %q, want %q", test.name, got, test.contentType)
+		}
+		if got := get.Header().Get("X-Amz-Meta-Color"); got != test.color {
+			t.Errorf("%s: x-amz-meta-color %q, want %q", test.name, got, test.color)
+		}
+		if get.Header().Get("ETag") != put.Header().Get("ETag") {
+			t.Errorf("%s: ETag %s of the copy differs from the source's %s", test.name, get.Header().Get("ETag"), put.Header().Get("ETag"))
+		}
+	}
+}
+
+func TestCopyObjectOntoItselfInVersionedBucket(t *testing.T) {
+	dir := newDataDir(t)
+	createBucket(t, dir, "versioned")
+	if code := setVersioning(dir, "versioned", "Enabled"); code != http.StatusOK {
+		t.Fatalf("enabling versioning: %d", code)
+	}
+	if w := putObject(t, dir, "versioned", "notes.txt", "hello"); w.Code != http.StatusOK {
+		t.Fatalf("PUT: %d %s", w.Code, w.Body)
+	}
+
+	w := copyObject(dir, "versioned", "notes.txt", http.Header{
+		"X-Amz-Copy-Source":        {"/versioned/notes.txt"},
+		"X-Amz-Metadata-Directive": {"REPLACE"},
+		"Content-Type":             {"text/plain"},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("copy: %d %s", w.Code, w.Body)
+	}
+	if w.Header().Get("x-amz-version-id") == "" {
+		t.Errorf("copy did not report a version ID")
+	}
+	if entries, _ := listVersions(t, dir, "versioned", ""); len(strings.Fields(entries)) != 2 {
+		t.Errorf("versions after copying onto itself: %s, want two", entries)
+	}
+	get := getObject(dir, "versioned", "notes.txt", nil)
+	if get.Body.String() != "hello" || get.Header().Get("Content-Type") != "text/plain" || get.Header().Get("Content-Length") != "5" {
+		t.Errorf("GET copy: %q %s %s", get.Body, get.Header().Get("Content-Type"), get.Header().Get("Content-Length"))
+	}
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"triple-s/internal/models"
+	"triple-s/internal/services"
+)
+
+// maxDeleteKeys is the largest number of keys a single batch delete may name
+const maxDeleteKeys = 1000
+
+// HandleDeleteObjects handles POST /{bucket}?delete requests removing many objects at once.
+// Object files are removed one by one but the bucket metadata is rewritten once per batch.
+func HandleDeleteObjects(w http.ResponseWriter, r *http.Request, directoryPath string) {
+	defer r.Body.Close()
+
+	// Extract bucket name from the URL path
+	bucketName := strings.Trim(r.URL.Path, "/")
+	if _, err := os.Stat(directoryPath + bucketName); err != nil || !ValidateBucketName(bucketName) {
+		writeErrorResponse(w, "Bucket does not exist", http.StatusNotFound)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 2<<20))
+	if err != nil {
+		writeErrorResponse(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+	// Content-MD5 is optional here, but when present it must match the body
+	if contentMD5 := r.Header.Get("Content-MD5"); contentMD5 != "" {
+		digest := md5.Sum(body)
+		if base64.StdEncoding.EncodeToString(digest[:]) != contentMD5 {
+			writeErrorResponse(w, "The Content-MD5 you specified did not match what we received", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var request models.DeleteObjectsRequest
+	if err := xml.NewDecoder(bytes.NewReader(body)).Decode(&request); err != nil || len(request.Objects) == 0 {
+		writeErrorResponse(w, "Malformed delete request", http.StatusBadRequest)
+		return
+	}
+	if len(request.Objects) > maxDeleteKeys {
+		writeErrorResponse(w, "A delete request may name at most 1000 keys", http.StatusBadRequest)
+		return
+	}
+
+	// Hold every key until the metadata rewrite so that no upload slips in between
+	keys := make([]string, len(request.Objects))
+	for i, object := range request.Objects {
+		keys[i] = object.Key
+	}
+	defer services.LockObjects(directoryPath, bucketName, keys)()
+
+	versioning, err := services.BucketVersioning(directoryPath, bucketName)
+	if err != nil {
+		writeErrorResponse(w, "Error reading bucket info", http.StatusInternalServerError)
+		return
+	}
+	var result models.DeleteResult
+	var deletedKeys []string
+	for _, object := range request.Objects {
+		key := object.Key
+		if key == "objects.csv" {
+			result.Errors = append(result.Errors, models.DeleteError{Key: key, Code: "AccessDenied", Message: "Cannot delete metadata file"})
+			continue
+		}
+		objectPath, err := services.ObjectPath(directoryPath, bucketName, key)
+		if err != nil || !ValidateObjectKey(key) {
+			result.Errors = append(result.Errors, models.DeleteError{Key: key, Code: "InvalidArgument", Message: "Invalid object key"})
+			continue
+		}
+		action := "s3:DeleteObject"
+		if object.VersionID != "" {
+			action = "s3:DeleteObjectVersion"
+		}
+		if !allowed(r, directoryPath, bucketName, key, action) {
+			result.Errors = append(result.Errors, models.DeleteError{Key: key, Code: "AccessDenied", Message: "Access Denied"})
+			continue
+		}
+
+		// Versioned deletes update the version records themselves
+		if object.VersionID != "" || versioning != "" {
+			deleted, err := deleteVersionedObject(directoryPath, bucketName, key, object.VersionID, versioning)
+			if err == services.ErrVersionNotFound {
+				result.Errors = append(result.Errors, models.DeleteError{Key: key, Code: "NoSuchVersion", Message: "The specified version does not exist"})
+			} else if err != nil {
+				result.Errors = append(result.Errors, models.DeleteError{Key: key, Code: "InternalError", Message: "Error deleting object"})
+			} else if !request.Quiet {
+				result.Deleted = append(result.Deleted, deleted)
+			}
+			continue
+		}
+
+		// Deleting a key that does not exist counts as a success, as in Amazon S3
+		if info, err := os.Stat(objectPath); err == nil && !info.IsDir() {
+			if err := os.Remove(objectPath); err != nil {
+				result.Errors = append(result.Errors, models.DeleteError{Key: key, Code: "InternalError", Message: "Error deleting object"})
+				continue
+			}
+			services.RemoveEmptyParents(directoryPath, bucketName, objectPath)
+		}
+		deletedKeys = append(deletedKeys, key)
+		if !request.Quiet {
+			result.Deleted = append(result.Deleted, models.DeletedObject{Key: key})
+		}
+	}
+
+	if err := services.DeleteObjectInfos(directoryPath, bucketName, deletedKeys); err != nil {
+		writeErrorResponse(w, "Error writing object metadata", http.StatusInternalServerError)
+		return
+	}
+
+	writeXMLResult(w, result)
+}
+
+// deleteVersionedObject removes one version of an object, or places a delete marker on top of it
+// when no version is named, and describes the result as a batch delete entry
+func deleteVersionedObject(directoryPath, bucketName, objectKey, versionID, versioning string) (models.DeletedObject, error) {
+	deleted := models.DeletedObject{Key: objectKey}
+	if versionID != "" {
+		outcome, err := services.DeleteObjectVersion(directoryPath, bucketName, objectKey, storedVersionID(versionID))
+		if err != nil {
+			return deleted, err
+		}
+		deleted.VersionID = versionID
+		if outcome.DeleteMarker {
+			deleted.DeleteMarker = true
+			deleted.DeleteMarkerVersionID = versionID
+		}
+		return deleted, nil
+	}
+
+	outcome, err := services.CreateDeleteMarker(directoryPath, bucketName, objectKey, versioning)
+	if err != nil {
+		return deleted, err
+	}
+	deleted.DeleteMarker = true
+	deleted.DeleteMarkerVersionID = apiVersionID(outcome.VersionID)
+	return deleted, nil
+}
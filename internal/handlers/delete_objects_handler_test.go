@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"triple-s/internal/models"
+)
+
+// deleteBody is a batch delete request body naming keys
+func deleteBody(quiet bool, keys ...string) string {
+	request := models.DeleteObjectsRequest{Quiet: quiet}
+	for _, key := range keys {
+		request.Objects = append(request.Objects, models.ObjectIdentifier{Key: key})
+	}
+	data, _ := xml.Marshal(request)
+	return string(data)
+}
+
+func TestHandleDeleteObjects(t *testing.T) {
+	dir := newDataDir(t)
+	createBucket(t, dir, "batch")
+	for _, key := range []string{"a", "b", "c", "dir/d"} {
+		if w := putObject(t, dir, "batch", key, key); w.Code != http.StatusOK {
+			t.Fatalf("PUT %s: %d %s", key, w.Code, w.Body)
+		}
+	}
+	var tooMany []string
+	for i := 0; i <= maxDeleteKeys; i++ {
+		tooMany = append(tooMany, fmt.Sprintf("key-%d", i))
+	}
+	badDigest := md5.Sum([]byte("another body"))
+
+	tests := []struct {
+		name    string
+		bucket  string
+		body    string
+		header  http.Header
+		want    int
+		deleted string
+		errors  string
+	}{
+		{"mixed keys", "batch", deleteBody(false, "a", "missing", "dir/d", "objects.csv", "../x"), nil,
+			http.StatusOK, "a missing dir/d", "objects.csv:AccessDenied ../x:InvalidArgument"},
+		{"quiet", "batch", deleteBody(true, "b"), nil, http.StatusOK, "", ""},
+		{"digest mismatch", "batch", deleteBody(false, "c"), http.Header{"Content-Md5": {base64.StdEncoding.EncodeToString(badDigest[:])}},
+			http.StatusBadRequest, "", ""},
+		{"malformed body", "batch", "<Delete><Object>", nil, http.StatusBadRequest, "", ""},
+		{"no keys", "batch", deleteBody(false), nil, http.StatusBadRequest, "", ""},
+		{"too many keys", "batch", deleteBody(false, tooMany...), nil, http.StatusBadRequest, "", ""},
+		{"missing bucket", "nobucket", deleteBody(false, "a"), nil, http.StatusNotFound, "", ""},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/"+test.bucket+"?delete", strings.NewReader(test.body))
+		for name, values := range test.header {
+			r.Header[name] = values
+		}
+		HandleDeleteObjects(w, r, dir)
+		if w.Code != test.want {
+			t.Errorf("%s: %d, want %d: %s", test.name, w.Code, test.want, w.Body)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var result models.DeleteResult
+		if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		var deleted, errors []string
+		for _, object := range result.Deleted {
+			deleted = append(deleted, object.Key)
+		}
+		for _, failure := range result.Errors {
+			errors = append(errors, failure.Key+":"+failure.Code)
+		}
+		if got := strings.Join(deleted, " "); got != test.deleted {
+			t.Errorf("%s: deleted %q, want %q", test.name, got, test.deleted)
+		}
+		if got := strings.Join(errors, " "); got != test.errors {
+			t.Errorf("%s: errors %q, want %q", test.name, got, test.errors)
+		}
+	}
+
+	// Only the key of the rejected request is left
+	for key, want := range map[string]int{"a": http.StatusNotFound, "b": http.StatusNotFound, "dir/d": http.StatusNotFound, "c": http.StatusOK} {
+		if w := getObject(dir, "batch", key, nil); w.Code != want {
+			t.Errorf("GET %s after the deletes: %d, want %d", key, w.Code, want)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"triple-s/internal/models"
+	"triple-s/internal/services"
+)
+
+// maxLifecycleRules is the largest number of rules a lifecycle configuration may hold
+const maxLifecycleRules = 1000
+
+// validateLifecycle checks a lifecycle configuration against the limits of Amazon S3
+func validateLifecycle(configuration models.LifecycleConfiguration) error {
+	if len(configuration.Rules) == 0 || len(configuration.Rules) > maxLifecycleRules {
+		return errors.New("a lifecycle configuration must hold between 1 and 1000 rules")
+	}
+
+	ids := make(map[string]bool, len(configuration.Rules))
+	for _, rule := range configuration.Rules {
+		if len(rule.ID) > 255 {
+			return errors.New("rule IDs may be at most 255 characters")
+		}
+		if rule.ID != "" {
+			if ids[rule.ID] {
+				return errors.New("rule ID " + rule.ID + " is used more than once")
+			}
+			ids[rule.ID] = true
+		}
+		if rule.Status != "Enabled" && rule.Status != "Disabled" {
+			return errors.New("rule status must be Enabled or Disabled")
+		}
+		if rule.Filter != nil && rule.Prefix != "" {
+			return errors.New("a rule may not have both a Prefix and a Filter")
+		}
+
+		_, tags := services.RuleFilter(rule)
+		tagMap := make(map[string]string, len(tags))
+		for _, tag := range tags {
+			tagMap[tag.Key] = tag.Value
+		}
+		if len(tagMap) != len(tags) || !ValidateTags(tagMap, maxObjectTags) {
+			return errors.New("invalid tags in rule filter")
+		}
+
+		if rule.Expiration == nil && rule.NoncurrentVersionExpiration == nil && rule.AbortIncompleteMultipartUpload == nil {
+			return errors.New("a rule must specify at least one action")
+		}
+		if expiration := rule.Expiration; expiration != nil {
+			set := 0
+			if expiration.Days != 0 {
+				set++
+			}
+			if expiration.Date != "" {
+				set++
+			}
+			if expiration.ExpiredObjectDeleteMarker {
+				set++
+			}
+			if set != 1 {
+				return errors.New("expiration must specify exactly one of Days, Date and ExpiredObjectDeleteMarker")
+			}
+			if expiration.Days < 0 {
+				return errors.New("expiration days must be a positive integer")
+			}
+			if expiration.Date != "" {
+				date, err := time.Parse(time.RFC3339, expiration.Date)
+				if err != nil || !date.Equal(date.UTC().Truncate(24*time.Hour)) {
+					return errors.New("expiration date must be at midnight UTC in ISO 8601 format")
+				}
+			}
+			if expiration.ExpiredObjectDeleteMarker && len(tags) > 0 {
+				return errors.New("ExpiredObjectDeleteMarker cannot be combined with tag filters")
+			}
+		}
+		if rule.NoncurrentVersionExpiration != nil && rule.NoncurrentVersionExpiration.NoncurrentDays <= 0 {
+			return errors.New("noncurrent days must be a positive integer")
+		}
+		if rule.AbortIncompleteMultipartUpload != nil {
+			if rule.AbortIncompleteMultipartUpload.DaysAfterInitiation <= 0 {
+				return errors.New("days after initiation must be a positive integer")
+			}
+			if len(tags) > 0 {
+				return errors.New("AbortIncompleteMultipartUpload cannot be combined with tag filters")
+			}
+		}
+	}
+	return nil
+}
+
+// HandleGetBucketLifecycle handles GET /{bucket}?lifecycle requests returning the lifecycle rules of a bucket
+func HandleGetBucketLifecycle(w http.ResponseWriter, r *http.Request, directoryPath string) {
+	if _, ok := findTaggedBucket(w, r, directoryPath); !ok {
+		return
+	}
+
+	bucketName := strings.Trim(r.URL.Path, "/")
+	configuration, err := services.ReadLifecycle(directoryPath, bucketName)
+	if err == services.ErrLifecycleNotFound {
+		writeErrorResponse(w, "The lifecycle configuration does not exist", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeErrorResponse(w, "Error reading lifecycle configuration", http.StatusInternalServerError)
+		return
+	}
+	writeXMLResult(w, configuration)
+}
+
+// HandlePutBucketLifecycle handles PUT /{bucket}?lifecycle requests replacing the lifecycle rules of a bucket
+func HandlePutBucketLifecycle(w http.ResponseWriter, r *http.Request, directoryPath string) {
+	defer r.Body.Close()
+
+	if _, ok := findTaggedBucket(w, r, directoryPath); !ok {
+		return
+	}
+
+	var configuration models.LifecycleConfiguration
+	if err := xml.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&configuration); err != nil {
+		writeErrorResponse(w, "Malformed lifecycle configuration", http.StatusBadRequest)
+		return
+	}
+	if err := validateLifecycle(configuration); err != nil {
+		writeErrorResponse(w, "Invalid lifecycle configuration: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	bucketName := strings.Trim(r.URL.Path, "/")
+	if err := services.WriteLifecycle(directoryPath, bucketName, configuration); err != nil {
+		writeErrorResponse(w, "Error writing lifecycle configuration", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleDeleteBucketLifecycle handles DELETE /{bucket}?lifecycle requests removing the lifecycle rules of a bucket
+func HandleDeleteBucketLifecycle(w http.ResponseWriter, r *http.Request, directoryPath string) {
+	if _, ok := findTaggedBucket(w, r, directoryPath); !ok {
+		return
+	}
+
+	bucketName := strings.Trim(r.URL.Path, "/")
+	if err := services.DeleteLifecycle(directoryPath, bucketName); err != nil {
+		writeErrorResponse(w, "Error removing lifecycle configuration", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
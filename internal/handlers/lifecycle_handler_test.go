@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"triple-s/internal/models"
+	"triple-s/internal/services"
+)
+
+// lifecycleBody is a LifecycleConfiguration document holding rules
+func lifecycleBody(rules ...models.LifecycleRule) string {
+	data, _ := xml.Marshal(models.LifecycleConfiguration{Rules: rules})
+	return string(data)
+}
+
+// putLifecycle replaces the lifecycle rules of a bucket through the handler
+func putLifecycle(dir, bucketName, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	HandlePutBucketLifecycle(w, httptest.NewRequest(http.MethodPut, "/"+bucketName+"?lifecycle", strings.NewReader(body)), dir)
+	return w
+}
+
+func TestBucketLifecycleConfiguration(t *testing.T) {
+	dir := newDataDir(t)
+	createBucket(t, dir, "aging")
+
+	expireLogs := models.LifecycleRule{ID: "logs", Status: "Enabled", Filter: &models.LifecycleFilter{Prefix: "logs/"}, Expiration: &models.LifecycleExpiration{Days: 30}}
+	invalid := []struct {
+		name string
+		body string
+	}{
+		{"malformed document", "<LifecycleConfiguration><Rule>"},
+		{"no rules", lifecycleBody()},
+		{"unknown status", lifecycleBody(models.LifecycleRule{Status: "On", Expiration: &models.LifecycleExpiration{Days: 1}})},
+		{"no action", lifecycleBody(models.LifecycleRule{Status: "Enabled"})},
+		{"duplicate IDs", lifecycleBody(expireLogs, expireLogs)},
+		{"prefix and filter", lifecycleBody(models.LifecycleRule{Status: "Enabled", Prefix: "a/", Filter: &models.LifecycleFilter{}, Expiration: &models.LifecycleExpiration{Days: 1}})},
+		{"days and date", lifecycleBody(models.LifecycleRule{Status: "Enabled", Expiration: &models.LifecycleExpiration{Days: 1, Date: "2030-01-01T00:00:00Z"}})},
+		{"date not at midnight", lifecycleBody(models.LifecycleRule{Status: "Enabled", Expiration: &models.LifecycleExpiration{Date: "2030-01-01T12:00:00Z"}})},
+		{"negative days", lifecycleBody(models.LifecycleRule{Status: "Enabled", Expiration: &models.LifecycleExpiration{Days: -1}})},
+		{"zero noncurrent days", lifecycleBody(models.LifecycleRule{Status: "Enabled", NoncurrentVersionExpiration: &models.NoncurrentVersionExpiration{}})},
+		{"abort with tag filter", lifecycleBody(models.LifecycleRule{Status: "Enabled", Filter: &models.LifecycleFilter{Tag: &models.Tag{Key: "a", Value: "b"}},
+			AbortIncompleteMultipartUpload: &models.AbortIncompleteMultipartUpload{DaysAfterInitiation: 1}})},
+	}
+	for _, test := range invalid {
+		if w := putLifecycle(dir, "aging", test.body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: %d, want %d", test.name, w.Code, http.StatusBadRequest)
+		}
+	}
+
+	// Each step changes or reads the rules of the bucket, in order
+	tests := []struct {
+		name    string
+		handler func(http.ResponseWriter, *http.Request, string)
+		method  string
+		bucket  string
+		body    string
+		want    int
+		rules   string
+	}{
+		{"no rules yet", HandleGetBucketLifecycle, http.MethodGet, "aging", "", http.StatusNotFound, ""},
+		{"set rules", HandlePutBucketLifecycle, http.MethodPut, "aging", lifecycleBody(expireLogs), http.StatusOK, ""},
+		{"rules stored", HandleGetBucketLifecycle, http.MethodGet, "aging", "", http.StatusOK, lifecycleBody(expireLogs)},
+		{"delete rules", HandleDeleteBucketLifecycle, http.MethodDelete, "aging", "", http.StatusNoContent, ""},
+		{"rules removed", HandleGetBucketLifecycle, http.MethodGet, "aging", "", http.StatusNotFound, ""},
+		{"missing bucket", HandlePutBucketLifecycle, http.MethodPut, "missing", lifecycleBody(expireLogs), http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		test.handler(w, httptest.NewRequest(test.method, "/"+test.bucket+"?lifecycle", strings.NewReader(test.body)), dir)
+		if w.Code != test.want {
+			t.Errorf("%s: %d, want %d: %s", test.name, w.Code, test.want, w.Body)
+			continue
+		}
+		if test.rules == "" {
+			continue
+		}
+		var configuration models.LifecycleConfiguration
+		if err := xml.Unmarshal(w.Body.Bytes(), &configuration); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if got := lifecycleBody(configuration.Rules...); got != test.rules {
+			t.Errorf("%s: rules %s, want %s", test.name, got, test.rules)
+		}
+	}
+}
+
+func TestRunLifecycle(t *testing.T) {
+	dir := newDataDir(t)
+	createBucket(t, dir, "aging")
+	for _, key := range []string{"logs/old", "scratch", "kept", "drafts/note"} {
+		putObject(t, dir, "aging", key, "data")
+	}
+	if w := putObjectWith(dir, "aging", "scratch", "data", http.Header{"X-Amz-Tagging": {"temporary=yes"}}); w.Code != http.StatusOK {
+		t.Fatalf("PUT scratch: %d %s", w.Code, w.Body)
+	}
+	w := callObjectHandler(HandlerCreateMultipartUpload, dir, http.MethodPost, "aging", "upload", "uploads", "")
+	var initiated models.InitiateMultipartUploadResult
+	if w.Code != http.StatusOK || xml.Unmarshal(w.Body.Bytes(), &initiated) != nil {
+		t.Fatalf("initiate: %d %s", w.Code, w.Body)
+	}
+
+	rules := lifecycleBody(
+		models.LifecycleRule{ID: "logs", Status: "Enabled", Filter: &models.LifecycleFilter{Prefix: "logs/"}, Expiration: &models.LifecycleExpiration{Days: 1}},
+		models.LifecycleRule{ID: "temporary", Status: "Enabled", Filter: &models.LifecycleFilter{Tag: &models.Tag{Key: "temporary", Value: "yes"}}, Expiration: &models.LifecycleExpiration{Days: 1}},
+		models.LifecycleRule{ID: "drafts", Status: "Disabled", Filter: &models.LifecycleFilter{Prefix: "drafts/"}, Expiration: &models.LifecycleExpiration{Days: 1}},
+		models.LifecycleRule{ID: "uploads", Status: "Enabled", AbortIncompleteMultipartUpload: &models.AbortIncompleteMultipartUpload{DaysAfterInitiation: 1}},
+	)
+	if w := putLifecycle(dir, "aging", rules); w.Code != http.StatusOK {
+		t.Fatalf("PUT lifecycle: %d %s", w.Code, w.Body)
+	}
+
+	uploadID := "uploadId=" + initiated.UploadID
+	tests := []struct {
+		name   string
+		now    time.Time
+		exists map[string]bool
+		upload bool
+	}{
+		{"before the rules are due", time.Now(), map[string]bool{"logs/old": true, "scratch": true, "kept": true, "drafts/note": true}, true},
+		{"after the rules are due", time.Now().Add(72 * time.Hour), map[string]bool{"logs/old": false, "scratch": false, "kept": true, "drafts/note": true}, false},
+	}
+	for _, test := range tests {
+		services.RunLifecycle(dir, test.now)
+		for key, exists := range test.exists {
+			if got := getObject(dir, "aging", key, nil).Code == http.StatusOK; got != exists {
+				t.Errorf("%s: %s exists %v, want %v", test.name, key, got, exists)
+			}
+		}
+		w := callObjectHandler(HandlerListParts, dir, http.MethodGet, "aging", "upload", uploadID, "")
+		if got := w.Code == http.StatusOK; got != test.upload {
+			t.Errorf("%s: upload in progress %v, want %v", test.name, got, test.upload)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"triple-s/internal/models"
+	"triple-s/internal/services"
+)
+
+// maxListKeys is the largest page a listing returns, matching Amazon S3
+const maxListKeys = 1000
+
+// defaultOwner is reported as the owner of every object when fetch-owner is requested
+var defaultOwner = models.Owner{ID: "triple-s", DisplayName: "triple-s"}
+
+// listPage is one page of a bucket listing after applying prefix, delimiter and marker
+type listPage struct {
+	contents       []models.Object
+	commonPrefixes []string
+	isTruncated    bool
+	// nextMarker is the last key or common prefix returned, from which the next page starts
+	nextMarker string
+}
+
+// objectScan calls fn with the objects of a listed prefix that sort after startAfter, in key
+// order, until fn returns false
+type objectScan func(startAfter string, fn func(models.Object) bool) error
+
+// listObjects selects up to maxKeys entries that sort after marker. Keys containing the
+// delimiter after the prefix are rolled up into common prefixes, each counting as one entry.
+// The scan starts after the marker, seeks past the keys of every common prefix it rolls up
+// and stops at the first entry past the page, so a page costs the same however many objects
+// follow it or share its common prefixes. Like S3, a page of zero entries is never
+// truncated, since it has no marker a client could continue from.
+func listObjects(scan objectScan, prefix, delimiter, marker string, maxKeys int) (listPage, error) {
+	var page listPage
+	if maxKeys == 0 {
+		return page, nil
+	}
+	count := 0
+	for start := marker; ; {
+		seek := ""
+		err := scan(start, func(object models.Object) bool {
+			key := object.ObjectKey
+			commonPrefix := ""
+			if delimiter != "" {
+				if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+					commonPrefix = key[:len(prefix)+i+len(delimiter)]
+				}
+			}
+			// Keys under a common prefix that was already returned are skipped
+			if commonPrefix != "" && (commonPrefix == marker || commonPrefix == page.nextMarker) {
+				seek = afterPrefix(commonPrefix)
+				return false
+			}
+
+			if count == maxKeys {
+				page.isTruncated = true
+				return false
+			}
+			count++
+
+			if commonPrefix != "" {
+				page.commonPrefixes = append(page.commonPrefixes, commonPrefix)
+				page.nextMarker = commonPrefix
+				seek = afterPrefix(commonPrefix)
+				return false
+			}
+			page.contents = append(page.contents, object)
+			page.nextMarker = key
+			return true
+		})
+		if err != nil || seek == "" {
+			return page, err
+		}
+		start = seek
+	}
+}
+
+// afterPrefix returns the marker from which a scan continues past every key starting with a
+// prefix: the last key such a scan would have to skip, the prefix padded with 0xff bytes to
+// the longest key length. The next key the scan returns is the first one at or after the
+// prefix with its last byte incremented.
+func afterPrefix(prefix string) string {
+	return prefix + strings.Repeat("\xff", max(maxObjectKeyLength-len(prefix), 0))
+}
+
+// parseMaxKeys reads the max-keys query parameter, defaulting to and capped at maxListKeys
+func parseMaxKeys(query url.Values) (int, bool) {
+	value := query.Get("max-keys")
+	if value == "" {
+		return maxListKeys, true
+	}
+	maxKeys, err := strconv.Atoi(value)
+	if err != nil || maxKeys < 0 {
+		return 0, false
+	}
+	if maxKeys > maxListKeys {
+		maxKeys = maxListKeys
+	}
+	return maxKeys, true
+}
+
+// listTimeFormat is the timestamp layout used by S3 listings
+const listTimeFormat = "2006-01-02T15:04:05.000Z"
+
+// objectContents converts object metadata into a listing entry
+func objectContents(object models.Object, fetchOwner bool, encode func(string) string) models.ObjectContents {
+	contents := models.ObjectContents{
+		Key:          encode(object.ObjectKey),
+		LastModified: object.LastModifiedTime,
+		ETag:         objectETag(object),
+		Size:         object.Size,
+		StorageClass: "STANDARD",
+	}
+	if modTime, err := time.Parse(time.RFC3339, object.LastModifiedTime); err == nil {
+		contents.LastModified = modTime.UTC().Format(listTimeFormat)
+	}
+	if fetchOwner {
+		owner := defaultOwner
+		contents.Owner = &owner
+	}
+	return contents
+}
+
+// keyEncoder returns the function applied to keys and prefixes for the requested encoding-type
+func keyEncoder(encodingType string) func(string) string {
+	if encodingType == "url" {
+		return func(s string) string {
+			return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+		}
+	}
+	return func(s string) string { return s }
+}
+
+// checkListedBucket checks that a listed bucket exists, writing an error response otherwise
+func checkListedBucket(w http.ResponseWriter, directoryPath, bucketName string) bool {
+	if _, err := os.Stat(directoryPath + bucketName); err != nil || !ValidateBucketName(bucketName) {
+		writeErrorResponse(w, "Bucket does not exist", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// listBucketPage reads one page of the objects of a bucket under prefix, writing an error
+// response and returning false when the bucket does not exist or cannot be read
+func listBucketPage(w http.ResponseWriter, directoryPath, bucketName, prefix, delimiter, marker string, maxKeys int) (listPage, bool) {
+	if !checkListedBucket(w, directoryPath, bucketName) {
+		return listPage{}, false
+	}
+	scan := func(startAfter string, fn func(models.Object) bool) error {
+		return services.ScanObjectInfo(directoryPath, bucketName, prefix, startAfter, fn)
+	}
+	page, err := listObjects(scan, prefix, delimiter, marker, maxKeys)
+	if err != nil {
+		writeErrorResponse(w, "Error reading object metadata", http.StatusInternalServerError)
+		return listPage{}, false
+	}
+	return page, true
+}
+
+// loadBucketObjects checks that the bucket exists and returns its objects whose key starts
+// with prefix, sorted by key
+func loadBucketObjects(w http.ResponseWriter, directoryPath, bucketName, prefix string) ([]models.Object, bool) {
+	if !checkListedBucket(w, directoryPath, bucketName) {
+		return nil, false
+	}
+	objects, err := services.ListObjectInfo(directoryPath, bucketName, prefix)
+	if err != nil {
+		writeErrorResponse(w, "Error reading object metadata", http.StatusInternalServerError)
+		return nil, false
+	}
+	return objects, true
+}
+
+// HandleListObjectsV2 handles GET requests listing the objects of a bucket with the ListObjectsV2 API
+func HandleListObjectsV2(w http.ResponseWriter, r *http.Request, directoryPath string) {
+	// Extract bucket name from the URL path
+	bucketName := strings.Trim(r.URL.Path, "/")
+	query := r.URL.Query()
+
+	maxKeys, ok := parseMaxKeys(query)
+	if !ok {
+		writeErrorResponse(w, "Invalid max-keys value", http.StatusBadRequest)
+		return
+	}
+	encodingType := query.Get("encoding-type")
+	if encodingType != "" && encodingType != "url" {
+		writeErrorResponse(w, "Invalid encoding-type value", http.StatusBadRequest)
+		return
+	}
+
+	// The continuation token is the opaque form of the last key of the previous page
+	marker := query.Get("start-after")
+	continuationToken := query.Get("continuation-token")
+	if continuationToken != "" {
+		decoded, err := base64.URLEncoding.DecodeString(continuationToken)
+		if err != nil {
+			writeErrorResponse(w, "Invalid continuation token", http.StatusBadRequest)
+			return
+		}
+		marker = string(decoded)
+	}
+
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	page, ok := listBucketPage(w, directoryPath, bucketName, prefix, delimiter, marker, maxKeys)
+	if !ok {
+		return
+	}
+
+	encode := keyEncoder(encodingType)
+	fetchOwner := query.Get("fetch-owner") == "true"
+	result := models.ListBucketResultV2{
+		Name:              bucketName,
+		Prefix:            encode(prefix),
+		Delimiter:         encode(delimiter),
+		MaxKeys:           maxKeys,
+		KeyCount:          len(page.contents) + len(page.commonPrefixes),
+		IsTruncated:       page.isTruncated,
+		EncodingType:      encodingType,
+		ContinuationToken: continuationToken,
+		StartAfter:        encode(query.Get("start-after")),
+	}
+	if page.isTruncated {
+		result.NextContinuationToken = base64.URLEncoding.EncodeToString([]byte(page.nextMarker))
+	}
+	for _, object := range page.contents {
+		result.Contents = append(result.Contents, objectContents(object, fetchOwner, encode))
+	}
+	for _, commonPrefix := range page.commonPrefixes {
+		result.CommonPrefixes = append(result.CommonPrefixes, models.CommonPrefix{Prefix: encode(commonPrefix)})
+	}
+
+	xmlData, err := xml.MarshalIndent(result, "", "  ")
+	if err != nil {
+		writeErrorResponse(w, "Error generating XML", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(xmlData)
+}
+
+// HandleListObjects handles GET requests listing the objects of a bucket with the legacy
+// ListObjects (V1) API, which pages with marker and NextMarker
+func HandleListObjects(w http.ResponseWriter, r *http.Request, directoryPath string) {
+	// Extract bucket name from the URL path
+	bucketName := strings.Trim(r.URL.Path, "/")
+	query := r.URL.Query()
+
+	maxKeys, ok := parseMaxKeys(query)
+	if !ok {
+		writeErrorResponse(w, "Invalid max-keys value", http.StatusBadRequest)
+		return
+	}
+	encodingType := query.Get("encoding-type")
+	if encodingType != "" && encodingType != "url" {
+		writeErrorResponse(w, "Invalid encoding-type value", http.StatusBadRequest)
+		return
+	}
+
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	marker := query.Get("marker")
+	page, ok := listBucketPage(w, directoryPath, bucketName, prefix, delimiter, marker, maxKeys)
+	if !ok {
+		return
+	}
+
+	// V1 listings always include the owner of each object
+	encode := keyEncoder(encodingType)
+	result := models.ListBucketResult{
+		Name:         bucketName,
+		Prefix:       encode(prefix),
+		Marker:       encode(marker),
+		MaxKeys:      maxKeys,
+		Delimiter:    encode(delimiter),
+		IsTruncated:  page.isTruncated,
+		EncodingType: encodingType,
+	}
+	if page.isTruncated {
+		result.NextMarker = encode(page.nextMarker)
+	}
+	for _, object := range page.contents {
+		result.Contents = append(result.Contents, objectContents(object, true, encode))
+	}
+	for _, commonPrefix := range page.commonPrefixes {
+		result.CommonPrefixes = append(result.CommonPrefixes, models.CommonPrefix{Prefix: encode(commonPrefix)})
+	}
+
+	xmlData, err := xml.MarshalIndent(result, "", "  ")
+	if err != nil {
+		writeErrorResponse(w, "Error generating XML", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(xmlData)
+}
@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+
+	"triple-s/internal/models"
+)
+
+// sliceScan scans sorted objects like a metadata store, counting the objects it hands out
+func sliceScan(objects []models.Object, prefix string, visited *int) objectScan {
+	return func(startAfter string, fn func(models.Object) bool) error {
+		for _, object := range objects {
+			if !strings.HasPrefix(object.ObjectKey, prefix) || object.ObjectKey <= startAfter {
+				continue
+			}
+			*visited++
+			if !fn(object) {
+				break
+			}
+		}
+		return nil
+	}
+}
+
+func TestListObjectsStopsAfterPage(t *testing.T) {
+	var objects []models.Object
+	for i := 0; i < 100; i++ {
+		objects = append(objects, models.Object{ObjectKey: fmt.Sprintf("key-%03d", i)})
+	}
+
+	tests := []struct {
+		marker      string
+		maxKeys     int
+		first, last string
+		truncated   bool
+		visited     int
+	}{
+		{"", 10, "key-000", "key-009", true, 11},
+		{"key-009", 10, "key-010", "key-019", true, 11},
+		{"key-094", 10, "key-095", "key-099", false, 5},
+		{"key-099", 10, "", "", false, 0},
+		{"", 0, "", "", false, 0},
+	}
+	for _, test := range tests {
+		visited := 0
+		page, err := listObjects(sliceScan(objects, "", &visited), "", "", test.marker, test.maxKeys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		first, last := "", ""
+		if len(page.contents) > 0 {
+			first, last = page.contents[0].ObjectKey, page.contents[len(page.contents)-1].ObjectKey
+		}
+		if first != test.first || last != test.last || page.isTruncated != test.truncated {
+			t.Errorf("marker %q: got %s..%s truncated %v, want %s..%s truncated %v",
+				test.marker, first, last, page.isTruncated, test.first, test.last, test.truncated)
+		}
+		if visited != test.visited {
+			t.Errorf("marker %q: scanned %d objects, want %d", test.marker, visited, test.visited)
+		}
+	}
+}
+
+func TestListObjectsSeeksPastCommonPrefixes(t *testing.T) {
+	var objects []models.Object
+	for i := 0; i < 1000; i++ {
+		objects = append(objects, models.Object{ObjectKey: fmt.Sprintf("photos/%03d.jpg", i)})
+	}
+	// "photos0" sorts right after every key under "photos/"
+	for _, key := range []string{"photos0", "readme", "videos/a.mp4", "videos/b.mp4"} {
+		objects = append(objects, models.Object{ObjectKey: key})
+	}
+
+	tests := []struct {
+		marker    string
+		maxKeys   int
+		entries   string
+		truncated bool
+		visited   int
+	}{
+		{"", 10, "photos/ photos0 readme videos/", false, 4},
+		{"", 1, "photos/", true, 2},
+		{"photos/", 10, "photos0 readme videos/", false, 4},
+		{"photos/500.jpg", 10, "photos/ photos0 readme videos/", false, 4},
+	}
+	for _, test := range tests {
+		visited := 0
+		page, err := listObjects(sliceScan(objects, "", &visited), "", "/", test.marker, test.maxKeys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var entries []string
+		entries = append(entries, page.commonPrefixes...)
+		for _, object := range page.contents {
+			entries = append(entries, object.ObjectKey)
+		}
+		sort.Strings(entries)
+		if got := strings.Join(entries, " "); got != test.entries || page.isTruncated != test.truncated {
+			t.Errorf("marker %q: got %q truncated %v, want %q truncated %v", test.marker, got, page.isTruncated, test.entries, test.truncated)
+		}
+		if visited != test.visited {
+			t.Errorf("marker %q: scanned %d objects, want %d", test.marker, visited, test.visited)
+		}
+	}
+}
+
+// listBucket runs a listing handler and decodes the result when the request succeeds
+func listBucket(t *testing.T, handler http.HandlerFunc, query string, result any) int {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/listing?"+query, nil))
+	if w.Code == http.StatusOK {
+		if err := xml.Unmarshal(w.Body.Bytes(), result); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+	return w.Code
+}
+
+// newListingBucket creates a bucket holding a small key hierarchy
+func newListingBucket(t *testing.T) string {
+	t.Helper()
+	dir := newDataDir(t)
+	createBucket(t, dir, "listing")
+	for _, key := range []string{"a.txt", "photos/2024/1.jpg", "photos/2024/2.jpg", "photos/cat.jpg", "z.txt"} {
+		if w := putObject(t, dir, "listing", key, key); w.Code != http.StatusOK {
+			t.Fatalf("PUT %s: %d %s", key, w.Code, w.Body)
+		}
+	}
+	return dir
+}
+
+// listedNames joins the keys and common prefixes of a listing
+func listedNames(contents []models.ObjectContents, prefixes []models.CommonPrefix) string {
+	var names []string
+	for _, object := range contents {
+		names = append(names, object.Key)
+	}
+	for _, prefix := range prefixes {
+		names = append(names, prefix.Prefix+"*")
+	}
+	return strings.Join(names, " ")
+}
+
+func TestHandleListObjectsV2(t *testing.T) {
+	dir := newListingBucket(t)
+	handler := func(w http.ResponseWriter, r *http.Request) { HandleListObjectsV2(w, r, dir) }
+
+	tests := []struct {
+		query     string
+		want      int
+		names     string
+		truncated bool
+	}{
+		{"list-type=2", http.StatusOK, "a.txt photos/2024/1.jpg photos/2024/2.jpg photos/cat.jpg z.txt", false},
+		{"list-type=2&prefix=photos/", http.StatusOK, "photos/2024/1.jpg photos/2024/2.jpg photos/cat.jpg", false},
+		{"list-type=2&delimiter=/", http.StatusOK, "a.txt z.txt photos/*", false},
+		{"list-type=2&prefix=photos/&delimiter=/", http.StatusOK, "photos/cat.jpg photos/2024/*", false},
+		{"list-type=2&start-after=photos/cat.jpg", http.StatusOK, "z.txt", false},
+		{"list-type=2&max-keys=2", http.StatusOK, "a.txt photos/2024/1.jpg", true},
+		{"list-type=2&max-keys=0", http.StatusOK, "", false},
+		{"list-type=2&max-keys=-1", http.StatusBadRequest, "", false},
+		{"list-type=2&encoding-type=base64", http.StatusBadRequest, "", false},
+		{"list-type=2&continuation-token=%25%25", http.StatusBadRequest, "", false},
+	}
+	for _, test := range tests {
+		var result models.ListBucketResultV2
+		if code := listBucket(t, handler, test.query, &result); code != test.want {
+			t.Errorf("%s: %d, want %d", test.query, code, test.want)
+			continue
+		}
+		names := listedNames(result.Contents, result.CommonPrefixes)
+		if names != test.names || result.IsTruncated != test.truncated {
+			t.Errorf("%s: %q truncated %v, want %q truncated %v", test.query, names, result.IsTruncated, test.names, test.truncated)
+		}
+		if result.KeyCount != len(result.Contents)+len(result.CommonPrefixes) {
+			t.Errorf("%s: KeyCount %d", test.query, result.KeyCount)
+		}
+		if result.IsTruncated != (result.NextContinuationToken != "") {
+			t.Errorf("%s: truncated %v with continuation token %q", test.query, result.IsTruncated, result.NextContinuationToken)
+		}
+	}
+
+	// Following the continuation tokens lists every entry once
+	for _, delimiter := range []string{"", "/"} {
+		var pages []string
+		token := ""
+		for len(pages) < 10 {
+			var result models.ListBucketResultV2
+			query := "list-type=2&max-keys=1&delimiter=" + url.QueryEscape(delimiter) + "&continuation-token=" + url.QueryEscape(token)
+			if code := listBucket(t, handler, query, &result); code != http.StatusOK {
+				t.Fatalf("%s: %d", query, code)
+			}
+			pages = append(pages, listedNames(result.Contents, result.CommonPrefixes))
+			if !result.IsTruncated {
+				break
+			}
+			token = result.NextContinuationToken
+		}
+		want := "a.txt photos/2024/1.jpg photos/2024/2.jpg photos/cat.jpg z.txt"
+		if delimiter != "" {
+			want = "a.txt photos/* z.txt"
+		}
+		if got := strings.Join(pages, " "); got != want {
+			t.Errorf("paging with delimiter %q: %q, want %q", delimiter, got, want)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	HandleListObjectsV2(w, httptest.NewRequest(http.MethodGet, "/missing?list-type=2", nil), dir)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("listing a missing bucket: %d", w.Code)
+	}
+}
+
+func TestHandleListObjectsV1(t *testing.T) {
+	dir := newListingBucket(t)
+	handler := func(w http.ResponseWriter, r *http.Request) { HandleListObjects(w, r, dir) }
+
+	tests := []struct {
+		query      string
+		names      string
+		truncated  bool
+		nextMarker string
+	}{
+		{"", "a.txt photos/2024/1.jpg photos/2024/2.jpg photos/cat.jpg z.txt", false, ""},
+		{"marker=photos/2024/2.jpg", "photos/cat.jpg z.txt", false, ""},
+		{"max-keys=2", "a.txt photos/2024/1.jpg", true, "photos/2024/1.jpg"},
+		{"max-keys=1&delimiter=/&marker=a.txt", "photos/*", true, "photos/"},
+		{"max-keys=0", "", false, ""},
+	}
+	for _, test := range tests {
+		var result models.ListBucketResult
+		if code := listBucket(t, handler, test.query, &result); code != http.StatusOK {
+			t.Errorf("%s: %d", test.query, code)
+			continue
+		}
+		names := listedNames(result.Contents, result.CommonPrefixes)
+		if names != test.names || result.IsTruncated != test.truncated || result.NextMarker != test.nextMarker {
+			t.Errorf("%s: %q truncated %v next %q, want %q truncated %v next %q", test.query,
+				names, result.IsTruncated, result.NextMarker, test.names, test.truncated, test.nextMarker)
+		}
+		for _, object := range result.Contents {
+			if object.Owner == nil {
+				t.Errorf("%s: %s listed without its owner", test.query, object.Key)
+			}
+		}
+	}
+
+	// Following NextMarker lists every entry once
+	var pages []string
+	marker := ""
+	for len(pages) < 10 {
+		var result models.ListBucketResult
+		query := "max-keys=2&delimiter=/&marker=" + url.QueryEscape(marker)
+		if code := listBucket(t, handler, query, &result); code != http.StatusOK {
+			t.Fatalf("%s: %d", query, code)
+		}
+		pages = append(pages, listedNames(result.Contents, result.CommonPrefixes))
+		if !result.IsTruncated {
+			break
+		}
+		marker = result.NextMarker
+	}
+	if got := strings.Join(pages, " "); got != "a.txt photos/* z.txt" {
+		t.Errorf("paging with markers: %q", got)
+	}
+}
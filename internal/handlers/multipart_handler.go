@@ -0,0 +1,418 @@
+package handlers
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"triple-s/internal/models"
+	"triple-s/internal/services"
+)
+
+const (
+	// maxPartNumber is the highest part number accepted for a multipart upload
+	maxPartNumber = 10000
+	// minPartSize is the smallest size allowed for every part except the last one
+	minPartSize = 5 << 20
+)
+
+// writeXMLResult marshals a successful API result and writes it with status 200
+func writeXMLResult(w http.ResponseWriter, result interface{}) {
+	xmlData, err := xml.MarshalIndent(result, "", "  ")
+	if err != nil {
+		WriteXMLResponse(w, http.StatusInternalServerError, "Error generating XML")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(xmlData)
+}
+
+// findUpload loads a multipart upload and checks that it belongs to the object key.
+// It writes an error response and returns false when the upload does not exist.
+func findUpload(w http.ResponseWriter, r *http.Request, directoryPath, bucketName, objectKey string) (models.MultipartUpload, bool) {
+	upload, err := services.ReadMultipartUpload(directoryPath, bucketName, r.URL.Query().Get("uploadId"))
+	if err == services.ErrUploadNotFound || (err == nil && upload.Key != objectKey) {
+		WriteXMLResponse(w, http.StatusNotFound, "The specified upload does not exist")
+		return models.MultipartUpload{}, false
+	} else if err != nil {
+		WriteXMLResponse(w, http.StatusInternalServerError, "Error reading upload: "+err.Error())
+		return models.MultipartUpload{}, false
+	}
+	return upload, true
+}
+
+// HandlerCreateMultipartUpload handles POST ?uploads requests starting a multipart upload
+func HandlerCreateMultipartUpload(w http.ResponseWriter, r *http.Request, directoryPath string, bucketName, objectKey string) {
+	if objectKey == "objects.csv" {
+		WriteXMLResponse(w, http.StatusForbidden, "Cannot overwrite metadata file")
+		return
+	}
+
+	// Check if the bucket exists
+	if _, err := os.Stat(directoryPath + bucketName); os.IsNotExist(err) {
+		WriteXMLResponse(w, http.StatusNotFound, "Bucket not found")
+		return
+	}
+
+	metadata, ok := requestMetadata(w, r)
+	if !ok {
+		return
+	}
+	tags, ok := requestTags(w, r)
+	if !ok {
+		return
+	}
+	acl, ok := requestACL(w, r)
+	if !ok {
+		return
+	}
+
+	uploadID, err := services.CreateMultipartUpload(directoryPath, bucketName, objectKey, r.Header.Get("Content-Type"), metadata, tags, acl)
+	if err != nil {
+		WriteXMLResponse(w, http.StatusInternalServerError, "Error creating upload: "+err.Error())
+		return
+	}
+
+	writeXMLResult(w, models.InitiateMultipartUploadResult{
+		Bucket:   bucketName,
+		Key:      objectKey,
+		UploadID: uploadID,
+	})
+}
+
+// HandlerUploadPart handles PUT ?partNumber&uploadId requests storing one part of a multipart upload
+func HandlerUploadPart(w http.ResponseWriter, r *http.Request, directoryPath string, bucketName, objectKey string) {
+	defer r.Body.Close()
+
+	partNumber, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > maxPartNumber {
+		WriteXMLResponse(w, http.StatusBadRequest, "Part number must be an integer between 1 and 10000")
+		return
+	}
+
+	if _, ok := findUpload(w, r, directoryPath, bucketName, objectKey); !ok {
+		return
+	}
+
+	// Stage the part content while hashing it, so that a failed upload keeps the previous part
+	staged, err := services.StageObject(directoryPath, bucketName)
+	if err != nil {
+		WriteXMLResponse(w, http.StatusInternalServerError, "Error creating part")
+		return
+	}
+	defer staged.Discard()
+
+	hash := md5.New()
+	size, err := io.Copy(io.MultiWriter(staged, hash), r.Body)
+	if err != nil {
+		if bodyVerificationFailed(w, r, err) {
+			return
+		}
+		if err == io.ErrUnexpectedEOF {
+			WriteXMLResponse(w, http.StatusBadRequest, "Request body is shorter than its Content-Length")
+			return
+		}
+		WriteXMLResponse(w, http.StatusInternalServerError, "Error writing part data")
+		return
+	}
+	if r.ContentLength >= 0 && size != r.ContentLength {
+		WriteXMLResponse(w, http.StatusBadRequest, "Request body does not match its Content-Length")
+		return
+	}
+
+	// Commit the part under the lock of the upload, once it is known to be still in progress,
+	// so that a concurrent abort or completion cannot leave a stray part directory behind
+	defer services.LockUpload(directoryPath, bucketName, r.URL.Query().Get("uploadId"))()
+	if _, ok := findUpload(w, r, directoryPath, bucketName, objectKey); !ok {
+		return
+	}
+	partPath := services.PartPath(directoryPath, bucketName, r.URL.Query().Get("uploadId"), partNumber)
+	if err := staged.Commit(partPath); err != nil {
+		WriteXMLResponse(w, http.StatusInternalServerError, "Error writing part data")
+		return
+	}
+
+	etag := hex.EncodeToString(hash.Sum(nil))
+	part := models.Part{
+		PartNumber:   partNumber,
+		LastModified: time.Now().Format(time.RFC3339),
+		ETag:         etag,
+		Size:         size,
+	}
+	if err := services.WritePartInfo(directoryPath, bucketName, r.URL.Query().Get("uploadId"), part); err != nil {
+		WriteXMLResponse(w, http.StatusInternalServerError, "Error writing part info: "+err.Error())
+		return
+	}
+
+	w.Header().Set("ETag", "\""+etag+"\"")
+	WriteXMLResponse(w, http.StatusOK, "Part uploaded successfully")
+}
+
+// HandlerCompleteMultipartUpload handles POST ?uploadId requests assembling the listed parts into the object
+func HandlerCompleteMultipartUpload(w http.ResponseWriter, r *http.Request, directoryPath string, bucketName, objectKey string) {
+	defer r.Body.Close()
+
+	// The parts must not change or go away while they are assembled
+	defer services.LockUpload(directoryPath, bucketName, r.URL.Query().Get("uploadId"))()
+	upload, ok := findUpload(w, r, directoryPath, bucketName, objectKey)
+	if !ok {
+		return
+	}
+
+	var request models.CompleteMultipartUpload
+	if err := xml.NewDecoder(r.Body).Decode(&request); err != nil || len(request.Parts) == 0 {
+		WriteXMLResponse(w, http.StatusBadRequest, "Malformed part list")
+		return
+	}
+
+	storedParts, err := services.ListPartInfo(directoryPath, bucketName, upload.UploadID)
+	if err != nil {
+		WriteXMLResponse(w, http.StatusInternalServerError, "Error reading parts: "+err.Error())
+		return
+	}
+	partsByNumber := make(map[int]models.Part, len(storedParts))
+	for _, part := range storedParts {
+		partsByNumber[part.PartNumber] = part
+	}
+
+	// The listed parts must be ascending, already uploaded and large enough
+	for i, completed := range request.Parts {
+		if i > 0 && completed.PartNumber <= request.Parts[i-1].PartNumber {
+			WriteXMLResponse(w, http.StatusBadRequest, "Parts must be listed in ascending order")
+			return
+		}
+		part, found := partsByNumber[completed.PartNumber]
+		if !found || strings.Trim(completed.ETag, `"`) != part.ETag {
+			WriteXMLResponse(w, http.StatusBadRequest, fmt.Sprintf("Part %d was not found or its ETag does not match", completed.PartNumber))
+			return
+		}
+		if i < len(request.Parts)-1 && part.Size < minPartSize {
+			WriteXMLResponse(w, http.StatusBadRequest, fmt.Sprintf("Part %d is smaller than the minimum allowed size", completed.PartNumber))
+			return
+		}
+	}
+
+	objectPath, ok := prepareObjectPath(w, directoryPath, bucketName, objectKey)
+	if !ok {
+		return
+	}
+	staged, err := services.StageObject(directoryPath, bucketName)
+	if err != nil {
+		WriteXMLResponse(w, http.StatusInternalServerError, "Error creating object")
+		return
+	}
+	defer staged.Discard()
+
+	// Concatenate the parts; the ETag is the MD5 of the part digests followed by the part count
+	digests := md5.New()
+	var size int64
+	for _, completed := range request.Parts {
+		n, err := appendPart(staged, services.PartPath(directoryPath, bucketName, upload.UploadID, completed.PartNumber))
+		if err != nil {
+			WriteXMLResponse(w, http.StatusInternalServerError, "Error assembling object")
+			return
+		}
+		size += n
+		digest, _ := hex.DecodeString(partsByNumber[completed.PartNumber].ETag)
+		digests.Write(digest)
+	}
+	etag := fmt.Sprintf("%s-%d", hex.EncodeToString(digests.Sum(nil)), len(request.Parts))
+
+	// Move the assembled object into place once the version it replaces is preserved
+	defer services.LockObject(directoryPath, bucketName, objectKey)()
+	versioning, err := services.BucketVersioning(directoryPath, bucketName)
+	if err != nil {
+		WriteXMLResponse(w, http.StatusInternalServerError, "Error reading bucket info")
+		return
+	}
+	localObject := models.Object{
+		ObjectKey:        objectKey,
+		Size:             size,
+		ContentType:      upload.ContentType,
+		LastModifiedTime: time.Now().Format(time.RFC3339),
+		ETag:             etag,
+		Metadata:         upload.Metadata,
+		Tags:             upload.Tags,
+		VersionID:        services.NextVersionID(versioning),
+		ACL:              upload.ACL,
+	}
+	err = services.ReplaceObject(directoryPath, bucketName, objectKey, versioning, func() (models.Object, error) {
+		return localObject, staged.Commit(objectPath)
+	})
+	if err != nil {
+		writeCommitError(w, err, "Error assembling object: "+err.Error())
+		return
+	}
+
+	// The staged parts are no longer needed once the object is in place
+	if err := services.AbortMultipartUpload(directoryPath, bucketName, upload.UploadID); err != nil {
+		log.Println("Error removing completed upload:", err)
+	}
+
+	if versioning != "" {
+		w.Header().Set("x-amz-version-id", apiVersionID(localObject.VersionID))
+	}
+
+	writeXMLResult(w, models.CompleteMultipartUploadResult{
+		Location: "/" + bucketName + "/" + objectKey,
+		Bucket:   bucketName,
+		Key:      objectKey,
+		ETag:     "\"" + etag + "\"",
+	})
+}
+
+// appendPart copies the content of a staged part to the end of the object file
+func appendPart(dst io.Writer, partPath string) (int64, error) {
+	part, err := os.Open(partPath)
+	if err != nil {
+		return 0, err
+	}
+	defer part.Close()
+	return io.Copy(dst, part)
+}
+
+// HandlerAbortMultipartUpload handles DELETE ?uploadId requests discarding a multipart upload
+func HandlerAbortMultipartUpload(w http.ResponseWriter, r *http.Request, directoryPath string, bucketName, objectKey string) {
+	defer services.LockUpload(directoryPath, bucketName, r.URL.Query().Get("uploadId"))()
+	upload, ok := findUpload(w, r, directoryPath, bucketName, objectKey)
+	if !ok {
+		return
+	}
+
+	if err := services.AbortMultipartUpload(directoryPath, bucketName, upload.UploadID); err != nil {
+		WriteXMLResponse(w, http.StatusInternalServerError, "Error aborting upload: "+err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandlerListParts handles GET ?uploadId requests listing the parts uploaded so far
+func HandlerListParts(w http.ResponseWriter, r *http.Request, directoryPath string, bucketName, objectKey string) {
+	query := r.URL.Query()
+	maxParts := maxListKeys
+	if value := query.Get("max-parts"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			WriteXMLResponse(w, http.StatusBadRequest, "Invalid max-parts value")
+			return
+		}
+		maxParts = min(n, maxListKeys)
+	}
+	marker := 0
+	if value := query.Get("part-number-marker"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			WriteXMLResponse(w, http.StatusBadRequest, "Invalid part-number-marker value")
+			return
+		}
+		marker = n
+	}
+
+	upload, ok := findUpload(w, r, directoryPath, bucketName, objectKey)
+	if !ok {
+		return
+	}
+	parts, err := services.ListPartInfo(directoryPath, bucketName, upload.UploadID)
+	if err != nil {
+		WriteXMLResponse(w, http.StatusInternalServerError, "Error reading parts: "+err.Error())
+		return
+	}
+
+	result := models.ListPartsResult{
+		Bucket:           bucketName,
+		Key:              objectKey,
+		UploadID:         upload.UploadID,
+		Initiator:        defaultOwner,
+		Owner:            defaultOwner,
+		StorageClass:     "STANDARD",
+		PartNumberMarker: marker,
+		MaxParts:         maxParts,
+	}
+	for _, part := range parts {
+		if part.PartNumber <= marker {
+			continue
+		}
+		if len(result.Parts) == maxParts {
+			result.IsTruncated = true
+			break
+		}
+		part.ETag = "\"" + part.ETag + "\""
+		if modTime, err := time.Parse(time.RFC3339, part.LastModified); err == nil {
+			part.LastModified = modTime.UTC().Format(listTimeFormat)
+		}
+		result.Parts = append(result.Parts, part)
+		result.NextPartNumberMarker = part.PartNumber
+	}
+
+	writeXMLResult(w, result)
+}
+
+// HandleListMultipartUploads handles GET /{bucket}?uploads requests listing in-progress uploads
+func HandleListMultipartUploads(w http.ResponseWriter, r *http.Request, directoryPath string) {
+	// Extract bucket name from the URL path
+	bucketName := strings.Trim(r.URL.Path, "/")
+	query := r.URL.Query()
+
+	maxUploads := maxListKeys
+	if value := query.Get("max-uploads"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			writeErrorResponse(w, "Invalid max-uploads value", http.StatusBadRequest)
+			return
+		}
+		maxUploads = min(n, maxListKeys)
+	}
+
+	if _, err := os.Stat(directoryPath + bucketName); err != nil || !ValidateBucketName(bucketName) {
+		writeErrorResponse(w, "Bucket does not exist", http.StatusNotFound)
+		return
+	}
+	uploads, err := services.ListMultipartUploads(directoryPath, bucketName)
+	if err != nil {
+		writeErrorResponse(w, "Error reading uploads", http.StatusInternalServerError)
+		return
+	}
+
+	prefix := query.Get("prefix")
+	keyMarker := query.Get("key-marker")
+	uploadIDMarker := query.Get("upload-id-marker")
+	result := models.ListMultipartUploadsResult{
+		Bucket:         bucketName,
+		KeyMarker:      keyMarker,
+		UploadIDMarker: uploadIDMarker,
+		Prefix:         prefix,
+		MaxUploads:     maxUploads,
+	}
+	for _, upload := range uploads {
+		if !strings.HasPrefix(upload.Key, prefix) {
+			continue
+		}
+		// Uploads of the marker key are skipped up to and including the upload ID marker
+		if upload.Key < keyMarker || (upload.Key == keyMarker && (uploadIDMarker == "" || upload.UploadID <= uploadIDMarker)) {
+			continue
+		}
+		if len(result.Uploads) == maxUploads {
+			result.IsTruncated = true
+			break
+		}
+		if initiated, err := time.Parse(time.RFC3339, upload.Initiated); err == nil {
+			upload.Initiated = initiated.UTC().Format(listTimeFormat)
+		}
+		result.Uploads = append(result.Uploads, upload)
+		result.NextKeyMarker = upload.Key
+		result.NextUploadIDMarker = upload.UploadID
+	}
+
+	writeXMLResult(w, result)
+}
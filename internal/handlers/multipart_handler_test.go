@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"triple-s/internal/models"
+)
+
+// objectHandlerFunc is the signature shared by the object handlers
+type objectHandlerFunc func(http.ResponseWriter, *http.Request, string, string, string)
+
+// callObjectHandler sends a request for bucket/key with the given query to an object handler
+func callObjectHandler(handler objectHandlerFunc, dir, method, bucketName, objectKey, query, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(method, "/"+bucketName+"/"+objectKey+"?"+query, strings.NewReader(body))
+	handler(w, r, dir, bucketName, objectKey)
+	return w
+}
+
+// completeBody lists parts in a CompleteMultipartUpload request body
+func completeBody(parts ...models.CompletedPart) string {
+	data, _ := xml.Marshal(models.CompleteMultipartUpload{Parts: parts})
+	return string(data)
+}
+
+func TestMultipartUpload(t *testing.T) {
+	dir := newDataDir(t)
+	createBucket(t, dir, "uploads")
+
+	w := callObjectHandler(HandlerCreateMultipartUpload, dir, http.MethodPost, "uploads", "video.mp4", "uploads", "")
+	var initiated models.InitiateMultipartUploadResult
+	if w.Code != http.StatusOK || xml.Unmarshal(w.Body.Bytes(), &initiated) != nil || initiated.UploadID == "" {
+		t.Fatalf("initiate: %d %s", w.Code, w.Body)
+	}
+	uploadID := "uploadId=" + initiated.UploadID
+
+	bodies := map[int]string{1: strings.Repeat("a", minPartSize), 2: "tail", 3: "small"}
+	etags := make(map[int]string)
+	for number := 1; number <= 3; number++ {
+		w := callObjectHandler(HandlerUploadPart, dir, http.MethodPut, "uploads", "video.mp4", fmt.Sprintf("partNumber=%d&%s", number, uploadID), bodies[number])
+		if w.Code != http.StatusOK {
+			t.Fatalf("part %d: %d %s", number, w.Code, w.Body)
+		}
+		etags[number] = w.Header().Get("ETag")
+	}
+
+	uploadTests := []struct {
+		name  string
+		key   string
+		query string
+		want  int
+	}{
+		{"part number 0", "video.mp4", "partNumber=0&" + uploadID, http.StatusBadRequest},
+		{"part number 10001", "video.mp4", "partNumber=10001&" + uploadID, http.StatusBadRequest},
+		{"unknown upload", "video.mp4", "partNumber=1&uploadId=missing", http.StatusNotFound},
+		{"upload of another key", "other.mp4", "partNumber=1&" + uploadID, http.StatusNotFound},
+	}
+	for _, test := range uploadTests {
+		if w := callObjectHandler(HandlerUploadPart, dir, http.MethodPut, "uploads", test.key, test.query, "x"); w.Code != test.want {
+			t.Errorf("%s: %d, want %d", test.name, w.Code, test.want)
+		}
+	}
+
+	w = callObjectHandler(HandlerListParts, dir, http.MethodGet, "uploads", "video.mp4", uploadID+"&max-parts=2", "")
+	var listed models.ListPartsResult
+	if w.Code != http.StatusOK || xml.Unmarshal(w.Body.Bytes(), &listed) != nil {
+		t.Fatalf("list parts: %d %s", w.Code, w.Body)
+	}
+	if len(listed.Parts) != 2 || !listed.IsTruncated || listed.NextPartNumberMarker != 2 || listed.Parts[0].ETag != etags[1] {
+		t.Errorf("list parts: %+v", listed)
+	}
+
+	part := func(number int) models.CompletedPart {
+		return models.CompletedPart{PartNumber: number, ETag: etags[number]}
+	}
+	completeTests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"malformed body", "<CompleteMultipartUpload>", http.StatusBadRequest},
+		{"no parts", completeBody(), http.StatusBadRequest},
+		{"descending parts", completeBody(part(2), part(1)), http.StatusBadRequest},
+		{"stale ETag", completeBody(part(1), models.CompletedPart{PartNumber: 2, ETag: `"stale"`}), http.StatusBadRequest},
+		{"missing part", completeBody(part(1), models.CompletedPart{PartNumber: 4, ETag: etags[1]}), http.StatusBadRequest},
+		{"small part before the last", completeBody(part(2), part(3)), http.StatusBadRequest},
+		{"complete", completeBody(part(1), part(2)), http.StatusOK},
+	}
+	for _, test := range completeTests {
+		if w := callObjectHandler(HandlerCompleteMultipartUpload, dir, http.MethodPost, "uploads", "video.mp4", uploadID, test.body); w.Code != test.want {
+			t.Errorf("%s: %d, want %d: %s", test.name, w.Code, test.want, w.Body)
+		}
+	}
+
+	// The object is the concatenation of the listed parts, and the upload is gone
+	w = getObject(dir, "uploads", "video.mp4", nil)
+	if w.Code != http.StatusOK || w.Body.String() != bodies[1]+bodies[2] {
+		t.Errorf("GET completed object: %d, %d bytes", w.Code, w.Body.Len())
+	}
+	if etag := w.Header().Get("ETag"); !strings.HasSuffix(etag, `-2"`) {
+		t.Errorf("ETag of the completed object: %s", etag)
+	}
+	if w := callObjectHandler(HandlerListParts, dir, http.MethodGet, "uploads", "video.mp4", uploadID, ""); w.Code != http.StatusNotFound {
+		t.Errorf("list parts of a completed upload: %d", w.Code)
+	}
+}
+
+func TestAbortMultipartUpload(t *testing.T) {
+	dir := newDataDir(t)
+	createBucket(t, dir, "uploads")
+
+	w := callObjectHandler(HandlerCreateMultipartUpload, dir, http.MethodPost, "uploads", "video.mp4", "uploads", "")
+	var initiated models.InitiateMultipartUploadResult
+	if w.Code != http.StatusOK || xml.Unmarshal(w.Body.Bytes(), &initiated) != nil {
+		t.Fatalf("initiate: %d %s", w.Code, w.Body)
+	}
+	uploadID := "uploadId=" + initiated.UploadID
+	if w := callObjectHandler(HandlerUploadPart, dir, http.MethodPut, "uploads", "video.mp4", "partNumber=1&"+uploadID, "data"); w.Code != http.StatusOK {
+		t.Fatalf("part: %d %s", w.Code, w.Body)
+	}
+
+	tests := []struct {
+		name    string
+		handler objectHandlerFunc
+		method  string
+		query   string
+		want    int
+	}{
+		{"abort", HandlerAbortMultipartUpload, http.MethodDelete, uploadID, http.StatusNoContent},
+		{"abort again", HandlerAbortMultipartUpload, http.MethodDelete, uploadID, http.StatusNotFound},
+		{"upload to an aborted upload", HandlerUploadPart, http.MethodPut, "partNumber=2&" + uploadID, http.StatusNotFound},
+		{"list parts of an aborted upload", HandlerListParts, http.MethodGet, uploadID, http.StatusNotFound},
+		{"complete an aborted upload", HandlerCompleteMultipartUpload, http.MethodPost, uploadID, http.StatusNotFound},
+	}
+	for _, test := range tests {
+		if w := callObjectHandler(test.handler, dir, test.method, "uploads", "video.mp4", test.query, "data"); w.Code != test.want {
+			t.Errorf("%s: %d, want %d", test.name, w.Code, test.want)
+		}
+	}
+	if w := getObject(dir, "uploads", "video.mp4", nil); w.Code != http.StatusNotFound {
+		t.Errorf("aborted upload stored the object: %d", w.Code)
+	}
+}
@@ -1,14 +1,17 @@
 package handlers
 
 import (
-	"encoding/base64"
-	"encoding/csv"
+	"crypto/md5"
+	"encoding/hex"
 	"encoding/xml"
+	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
-	"strings"
+	"time"
 
 	"triple-s/internal/models"
 	"triple-s/internal/services"
@@ -39,10 +42,9 @@ func WriteXMLResponse(w http.ResponseWriter, status int, message string) {
 func HandlerPutObject(w http.ResponseWriter, r *http.Request, directoryPath string, bucketName, objectKey string) {
 	defer r.Body.Close()
 
-	// Validate the URL structure
-	count := strings.Count(r.URL.Path, "/")
-	if count != 2 {
-		WriteXMLResponse(w, http.StatusBadRequest, "Invalid URL path format")
+	// Prevent overwriting the objects.csv metadata file
+	if objectKey == "objects.csv" {
+		WriteXMLResponse(w, http.StatusForbidden, "Cannot overwrite metadata file")
 		return
 	}
 
@@ -53,28 +55,86 @@ func HandlerPutObject(w http.ResponseWriter, r *http.Request, directoryPath stri
 		return
 	}
 
-	// Create the object
-	objectPath := bucketPath + "/" + objectKey
-	newFile, err := os.Create(objectPath)
+	// Evaluate conditional headers against the object being replaced
+	if status := checkWritePreconditions(r, currentObject(directoryPath, bucketName, objectKey)); status != 0 {
+		WriteXMLResponse(w, status, "At least one of the preconditions you specified did not hold")
+		return
+	}
+	if _, ok := requestMetadata(w, r); !ok {
+		return
+	}
+	if _, ok := requestTags(w, r); !ok {
+		return
+	}
+	if _, ok := requestACL(w, r); !ok {
+		return
+	}
+
+	// Resolve the object file and check that its key does not collide with another object
+	objectPath, ok := prepareObjectPath(w, directoryPath, bucketName, objectKey)
+	if !ok {
+		return
+	}
+
+	// Stage the request body while hashing it, so that readers never see a partial object
+	staged, err := services.StageObject(directoryPath, bucketName)
 	if err != nil {
 		WriteXMLResponse(w, http.StatusInternalServerError, "Error creating object")
 		return
 	}
-	defer newFile.Close()
+	defer staged.Discard()
 
-	// Write object data from the request body to the file
-	if _, err := io.Copy(newFile, r.Body); err != nil {
+	hash := md5.New()
+	if _, err := io.Copy(io.MultiWriter(staged, hash), r.Body); err != nil {
+		if bodyVerificationFailed(w, r, err) {
+			return
+		}
+		if err == io.ErrUnexpectedEOF {
+			WriteXMLResponse(w, http.StatusBadRequest, "Request body is shorter than its Content-Length")
+			return
+		}
 		WriteXMLResponse(w, http.StatusInternalServerError, "Error writing object data")
 		return
 	}
+	if r.ContentLength >= 0 && staged.Size() != r.ContentLength {
+		WriteXMLResponse(w, http.StatusBadRequest, "Request body does not match its Content-Length")
+		return
+	}
+	etag := hex.EncodeToString(hash.Sum(nil))
+
+	// Replace the object under its lock, evaluating the conditions again now that no other
+	// request can change it
+	defer services.LockObject(directoryPath, bucketName, objectKey)()
+	if status := checkWritePreconditions(r, currentObject(directoryPath, bucketName, objectKey)); status != 0 {
+		WriteXMLResponse(w, status, "At least one of the preconditions you specified did not hold")
+		return
+	}
+
+	// Keep the version being replaced when the bucket has versioning configured
+	versioning, err := services.BucketVersioning(directoryPath, bucketName)
+	if err != nil {
+		WriteXMLResponse(w, http.StatusInternalServerError, "Error reading bucket info")
+		return
+	}
+	versionID := services.NextVersionID(versioning)
 
-	// Store object metadata
-	if err := services.WriteObjectInfo(r, directoryPath, bucketName, objectKey); err != nil {
-		WriteXMLResponse(w, http.StatusInternalServerError, "Error writing object info: "+err.Error())
+	// Move the complete object into place, then store its metadata
+	err = services.ReplaceObject(directoryPath, bucketName, objectKey, versioning, func() (models.Object, error) {
+		if err := staged.Commit(objectPath); err != nil {
+			return models.Object{}, err
+		}
+		return services.NewObjectInfo(r, directoryPath, bucketName, objectKey, etag, versionID)
+	})
+	if err != nil {
+		writeCommitError(w, err, "Error writing object: "+err.Error())
 		return
 	}
 
 	// Respond with success
+	w.Header().Set("ETag", "\""+etag+"\"")
+	if versioning != "" {
+		w.Header().Set("x-amz-version-id", apiVersionID(versionID))
+	}
 	WriteXMLResponse(w, http.StatusOK, "Object created successfully")
 }
 
@@ -93,124 +153,307 @@ func HandlerDeleteObject(w http.ResponseWriter, r *http.Request, directoryPath s
 		return
 	}
 
+	defer services.LockObject(directoryPath, bucketName, objectKey)()
+
+	// Buckets with versioning configured keep the deleted data behind a delete marker
+	if r.URL.Query().Has("versionId") {
+		deleteObjectVersion(w, r, directoryPath, bucketName, objectKey)
+		return
+	}
+	versioning, err := services.BucketVersioning(directoryPath, bucketName)
+	if err != nil {
+		WriteXMLResponse(w, http.StatusInternalServerError, "Error reading bucket info")
+		return
+	}
+	if versioning != "" {
+		if status := checkWritePreconditions(r, currentObject(directoryPath, bucketName, objectKey)); status != 0 {
+			WriteXMLResponse(w, status, "At least one of the preconditions you specified did not hold")
+			return
+		}
+		outcome, err := services.CreateDeleteMarker(directoryPath, bucketName, objectKey, versioning)
+		if err != nil {
+			WriteXMLResponse(w, http.StatusInternalServerError, "Error deleting object: "+err.Error())
+			return
+		}
+		setDeleteOutcomeHeaders(w, outcome)
+		WriteXMLResponse(w, http.StatusOK, "Object successfully deleted")
+		return
+	}
+
 	// Check if the object exists
-	objectPath := bucketPath + "/" + objectKey
-	if _, err := os.Stat(objectPath); os.IsNotExist(err) {
+	objectPath, err := services.ObjectPath(directoryPath, bucketName, objectKey)
+	if err != nil {
+		WriteXMLResponse(w, http.StatusBadRequest, "Invalid object key")
+		return
+	}
+	if info, err := os.Stat(objectPath); err != nil || info.IsDir() {
 		WriteXMLResponse(w, http.StatusNotFound, "Object does not exist")
 		return
 	}
 
-	// Open the objects CSV file
-	file, err := os.Open(bucketPath + "/objects.csv")
-	if err != nil {
-		WriteXMLResponse(w, http.StatusInternalServerError, "Cannot open metadata file")
+	// Evaluate conditional headers against the object being removed
+	if status := checkWritePreconditions(r, currentObject(directoryPath, bucketName, objectKey)); status != 0 {
+		WriteXMLResponse(w, status, "At least one of the preconditions you specified did not hold")
 		return
 	}
-	defer file.Close()
 
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
-	if err != nil {
-		WriteXMLResponse(w, http.StatusInternalServerError, "Cannot read metadata file")
+	// Delete the actual object file and the directories it leaves empty
+	if err := os.Remove(objectPath); err != nil {
+		WriteXMLResponse(w, http.StatusInternalServerError, "Error deleting object")
+		return
+	}
+	services.RemoveEmptyParents(directoryPath, bucketName, objectPath)
+
+	// Remove the object from the metadata file
+	if err := services.DeleteObjectInfos(directoryPath, bucketName, []string{objectKey}); err != nil {
+		WriteXMLResponse(w, http.StatusInternalServerError, "Error writing metadata file")
+		return
+	}
+
+	// Respond with success
+	WriteXMLResponse(w, http.StatusOK, "Object successfully deleted")
+}
+
+// HandlerGetObject handles retrieving an object. The stored file is streamed back
+// unless the ?metadata query is present, in which case the XML description is returned.
+func HandlerGetObject(w http.ResponseWriter, r *http.Request, directoryPath string, bucketName, objectKey string) {
+	// Find the object metadata and the data of the requested version
+	localObject, objectPath, ok := resolveObject(w, r, directoryPath, bucketName, objectKey)
+	if !ok {
+		return
+	}
+
+	// Evaluate conditional headers
+	switch checkReadPreconditions(r, localObject) {
+	case http.StatusNotModified:
+		writeNotModified(w, localObject)
+		return
+	case http.StatusPreconditionFailed:
+		WriteXMLResponse(w, http.StatusPreconditionFailed, "At least one of the preconditions you specified did not hold")
 		return
 	}
 
-	// Filter out the object to delete
-	var newRecords [][]string
-	for _, v := range records {
-		decodedName, err := base64.StdEncoding.DecodeString(v[0])
+	if r.URL.Query().Has("metadata") {
+		// Marshal the object info to XML
+		x, err := xml.MarshalIndent(localObject, "", " ")
 		if err != nil {
-			WriteXMLResponse(w, http.StatusInternalServerError, "Error decoding object name")
+			WriteXMLResponse(w, http.StatusInternalServerError, "Error generating XML")
 			return
 		}
-		if string(decodedName) != objectKey {
-			newRecords = append(newRecords, v)
-		} else {
-			// Delete the actual object file
-			if err := os.Remove(objectPath); err != nil {
-				WriteXMLResponse(w, http.StatusInternalServerError, "Error deleting object")
-				return
-			}
-		}
+
+		// Set content type and write the response
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusOK)
+		w.Write(x)
+		return
 	}
 
-	// Write the updated records back to the objects CSV file
-	file, err = os.Create(bucketPath + "/objects.csv")
+	// Open the stored object data
+	file, err := os.Open(objectPath)
 	if err != nil {
-		WriteXMLResponse(w, http.StatusInternalServerError, "Error writing metadata file")
+		WriteXMLResponse(w, http.StatusInternalServerError, "Cannot open object")
 		return
 	}
 	defer file.Close()
 
-	writer := csv.NewWriter(file)
-	if err := writer.WriteAll(newRecords); err != nil {
-		WriteXMLResponse(w, http.StatusInternalServerError, "Error writing to CSV file")
+	// Malformed Range headers are ignored and the whole object is returned
+	var ranges []byteRange
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+		ranges, err = parseRange(rangeHeader, localObject.Size)
+		if err == errUnsatisfiableRange {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", localObject.Size))
+			w.Header().Set("Content-Type", "application/xml")
+			WriteXMLResponse(w, http.StatusRequestedRangeNotSatisfiable, "The requested range is not satisfiable")
+			return
+		}
+	}
+
+	// Stream the object body with its stored metadata
+	setObjectHeaders(w, localObject)
+	if len(ranges) > 0 {
+		serveRanges(w, file, localObject, ranges)
 		return
 	}
-	writer.Flush()
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, file); err != nil {
+		log.Println("Error streaming object:", err)
+	}
+}
 
-	// Respond with success
-	WriteXMLResponse(w, http.StatusOK, "Object successfully deleted")
+// setObjectHeaders sets the representation headers of an object from its metadata
+func setObjectHeaders(w http.ResponseWriter, object models.Object) {
+	contentType := object.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(object.Size, 10))
+	w.Header().Set("ETag", objectETag(object))
+	w.Header().Set("Accept-Ranges", "bytes")
+	for name, value := range object.Metadata {
+		w.Header()[http.CanonicalHeaderKey(name)] = []string{value}
+	}
+	setTaggingCountHeader(w, object)
+	if object.VersionID != "" {
+		w.Header().Set("x-amz-version-id", object.VersionID)
+	}
+	if modTime, err := time.Parse(time.RFC3339, object.LastModifiedTime); err == nil {
+		w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
 }
 
-// HandlerGetObject handles retrieving an object.
-func HandlerGetObject(w http.ResponseWriter, r *http.Request, directoryPath string, bucketName, objectKey string) {
-	bucketPath := directoryPath + bucketName
+// resolveObject finds the object version addressed by a GET or HEAD request and the path of
+// its data. It writes an error response and returns false when there is nothing to serve.
+func resolveObject(w http.ResponseWriter, r *http.Request, directoryPath, bucketName, objectKey string) (models.Object, string, bool) {
 	// Check if bucket exists
-	if _, err := os.Stat(bucketPath); os.IsNotExist(err) {
+	if _, err := os.Stat(directoryPath + bucketName); os.IsNotExist(err) {
 		WriteXMLResponse(w, http.StatusNotFound, "Bucket does not exist")
-		return
+		return models.Object{}, "", false
+	}
+
+	// A specific version may be current, noncurrent or a delete marker
+	query := r.URL.Query()
+	if query.Has("versionId") {
+		version, dataPath, err := services.ReadObjectVersion(directoryPath, bucketName, objectKey, storedVersionID(query.Get("versionId")))
+		if err == services.ErrVersionNotFound {
+			WriteXMLResponse(w, http.StatusNotFound, "The specified version does not exist")
+			return models.Object{}, "", false
+		} else if err != nil {
+			WriteXMLResponse(w, http.StatusInternalServerError, "Cannot read metadata file")
+			return models.Object{}, "", false
+		}
+		if version.IsDeleteMarker {
+			setDeleteOutcomeHeaders(w, services.DeleteOutcome{VersionID: version.Object.VersionID, DeleteMarker: true})
+			WriteXMLResponse(w, http.StatusMethodNotAllowed, "The specified method is not allowed against a delete marker")
+			return models.Object{}, "", false
+		}
+		w.Header().Set("x-amz-version-id", apiVersionID(version.Object.VersionID))
+		return version.Object, dataPath, true
 	}
-	contType := r.Header.Get("Content-Type")
+
 	// Check if the object exists
-	objectPath := bucketPath + "/" + objectKey
-	if _, err := os.Stat(objectPath); os.IsNotExist(err) {
+	objectPath, err := services.ObjectPath(directoryPath, bucketName, objectKey)
+	if err != nil {
+		WriteXMLResponse(w, http.StatusBadRequest, "Invalid object key")
+		return models.Object{}, "", false
+	}
+	localObject, err := services.ReadObjectInfo(directoryPath, bucketName, objectKey)
+	if err == services.ErrObjectNotFound {
+		// Report the delete marker hiding the object, if any
+		if marker, ok := services.LatestDeleteMarker(directoryPath, bucketName, objectKey); ok {
+			setDeleteOutcomeHeaders(w, services.DeleteOutcome{VersionID: marker.Object.VersionID, DeleteMarker: true})
+		}
 		WriteXMLResponse(w, http.StatusNotFound, "Object does not exist")
-		return
+		return models.Object{}, "", false
+	} else if err != nil {
+		WriteXMLResponse(w, http.StatusInternalServerError, "Cannot read metadata file")
+		return models.Object{}, "", false
+	}
+	if info, err := os.Stat(objectPath); err != nil || info.IsDir() {
+		WriteXMLResponse(w, http.StatusNotFound, "Object does not exist")
+		return models.Object{}, "", false
 	}
+	return localObject, objectPath, true
+}
+
+// maxUserMetadataSize is the limit on user-defined metadata of an object, as in Amazon S3
+const maxUserMetadataSize = 2 << 10
+
+// requestMetadata extracts the metadata headers to store with an object. It writes an
+// error response and returns false when the user-defined metadata exceeds 2 KB.
+func requestMetadata(w http.ResponseWriter, r *http.Request) (map[string]string, bool) {
+	metadata := services.ExtractObjectMetadata(r.Header)
+	if services.UserMetadataSize(metadata) > maxUserMetadataSize {
+		WriteXMLResponse(w, http.StatusBadRequest, "Your metadata headers exceed the maximum allowed metadata size")
+		return nil, false
+	}
+	return metadata, true
+}
 
-	// Open the objects CSV file
-	file, err := os.Open(bucketPath + "/objects.csv")
+// prepareObjectPath resolves the file an object is written to. It writes an error response
+// and returns false when the key collides with an existing object or key prefix. The
+// directories of a hierarchical key are created when the object is committed.
+func prepareObjectPath(w http.ResponseWriter, directoryPath, bucketName, objectKey string) (string, bool) {
+	objectPath, err := services.ObjectPath(directoryPath, bucketName, objectKey)
 	if err != nil {
-		WriteXMLResponse(w, http.StatusInternalServerError, "Cannot open objects metadata file")
+		WriteXMLResponse(w, http.StatusBadRequest, "Invalid object key")
+		return "", false
+	}
+	if info, err := os.Stat(objectPath); err == nil && info.IsDir() {
+		WriteXMLResponse(w, http.StatusConflict, "Object key conflicts with an existing key prefix")
+		return "", false
+	}
+	bucketPath := filepath.Clean(directoryPath + bucketName)
+	for dir := filepath.Dir(objectPath); dir != bucketPath; dir = filepath.Dir(dir) {
+		if info, err := os.Stat(dir); err == nil && !info.IsDir() {
+			WriteXMLResponse(w, http.StatusConflict, "Object key conflicts with an existing object")
+			return "", false
+		}
+	}
+	return objectPath, true
+}
+
+// writeCommitError answers a write whose data could not be moved into place
+func writeCommitError(w http.ResponseWriter, err error, message string) {
+	if err == services.ErrKeyConflict {
+		WriteXMLResponse(w, http.StatusConflict, "Object key conflicts with an existing object")
 		return
 	}
-	defer file.Close()
+	WriteXMLResponse(w, http.StatusInternalServerError, message)
+}
+
+// writeNotModified answers a conditional read whose cached copy is still current
+func writeNotModified(w http.ResponseWriter, object models.Object) {
+	w.Header().Set("ETag", objectETag(object))
+	if modTime, err := time.Parse(time.RFC3339, object.LastModifiedTime); err == nil {
+		w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+	w.WriteHeader(http.StatusNotModified)
+}
 
-	reader := csv.NewReader(file)
-	var localObject models.Object
-	records, err := reader.ReadAll()
+// currentObject returns the stored metadata of an object, or nil when it does not exist
+func currentObject(directoryPath, bucketName, objectKey string) *models.Object {
+	localObject, err := services.ReadObjectInfo(directoryPath, bucketName, objectKey)
 	if err != nil {
-		WriteXMLResponse(w, http.StatusInternalServerError, "Cannot read metadata file")
+		return nil
+	}
+	return &localObject
+}
+
+// objectETag returns the quoted entity tag of an object. Objects stored before
+// content hashes were recorded fall back to a tag built from size and modification time.
+func objectETag(object models.Object) string {
+	if object.ETag != "" {
+		return "\"" + object.ETag + "\""
+	}
+	modTime, _ := time.Parse(time.RFC3339, object.LastModifiedTime)
+	return fmt.Sprintf("\"%x-%x\"", modTime.Unix(), object.Size)
+}
+
+// HandlerHeadObject handles HEAD requests returning the object metadata as headers without a body
+func HandlerHeadObject(w http.ResponseWriter, r *http.Request, directoryPath string, bucketName, objectKey string) {
+	// The metadata file is not an object
+	if objectKey == "objects.csv" {
+		w.WriteHeader(http.StatusForbidden)
 		return
 	}
 
-	// Find the object metadata
-	for _, v := range records {
-		name, err := base64.StdEncoding.DecodeString(v[0])
-		if err != nil {
-			WriteXMLResponse(w, http.StatusInternalServerError, "Error decoding object name")
-			return
-		}
-		if string(name) == objectKey {
-			localObject.ObjectKey = objectKey
-			size, _ := strconv.Atoi(v[1])
-			localObject.Size = int64(size)
-			localObject.ContentType = contType
-			modTime, _ := base64.StdEncoding.DecodeString(v[3])
-			localObject.LastModifiedTime = string(modTime)
-		}
+	// Error bodies written while resolving the object are discarded for HEAD requests
+	localObject, _, ok := resolveObject(w, r, directoryPath, bucketName, objectKey)
+	if !ok {
+		return
 	}
 
-	// Marshal the object info to XML
-	x, err := xml.MarshalIndent(localObject, "", " ")
-	if err != nil {
-		WriteXMLResponse(w, http.StatusInternalServerError, "Error generating XML")
+	// Evaluate conditional headers
+	switch checkReadPreconditions(r, localObject) {
+	case http.StatusNotModified:
+		writeNotModified(w, localObject)
+		return
+	case http.StatusPreconditionFailed:
+		w.WriteHeader(http.StatusPreconditionFailed)
 		return
 	}
 
-	// Set content type and write the response
+	setObjectHeaders(w, localObject)
 	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/xml")
-	w.Write(x)
 }
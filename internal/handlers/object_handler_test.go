@@ -0,0 +1,313 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"triple-s/internal/services"
+)
+
+// putObjectWith uploads an object through the handler with the given request headers
+func putObjectWith(dir, bucketName, objectKey, body string, header http.Header) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPut, "/"+bucketName+"/"+objectKey, strings.NewReader(body))
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	HandlerPutObject(w, r, dir, bucketName, objectKey)
+	return w
+}
+
+// getObject downloads an object through the handler with the given request headers
+func getObject(dir, bucketName, objectKey string, header http.Header) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/"+bucketName+"/"+objectKey, nil)
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	HandlerGetObject(w, r, dir, bucketName, objectKey)
+	return w
+}
+
+func TestFolderMarkerKeys(t *testing.T) {
+	dir := newDataDir(t)
+	createBucket(t, dir, "console")
+
+	// Folder markers, keys with empty segments and the keys under them do not collide
+	objects := map[string]string{
+		"photos/":         "",
+		"photos/cat.jpg":  "cat",
+		"photos//dog.jpg": "dog",
+		"photos//":        "marker",
+		"/root.txt":       "root",
+	}
+	for key, body := range objects {
+		if w := putObject(t, dir, "console", key, body); w.Code != http.StatusOK {
+			t.Fatalf("PUT %q: %d %s", key, w.Code, w.Body)
+		}
+	}
+	for key, body := range objects {
+		if w := getObject(dir, "console", key, nil); w.Code != http.StatusOK || w.Body.String() != body {
+			t.Errorf("GET %q: %d %q, want %q", key, w.Code, w.Body, body)
+		}
+	}
+
+	// Deleting the folder marker keeps the objects inside the folder
+	w := httptest.NewRecorder()
+	HandlerDeleteObject(w, httptest.NewRequest(http.MethodDelete, "/console/photos/", nil), dir, "console", "photos/")
+	if w.Code != http.StatusOK {
+		t.Fatalf("DELETE photos/: %d %s", w.Code, w.Body)
+	}
+	if w := getObject(dir, "console", "photos/", nil); w.Code != http.StatusNotFound {
+		t.Errorf("GET deleted folder marker: %d", w.Code)
+	}
+	if w := getObject(dir, "console", "photos/cat.jpg", nil); w.Code != http.StatusOK {
+		t.Errorf("GET photos/cat.jpg after deleting the marker: %d", w.Code)
+	}
+
+	// A key cannot be stored where another object holds one of its prefixes
+	if w := putObject(t, dir, "console", "photos/cat.jpg/", "x"); w.Code != http.StatusConflict {
+		t.Errorf("PUT under an object: %d", w.Code)
+	}
+}
+
+func TestGetObjectStreamsStoredContent(t *testing.T) {
+	dir := newDataDir(t)
+	createBucket(t, dir, "files")
+
+	tests := []struct {
+		key         string
+		body        string
+		contentType string
+		wantType    string
+	}{
+		{"notes.txt", "hello, world", "text/plain", "text/plain"},
+		{"data.bin", "\x00\x01\x02\xff", "", "application/octet-stream"},
+		{"empty", "", "text/plain", "text/plain"},
+		{"large", strings.Repeat("0123456789", 100000), "application/x-large", "application/x-large"},
+	}
+	for _, test := range tests {
+		header := http.Header{}
+		if test.contentType != "" {
+			header.Set("Content-Type", test.contentType)
+		}
+		if w := putObjectWith(dir, "files", test.key, test.body, header); w.Code != http.StatusOK {
+			t.Fatalf("PUT %s: %d %s", test.key, w.Code, w.Body)
+		}
+		w := getObject(dir, "files", test.key, nil)
+		if w.Code != http.StatusOK || w.Body.String() != test.body {
+			t.Errorf("GET %s: %d, %d bytes, want the %d stored bytes", test.key, w.Code, w.Body.Len(), len(test.body))
+		}
+		if got := w.Header().Get("Content-Type"); got != test.wantType {
+			t.Errorf("GET %s: Content-Type %q, want %q", test.key, got, test.wantType)
+		}
+		if got := w.Header().Get("Content-Length"); got != strconv.Itoa(len(test.body)) {
+			t.Errorf("GET %s: Content-Length %s, want %d", test.key, got, len(test.body))
+		}
+		if w.Header().Get("ETag") == "" || w.Header().Get("Last-Modified") == "" {
+			t.Errorf("GET %s: missing ETag or Last-Modified", test.key)
+		}
+	}
+
+	// Missing objects and buckets answer 404 with an XML error
+	for _, path := range [][2]string{{"files", "missing"}, {"nobucket", "notes.txt"}} {
+		if w := getObject(dir, path[0], path[1], nil); w.Code != http.StatusNotFound {
+			t.Errorf("GET %s/%s: %d, want 404", path[0], path[1], w.Code)
+		}
+	}
+}
+
+func TestHeadObject(t *testing.T) {
+	dir := newDataDir(t)
+	createBucket(t, dir, "files")
+	header := http.Header{"Content-Type": {"text/plain"}}
+	if w := putObjectWith(dir, "files", "notes.txt", "hello", header); w.Code != http.StatusOK {
+		t.Fatalf("PUT: %d %s", w.Code, w.Body)
+	}
+	get := getObject(dir, "files", "notes.txt", nil)
+
+	tests := []struct {
+		bucket, key string
+		want        int
+	}{
+		{"files", "notes.txt", http.StatusOK},
+		{"files", "missing", http.StatusNotFound},
+		{"nobucket", "notes.txt", http.StatusNotFound},
+		{"files", "objects.csv", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		HandlerHeadObject(w, httptest.NewRequest(http.MethodHead, "/"+test.bucket+"/"+test.key, nil), dir, test.bucket, test.key)
+		if w.Code != test.want {
+			t.Errorf("HEAD %s/%s: %d, want %d", test.bucket, test.key, w.Code, test.want)
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		// HEAD answers with the headers of GET and no body
+		for _, name := range []string{"Content-Type", "Content-Length", "ETag", "Last-Modified"} {
+			if w.Header().Get(name) != get.Header().Get(name) {
+				t.Errorf("HEAD %s: %s %q, GET sent %q", test.key, name, w.Header().Get(name), get.Header().Get(name))
+			}
+		}
+		if w.Body.Len() != 0 {
+			t.Errorf("HEAD %s: %d bytes of body", test.key, w.Body.Len())
+		}
+	}
+}
+
+// putObject uploads an object through the handler and returns the response
+func putObject(t *testing.T, dir, bucketName, objectKey, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	return putObjectWith(dir, bucketName, objectKey, body, nil)
+}
+
+func TestHierarchicalKeys(t *testing.T) {
+	dir := newDataDir(t)
+	createBucket(t, dir, "tree")
+
+	keys := []string{"a/b/c/deep.txt", "a/b/sibling.txt", "a/top.txt", "unicode/ключ.txt"}
+	for _, key := range keys {
+		if w := putObject(t, dir, "tree", key, "data of "+key); w.Code != http.StatusOK {
+			t.Fatalf("PUT %s: %d %s", key, w.Code, w.Body)
+		}
+	}
+
+	// Deleting a nested key removes the directories it leaves empty and nothing else
+	tests := []struct {
+		deleted string
+		gone    []string
+		kept    []string
+	}{
+		{"a/b/c/deep.txt", []string{"a/b/c"}, []string{"a/b", "a/b/sibling.txt", "a/top.txt"}},
+		{"a/b/sibling.txt", []string{"a/b"}, []string{"a", "a/top.txt"}},
+		{"a/top.txt", []string{"a"}, []string{"unicode/ключ.txt"}},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		HandlerDeleteObject(w, httptest.NewRequest(http.MethodDelete, "/tree/x", nil), dir, "tree", test.deleted)
+		if w.Code != http.StatusOK {
+			t.Fatalf("DELETE %s: %d %s", test.deleted, w.Code, w.Body)
+		}
+		for _, path := range test.gone {
+			if _, err := os.Stat(dir + "tree/" + path); !os.IsNotExist(err) {
+				t.Errorf("after deleting %s: %s still exists", test.deleted, path)
+			}
+		}
+		for _, path := range test.kept {
+			if _, err := os.Stat(dir + "tree/" + path); err != nil {
+				t.Errorf("after deleting %s: %s: %v", test.deleted, path, err)
+			}
+		}
+	}
+	if w := getObject(dir, "tree", "unicode/ключ.txt", nil); w.Code != http.StatusOK || w.Body.String() != "data of unicode/ключ.txt" {
+		t.Errorf("GET unicode/ключ.txt: %d %q", w.Code, w.Body)
+	}
+}
+
+func TestObjectMetadataHeaders(t *testing.T) {
+	dir := newDataDir(t)
+	createBucket(t, dir, "files")
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   int
+		stored map[string]string
+	}{
+		{"user metadata", http.Header{"X-Amz-Meta-Author": {"ada"}, "X-Amz-Meta-Project": {"engine"}}, http.StatusOK,
+			map[string]string{"X-Amz-Meta-Author": "ada", "X-Amz-Meta-Project": "engine"}},
+		{"repeated header", http.Header{"X-Amz-Meta-Tags": {"a", "b"}}, http.StatusOK,
+			map[string]string{"X-Amz-Meta-Tags": "a,b"}},
+		{"representation headers", http.Header{"Cache-Control": {"no-cache"}, "Content-Disposition": {"attachment"}}, http.StatusOK,
+			map[string]string{"Cache-Control": "no-cache", "Content-Disposition": "attachment"}},
+		{"at the size limit", http.Header{"X-Amz-Meta-Big": {strings.Repeat("x", maxUserMetadataSize-len("big"))}}, http.StatusOK, nil},
+		{"over the size limit", http.Header{"X-Amz-Meta-Big": {strings.Repeat("x", maxUserMetadataSize)}}, http.StatusBadRequest, nil},
+	}
+	for i, test := range tests {
+		key := fmt.Sprintf("object-%d", i)
+		if w := putObjectWith(dir, "files", key, "data", test.header); w.Code != test.want {
+			t.Errorf("%s: PUT %d, want %d", test.name, w.Code, test.want)
+			continue
+		}
+		if test.want != http.StatusOK {
+			continue
+		}
+		// GET and HEAD both return the stored headers
+		get := getObject(dir, "files", key, nil)
+		head := httptest.NewRecorder()
+		HandlerHeadObject(head, httptest.NewRequest(http.MethodHead, "/files/"+key, nil), dir, "files", key)
+		for name, value := range test.stored {
+			if got := get.Header().Get(name); got != value {
+				t.Errorf("%s: GET %s %q, want %q", test.name, name, got, value)
+			}
+			if got := head.Header().Get(name); got != value {
+				t.Errorf("%s: HEAD %s %q, want %q", test.name, name, got, value)
+			}
+		}
+	}
+}
+
+// failingReader returns its data and then fails with err, like a client disconnecting
+type failingReader struct {
+	data io.Reader
+	err  error
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	n, err := f.data.Read(p)
+	if err == io.EOF {
+		return n, f.err
+	}
+	return n, err
+}
+
+func TestPutObjectIsAtomic(t *testing.T) {
+	dir := newDataDir(t)
+	createBucket(t, dir, "atomic")
+	putObject(t, dir, "atomic", "doc", "original")
+
+	tests := []struct {
+		name string
+		// body returns a new request body for each attempt
+		body          func() io.Reader
+		contentLength int64
+		want          int
+	}{
+		{"body shorter than its Content-Length", func() io.Reader { return strings.NewReader("short") }, 100, http.StatusBadRequest},
+		{"body longer than its Content-Length", func() io.Reader { return strings.NewReader("replacement") }, 3, http.StatusBadRequest},
+		{"connection closed early", func() io.Reader { return &failingReader{strings.NewReader("partial"), io.ErrUnexpectedEOF} }, 100, http.StatusBadRequest},
+		{"read error", func() io.Reader { return &failingReader{strings.NewReader("partial"), errors.New("connection reset")} }, -1, http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		for _, objectKey := range []string{"doc", "new"} {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPut, "/atomic/"+objectKey, test.body())
+			r.ContentLength = test.contentLength
+			HandlerPutObject(w, r, dir, "atomic", objectKey)
+			if w.Code != test.want {
+				t.Errorf("%s: %d, want %d: %s", test.name, w.Code, test.want, w.Body)
+			}
+		}
+
+		// The previous content stays in place and a failed first write stores nothing
+		if w := getObject(dir, "atomic", "doc", nil); w.Code != http.StatusOK || w.Body.String() != "original" {
+			t.Errorf("%s: object replaced by %d %q", test.name, w.Code, w.Body)
+		}
+		if w := getObject(dir, "atomic", "new", nil); w.Code != http.StatusNotFound {
+			t.Errorf("%s: partial object stored: %d %q", test.name, w.Code, w.Body)
+		}
+		staged, err := os.ReadDir(filepath.Join(dir+"atomic", services.InternalDirName, "tmp"))
+		if err != nil || len(staged) != 0 {
+			t.Errorf("%s: staged data left behind: %v %v", test.name, staged, err)
+		}
+	}
+}
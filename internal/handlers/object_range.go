@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"triple-s/internal/models"
+)
+
+// byteRange is a single inclusive range of bytes requested with the Range header
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+// maxRanges is the number of distinct ranges a request may ask for. Longer range lists are
+// ignored and the whole object is returned, so that a request cannot inflate the response.
+const maxRanges = 100
+
+var (
+	errInvalidRange       = errors.New("invalid range")
+	errUnsatisfiableRange = errors.New("range not satisfiable")
+)
+
+// contentRange formats the Content-Range value of the range for an object of the given size
+func (br byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", br.start, br.start+br.length-1, size)
+}
+
+// parseRange parses a Range header such as "bytes=0-99", "bytes=100-" or "bytes=-50".
+// Ranges starting past the end of the object are dropped, and if none remain
+// errUnsatisfiableRange is returned. Overlapping and adjacent ranges are merged and returned
+// in ascending order, so no byte is sent twice; more than maxRanges distinct ranges are
+// treated as an invalid header.
+func parseRange(header string, size int64) ([]byteRange, error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return nil, errInvalidRange
+	}
+
+	var ranges []byteRange
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		first, last, ok := strings.Cut(part, "-")
+		if !ok {
+			return nil, errInvalidRange
+		}
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+
+		var br byteRange
+		if first == "" {
+			// Suffix range: the final N bytes of the object
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, errInvalidRange
+			}
+			if n == 0 {
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			br = byteRange{start: size - n, length: n}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, errInvalidRange
+			}
+			end := size - 1
+			if last != "" {
+				end, err = strconv.ParseInt(last, 10, 64)
+				if err != nil || end < start {
+					return nil, errInvalidRange
+				}
+				if end >= size {
+					end = size - 1
+				}
+			}
+			if start >= size {
+				continue
+			}
+			br = byteRange{start: start, length: end - start + 1}
+		}
+		if br.length > 0 {
+			ranges = append(ranges, br)
+		}
+	}
+
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiableRange
+	}
+	ranges = coalesceRanges(ranges)
+	if len(ranges) > maxRanges {
+		return nil, errInvalidRange
+	}
+	return ranges, nil
+}
+
+// coalesceRanges sorts ranges by their first byte and merges the ones that overlap or touch
+func coalesceRanges(ranges []byteRange) []byteRange {
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].start < ranges[j].start })
+	merged := ranges[:1]
+	for _, br := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if br.start > last.start+last.length {
+			merged = append(merged, br)
+			continue
+		}
+		if end := br.start + br.length; end > last.start+last.length {
+			last.length = end - last.start
+		}
+	}
+	return merged
+}
+
+// serveRanges answers a ranged GET with 206 Partial Content, using a
+// multipart/byteranges body when more than one range was requested
+func serveRanges(w http.ResponseWriter, file *os.File, object models.Object, ranges []byteRange) {
+	if len(ranges) == 1 {
+		br := ranges[0]
+		if _, err := file.Seek(br.start, io.SeekStart); err != nil {
+			WriteXMLResponse(w, http.StatusInternalServerError, "Cannot read object")
+			return
+		}
+		w.Header().Set("Content-Range", br.contentRange(object.Size))
+		w.Header().Set("Content-Length", strconv.FormatInt(br.length, 10))
+		w.WriteHeader(http.StatusPartialContent)
+		if _, err := io.CopyN(w, file, br.length); err != nil {
+			log.Println("Error streaming object range:", err)
+		}
+		return
+	}
+
+	contentType := w.Header().Get("Content-Type")
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	w.Header().Del("Content-Length")
+	w.WriteHeader(http.StatusPartialContent)
+
+	for _, br := range ranges {
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":  {contentType},
+			"Content-Range": {br.contentRange(object.Size)},
+		})
+		if err != nil {
+			log.Println("Error writing multipart range:", err)
+			return
+		}
+		if _, err := io.Copy(part, io.NewSectionReader(file, br.start, br.length)); err != nil {
+			log.Println("Error streaming object range:", err)
+			return
+		}
+	}
+	mw.Close()
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	const size = 100
+	tests := []struct {
+		header string
+		want   string
+		err    error
+	}{
+		{"bytes=0-9", "0+10", nil},
+		{"bytes=90-", "90+10", nil},
+		{"bytes=-5", "95+5", nil},
+		{"bytes=50-500", "50+50", nil},
+		{"bytes=0-9,20-29", "0+10 20+10", nil},
+		{"bytes=20-29,0-9", "0+10 20+10", nil},
+		{"bytes=0-9,5-14", "0+15", nil},
+		{"bytes=0-4,5-9", "0+10", nil},
+		{"bytes=0-,0-,0-,0-", "0+100", nil},
+		{"bytes=-10,90-", "90+10", nil},
+		{"bytes=0-9,200-", "0+10", nil},
+		{"bytes=200-", "", errUnsatisfiableRange},
+		{"bytes=9-0", "", errInvalidRange},
+		{"items=0-9", "", errInvalidRange},
+	}
+	for _, test := range tests {
+		ranges, err := parseRange(test.header, size)
+		var got []string
+		for _, br := range ranges {
+			got = append(got, fmt.Sprintf("%d+%d", br.start, br.length))
+		}
+		if err != test.err || strings.Join(got, " ") != test.want {
+			t.Errorf("%s: got %v, %v, want %s, %v", test.header, got, err, test.want, test.err)
+		}
+	}
+
+	// Many distinct ranges are ignored rather than answered with a huge multipart body
+	var parts []string
+	for i := 0; i <= maxRanges; i++ {
+		parts = append(parts, fmt.Sprintf("%d-%d", 2*i, 2*i))
+	}
+	if _, err := parseRange("bytes="+strings.Join(parts, ","), 1000); err != errInvalidRange {
+		t.Errorf("%d ranges: got %v, want %v", len(parts), err, errInvalidRange)
+	}
+}
+
+func TestGetObjectRanges(t *testing.T) {
+	dir := newDataDir(t)
+	createBucket(t, dir, "files")
+	const body = "0123456789abcdefghij"
+	if w := putObject(t, dir, "files", "digits", body); w.Code != http.StatusOK {
+		t.Fatalf("PUT: %d %s", w.Code, w.Body)
+	}
+
+	tests := []struct {
+		header       string
+		want         int
+		contentRange string
+		body         string
+	}{
+		{"bytes=0-9", http.StatusPartialContent, "bytes 0-9/20", "0123456789"},
+		{"bytes=15-", http.StatusPartialContent, "bytes 15-19/20", "fghij"},
+		{"bytes=-3", http.StatusPartialContent, "bytes 17-19/20", "hij"},
+		{"bytes=10-100", http.StatusPartialContent, "bytes 10-19/20", "abcdefghij"},
+		{"bytes=0-4,3-7", http.StatusPartialContent, "bytes 0-7/20", "01234567"},
+		{"bytes=20-", http.StatusRequestedRangeNotSatisfiable, "bytes */20", ""},
+		{"bytes=9-0", http.StatusOK, "", body},
+		{"pages=1", http.StatusOK, "", body},
+	}
+	for _, test := range tests {
+		w := getObject(dir, "files", "digits", http.Header{"Range": {test.header}})
+		if w.Code != test.want {
+			t.Errorf("Range %s: %d, want %d", test.header, w.Code, test.want)
+			continue
+		}
+		if got := w.Header().Get("Content-Range"); got != test.contentRange {
+			t.Errorf("Range %s: Content-Range %q, want %q", test.header, got, test.contentRange)
+		}
+		if test.want != http.StatusRequestedRangeNotSatisfiable && w.Body.String() != test.body {
+			t.Errorf("Range %s: body %q, want %q", test.header, w.Body, test.body)
+		}
+	}
+
+	// Disjoint ranges are sent as the parts of a multipart/byteranges body
+	w := getObject(dir, "files", "digits", http.Header{"Range": {"bytes=0-1,10-11"}})
+	mediaType, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if w.Code != http.StatusPartialContent || err != nil || mediaType != "multipart/byteranges" {
+		t.Fatalf("disjoint ranges: %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	reader := multipart.NewReader(w.Body, params["boundary"])
+	var parts []string
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(part)
+		parts = append(parts, part.Header.Get("Content-Range")+" "+string(data))
+	}
+	if got := strings.Join(parts, ", "); got != "bytes 0-1/20 01, bytes 10-11/20 ab" {
+		t.Errorf("disjoint ranges: parts %s", got)
+	}
+}
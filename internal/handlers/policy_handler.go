@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"triple-s/internal/services"
+)
+
+// HandleGetBucketPolicy handles GET /{bucket}?policy requests returning the policy document of a bucket
+func HandleGetBucketPolicy(w http.ResponseWriter, r *http.Request, directoryPath string) {
+	if _, ok := findTaggedBucket(w, r, directoryPath); !ok {
+		return
+	}
+
+	bucketName := strings.Trim(r.URL.Path, "/")
+	document, err := services.ReadBucketPolicy(directoryPath, bucketName)
+	if err == services.ErrPolicyNotFound {
+		writeErrorResponse(w, "The bucket policy does not exist", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeErrorResponse(w, "Error reading bucket policy", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(document)
+}
+
+// HandlePutBucketPolicy handles PUT /{bucket}?policy requests replacing the policy of a bucket
+func HandlePutBucketPolicy(w http.ResponseWriter, r *http.Request, directoryPath string) {
+	defer r.Body.Close()
+
+	if _, ok := findTaggedBucket(w, r, directoryPath); !ok {
+		return
+	}
+
+	bucketName := strings.Trim(r.URL.Path, "/")
+	document, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		writeErrorResponse(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+	if _, err := services.ParseBucketPolicy(document, bucketName); err != nil {
+		writeErrorResponse(w, "Invalid bucket policy: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := services.WriteBucketPolicy(directoryPath, bucketName, document); err != nil {
+		writeErrorResponse(w, "Error writing bucket policy", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleDeleteBucketPolicy handles DELETE /{bucket}?policy requests removing the policy of a bucket
+func HandleDeleteBucketPolicy(w http.ResponseWriter, r *http.Request, directoryPath string) {
+	if _, ok := findTaggedBucket(w, r, directoryPath); !ok {
+		return
+	}
+
+	bucketName := strings.Trim(r.URL.Path, "/")
+	if err := services.DeleteBucketPolicy(directoryPath, bucketName); err != nil {
+		writeErrorResponse(w, "Error removing bucket policy", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// subresourceActions lists bucket sub-resources with the policy actions reading and changing
+// them, in the order bucketHandler dispatches them, so that a request naming several
+// sub-resources is authorized for the one it is served as
+var subresourceActions = []struct {
+	subresource string
+	actions     [2]string
+}{
+	{"acl", [2]string{"s3:GetBucketAcl", "s3:PutBucketAcl"}},
+	{"policy", [2]string{"s3:GetBucketPolicy", "s3:PutBucketPolicy"}},
+	{"tagging", [2]string{"s3:GetBucketTagging", "s3:PutBucketTagging"}},
+	{"lifecycle", [2]string{"s3:GetLifecycleConfiguration", "s3:PutLifecycleConfiguration"}},
+	{"versioning", [2]string{"s3:GetBucketVersioning", "s3:PutBucketVersioning"}},
+}
+
+// requestAction names the policy action of a request, following the names used by Amazon S3
+func requestAction(r *http.Request, bucketName, objectKey string) string {
+	query := r.URL.Query()
+	if bucketName == "" {
+		return "s3:ListAllMyBuckets"
+	}
+
+	if objectKey == "" {
+		for _, entry := range subresourceActions {
+			if query.Has(entry.subresource) {
+				if entry.subresource == "policy" && r.Method == http.MethodDelete {
+					return "s3:DeleteBucketPolicy"
+				}
+				if r.Method == http.MethodGet {
+					return entry.actions[0]
+				}
+				return entry.actions[1]
+			}
+		}
+		switch r.Method {
+		case http.MethodPut:
+			return "s3:CreateBucket"
+		case http.MethodDelete:
+			return "s3:DeleteBucket"
+		case http.MethodPost:
+			// Batch deletes are authorized key by key in HandleDeleteObjects
+			return ""
+		}
+		if query.Has("uploads") {
+			return "s3:ListBucketMultipartUploads"
+		}
+		if query.Has("versions") {
+			return "s3:ListBucketVersions"
+		}
+		return "s3:ListBucket"
+	}
+
+	versioned := query.Has("versionId")
+	if query.Has("acl") {
+		if r.Method == http.MethodGet {
+			return "s3:GetObjectAcl"
+		}
+		return "s3:PutObjectAcl"
+	}
+	if query.Has("tagging") {
+		switch r.Method {
+		case http.MethodGet:
+			return "s3:GetObjectTagging"
+		case http.MethodDelete:
+			return "s3:DeleteObjectTagging"
+		}
+		return "s3:PutObjectTagging"
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if query.Has("uploadId") {
+			return "s3:ListMultipartUploadParts"
+		}
+		if versioned {
+			return "s3:GetObjectVersion"
+		}
+		return "s3:GetObject"
+	case http.MethodDelete:
+		if query.Has("uploadId") {
+			return "s3:AbortMultipartUpload"
+		}
+		if versioned {
+			return "s3:DeleteObjectVersion"
+		}
+		return "s3:DeleteObject"
+	}
+	return "s3:PutObject"
+}
+
+// resourceARN names the bucket or object a request acts on
+func resourceARN(bucketName, objectKey string) string {
+	if objectKey == "" {
+		return "arn:aws:s3:::" + bucketName
+	}
+	return "arn:aws:s3:::" + bucketName + "/" + objectKey
+}
+
+// allowed decides whether the requester may perform an action on a bucket or object. Policy
+// statements are applied first, with explicit denies taking precedence. Without an applicable
+// statement, signed requests are allowed and anonymous requests are only allowed while
+// authentication is not enforced or when the canned ACLs grant public access.
+func allowed(r *http.Request, directoryPath, bucketName, objectKey, action string) bool {
+	identity := RequestIdentity(r)
+
+	if bucketName != "" {
+		switch policyDecision(r, directoryPath, bucketName, objectKey, action) {
+		case services.PolicyDeny:
+			return false
+		case services.PolicyAllow:
+			return true
+		}
+	}
+
+	if identity.Anonymous() && identity.AuthEnforced {
+		return bucketName != "" && aclAllows(r, directoryPath, bucketName, objectKey, action)
+	}
+	return true
+}
+
+// policyDecision evaluates the policy of a bucket, if any, for a request
+func policyDecision(r *http.Request, directoryPath, bucketName, objectKey, action string) services.PolicyDecision {
+	policy, err := services.LoadBucketPolicy(directoryPath, bucketName)
+	if err == services.ErrPolicyNotFound {
+		return services.PolicyNoMatch
+	} else if err != nil {
+		// A policy that cannot be read must not silently open the bucket
+		log.Println("Error loading policy of bucket", bucketName+":", err)
+		return services.PolicyDeny
+	}
+
+	identity := RequestIdentity(r)
+	request := services.PolicyRequest{
+		Action:   action,
+		Resource: resourceARN(bucketName, objectKey),
+		Secure:   r.TLS != nil,
+		Keys:     map[string]string{},
+	}
+	if !identity.Anonymous() {
+		request.Principals = []string{identity.AccessKeyID, identity.UserName}
+		request.Keys["aws:username"] = identity.UserName
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		request.SourceIP = net.ParseIP(host)
+	}
+	query := r.URL.Query()
+	for _, key := range []string{"prefix", "delimiter", "max-keys"} {
+		if query.Has(key) {
+			request.Keys["s3:"+key] = query.Get(key)
+		}
+	}
+	return services.EvaluatePolicy(policy, request)
+}
+
+// Authorize checks that the requester may perform a request on a bucket or object.
+// It writes an AccessDenied error and returns false when the request is not allowed.
+func Authorize(w http.ResponseWriter, r *http.Request, directoryPath, bucketName, objectKey string) bool {
+	action := requestAction(r, bucketName, objectKey)
+	if action == "" || allowed(r, directoryPath, bucketName, objectKey, action) {
+		return true
+	}
+	writeS3Error(w, r, http.StatusForbidden, "AccessDenied", "Access Denied")
+	return false
+}
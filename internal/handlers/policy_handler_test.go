@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// putPolicy replaces the policy of a bucket through the handler
+func putPolicy(dir, bucketName, document string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	HandlePutBucketPolicy(w, httptest.NewRequest(http.MethodPut, "/"+bucketName+"?policy", strings.NewReader(document)), dir)
+	return w
+}
+
+func TestBucketPolicyConfiguration(t *testing.T) {
+	dir := newDataDir(t)
+	createBucket(t, dir, "shared")
+
+	document := `{"Version": "2012-10-17", "Statement": {"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::shared/*"}}`
+	invalid := []struct {
+		name     string
+		document string
+	}{
+		{"not JSON", "{"},
+		{"unknown version", strings.Replace(document, "2012-10-17", "2020-01-01", 1)},
+		{"unknown effect", strings.Replace(document, `"Allow"`, `"Maybe"`, 1)},
+		{"action of another service", strings.Replace(document, "s3:GetObject", "iam:CreateUser", 1)},
+		{"resource outside the bucket", strings.Replace(document, ":::shared/*", ":::other/*", 1)},
+		{"no statements", `{"Version": "2012-10-17", "Statement": []}`},
+		{"unsupported condition", strings.Replace(document, `"Resource"`, `"Condition": {"Unknown": {"aws:SourceIp": "10.0.0.0/8"}}, "Resource"`, 1)},
+	}
+	for _, test := range invalid {
+		if w := putPolicy(dir, "shared", test.document); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: %d, want %d", test.name, w.Code, http.StatusBadRequest)
+		}
+	}
+
+	// Each step changes or reads the policy of the bucket, in order
+	tests := []struct {
+		name     string
+		handler  func(http.ResponseWriter, *http.Request, string)
+		method   string
+		bucket   string
+		body     string
+		want     int
+		document string
+	}{
+		{"no policy yet", HandleGetBucketPolicy, http.MethodGet, "shared", "", http.StatusNotFound, ""},
+		{"set a policy", HandlePutBucketPolicy, http.MethodPut, "shared", document, http.StatusNoContent, ""},
+		{"policy stored as sent", HandleGetBucketPolicy, http.MethodGet, "shared", "", http.StatusOK, document},
+		{"delete the policy", HandleDeleteBucketPolicy, http.MethodDelete, "shared", "", http.StatusNoContent, ""},
+		{"policy removed", HandleGetBucketPolicy, http.MethodGet, "shared", "", http.StatusNotFound, ""},
+		{"missing bucket", HandlePutBucketPolicy, http.MethodPut, "missing", document, http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		test.handler(w, httptest.NewRequest(test.method, "/"+test.bucket+"?policy", strings.NewReader(test.body)), dir)
+		if w.Code != test.want {
+			t.Errorf("%s: %d, want %d: %s", test.name, w.Code, test.want, w.Body)
+			continue
+		}
+		if test.document != "" && w.Body.String() != test.document {
+			t.Errorf("%s: policy %s, want %s", test.name, w.Body, test.document)
+		}
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	dir := newDataDir(t)
+	createBucket(t, dir, "shared")
+	policy := `{
+		"Version": "2012-10-17",
+		"Statement": [
+			{"Sid": "PublicRead", "Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::shared/public/*"},
+			{"Sid": "HideSecrets", "Effect": "Deny", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::shared/public/secret*"},
+			{"Sid": "LockOutBob", "Effect": "Deny", "Principal": {"AWS": "arn:aws:iam::123456789012:user/bob"}, "Action": "s3:*",
+				"Resource": ["arn:aws:s3:::shared", "arn:aws:s3:::shared/*"]}
+		]
+	}`
+	if w := putPolicy(dir, "shared", policy); w.Code != http.StatusNoContent {
+		t.Fatalf("PUT policy: %d %s", w.Code, w.Body)
+	}
+
+	anonymous := Identity{AuthEnforced: true}
+	alice := Identity{AccessKeyID: "AKIAALICE", UserName: "alice", AuthEnforced: true}
+	bob := Identity{AccessKeyID: "AKIABOB", UserName: "bob", AuthEnforced: true}
+	tests := []struct {
+		name     string
+		identity Identity
+		method   string
+		key      string
+		query    string
+		allowed  bool
+	}{
+		{"public object allowed to everyone", anonymous, http.MethodGet, "public/photo.jpg", "", true},
+		{"deny overrides allow", anonymous, http.MethodGet, "public/secret.txt", "", false},
+		{"deny overrides allow for signed requests", alice, http.MethodGet, "public/secret.txt", "", false},
+		{"only reads are public", anonymous, http.MethodPut, "public/photo.jpg", "", false},
+		{"anonymous without a statement", anonymous, http.MethodGet, "private/file", "", false},
+		{"anonymous listing", anonymous, http.MethodGet, "", "", false},
+		{"signed without a statement", alice, http.MethodGet, "private/file", "", true},
+		{"signed upload without a statement", alice, http.MethodPut, "private/file", "", true},
+		{"denied principal despite the public allow", bob, http.MethodGet, "public/photo.jpg", "", false},
+		{"denied principal writing", bob, http.MethodPut, "private/file", "", false},
+		{"denied principal listing", bob, http.MethodGet, "", "", false},
+		{"denied principal managing the policy", bob, http.MethodDelete, "", "policy", false},
+		{"signed request managing the policy without a statement", alice, http.MethodDelete, "", "policy", true},
+		{"anonymous cannot manage the policy", anonymous, http.MethodGet, "", "policy", false},
+		{"authentication not enforced", Identity{}, http.MethodGet, "private/file", "", true},
+	}
+	for _, test := range tests {
+		target := "/shared"
+		if test.key != "" {
+			target += "/" + test.key
+		}
+		r := withIdentity(httptest.NewRequest(test.method, target+"?"+test.query, nil), test.identity)
+		w := httptest.NewRecorder()
+		if got := Authorize(w, r, dir, "shared", test.key); got != test.allowed {
+			t.Errorf("%s: allowed %v, want %v", test.name, got, test.allowed)
+		} else if !got && w.Code != http.StatusForbidden {
+			t.Errorf("%s: denied with %d", test.name, w.Code)
+		}
+	}
+}
+
+func TestRequestAction(t *testing.T) {
+	tests := []struct {
+		method string
+		target string
+		bucket string
+		key    string
+		want   string
+	}{
+		{http.MethodGet, "/", "", "", "s3:ListAllMyBuckets"},
+		{http.MethodGet, "/b?policy", "b", "", "s3:GetBucketPolicy"},
+		{http.MethodDelete, "/b?policy", "b", "", "s3:DeleteBucketPolicy"},
+		{http.MethodPut, "/b?lifecycle", "b", "", "s3:PutLifecycleConfiguration"},
+		{http.MethodPut, "/b?policy&acl", "b", "", "s3:PutBucketAcl"},
+		{http.MethodDelete, "/b?versioning&policy", "b", "", "s3:DeleteBucketPolicy"},
+		{http.MethodGet, "/b?versioning&lifecycle&tagging", "b", "", "s3:GetBucketTagging"},
+		{http.MethodPut, "/b?versioning&lifecycle", "b", "", "s3:PutLifecycleConfiguration"},
+		{http.MethodPut, "/b", "b", "", "s3:CreateBucket"},
+		{http.MethodGet, "/b?versions", "b", "", "s3:ListBucketVersions"},
+		{http.MethodGet, "/b/k?versionId=1", "b", "k", "s3:GetObjectVersion"},
+		{http.MethodPut, "/b/k?acl&tagging", "b", "k", "s3:PutObjectAcl"},
+		{http.MethodDelete, "/b/k?uploadId=1", "b", "k", "s3:AbortMultipartUpload"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, test.target, nil)
+		if got := requestAction(r, test.bucket, test.key); got != test.want {
+			t.Errorf("%s %s: action %q, want %q", test.method, test.target, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"triple-s/internal/models"
+	"triple-s/internal/services"
+)
+
+const (
+	// maxObjectTags is the number of tags an object may carry
+	maxObjectTags = 10
+	// maxBucketTags is the number of tags a bucket may carry
+	maxBucketTags = 50
+)
+
+// parseTagging decodes a Tagging document into a tag map, rejecting duplicate keys
+func parseTagging(body io.Reader) (map[string]string, error) {
+	var tagging models.Tagging
+	if err := xml.NewDecoder(io.LimitReader(body, 1<<20)).Decode(&tagging); err != nil {
+		return nil, errors.New("malformed tagging document")
+	}
+
+	tags := make(map[string]string, len(tagging.TagSet))
+	for _, tag := range tagging.TagSet {
+		if _, exists := tags[tag.Key]; exists {
+			return nil, errors.New("duplicate tag key " + tag.Key)
+		}
+		tags[tag.Key] = tag.Value
+	}
+	return tags, nil
+}
+
+// tagSet converts a tag map into the Tagging document sorted by key
+func tagSet(tags map[string]string) models.Tagging {
+	tagging := models.Tagging{TagSet: []models.Tag{}}
+	for key, value := range tags {
+		tagging.TagSet = append(tagging.TagSet, models.Tag{Key: key, Value: value})
+	}
+	sort.Slice(tagging.TagSet, func(i, j int) bool {
+		return tagging.TagSet[i].Key < tagging.TagSet[j].Key
+	})
+	return tagging
+}
+
+// requestTags parses the x-amz-tagging header of an upload. It writes an error response and
+// returns false when the header is malformed or exceeds the object tag limits.
+func requestTags(w http.ResponseWriter, r *http.Request) (map[string]string, bool) {
+	header := r.Header.Get("x-amz-tagging")
+	if header == "" {
+		return nil, true
+	}
+	tags, err := services.ParseTaggingHeader(header)
+	if err != nil || !ValidateTags(tags, maxObjectTags) {
+		WriteXMLResponse(w, http.StatusBadRequest, "Invalid tag set in x-amz-tagging header")
+		return nil, false
+	}
+	return tags, true
+}
+
+// setTaggingCountHeader reports how many tags an object carries on GET and HEAD
+func setTaggingCountHeader(w http.ResponseWriter, object models.Object) {
+	if len(object.Tags) > 0 {
+		w.Header().Set("x-amz-tagging-count", strconv.Itoa(len(object.Tags)))
+	}
+}
+
+// findTaggedObject loads the metadata of an object addressed by a ?tagging request.
+// It writes an error response and returns false when the bucket or object does not exist.
+func findTaggedObject(w http.ResponseWriter, directoryPath, bucketName, objectKey string) (models.Object, bool) {
+	if _, err := os.Stat(directoryPath + bucketName); os.IsNotExist(err) {
+		WriteXMLResponse(w, http.StatusNotFound, "Bucket does not exist")
+		return models.Object{}, false
+	}
+	localObject, err := services.ReadObjectInfo(directoryPath, bucketName, objectKey)
+	if err == services.ErrObjectNotFound {
+		WriteXMLResponse(w, http.StatusNotFound, "Object does not exist")
+		return models.Object{}, false
+	} else if err != nil {
+		WriteXMLResponse(w, http.StatusInternalServerError, "Cannot read metadata file")
+		return models.Object{}, false
+	}
+	return localObject, true
+}
+
+// HandlerGetObjectTagging handles GET ?tagging requests returning the tags of an object
+func HandlerGetObjectTagging(w http.ResponseWriter, r *http.Request, directoryPath string, bucketName, objectKey string) {
+	localObject, ok := findTaggedObject(w, directoryPath, bucketName, objectKey)
+	if !ok {
+		return
+	}
+	writeXMLResult(w, tagSet(localObject.Tags))
+}
+
+// HandlerPutObjectTagging handles PUT ?tagging requests replacing the tags of an object
+func HandlerPutObjectTagging(w http.ResponseWriter, r *http.Request, directoryPath string, bucketName, objectKey string) {
+	defer r.Body.Close()
+
+	defer services.LockObject(directoryPath, bucketName, objectKey)()
+	localObject, ok := findTaggedObject(w, directoryPath, bucketName, objectKey)
+	if !ok {
+		return
+	}
+
+	tags, err := parseTagging(r.Body)
+	if err != nil {
+		WriteXMLResponse(w, http.StatusBadRequest, "Invalid tagging document: "+err.Error())
+		return
+	}
+	if !ValidateTags(tags, maxObjectTags) {
+		WriteXMLResponse(w, http.StatusBadRequest, "Object tags must number at most 10 with keys of 1-128 and values of at most 256 characters")
+		return
+	}
+
+	localObject.Tags = tags
+	if err := services.PutObjectInfo(directoryPath, bucketName, localObject); err != nil {
+		WriteXMLResponse(w, http.StatusInternalServerError, "Error writing object info: "+err.Error())
+		return
+	}
+	WriteXMLResponse(w, http.StatusOK, "Object tags updated successfully")
+}
+
+// HandlerDeleteObjectTagging handles DELETE ?tagging requests removing every tag of an object
+func HandlerDeleteObjectTagging(w http.ResponseWriter, r *http.Request, directoryPath string, bucketName, objectKey string) {
+	defer services.LockObject(directoryPath, bucketName, objectKey)()
+	localObject, ok := findTaggedObject(w, directoryPath, bucketName, objectKey)
+	if !ok {
+		return
+	}
+
+	localObject.Tags = nil
+	if err := services.PutObjectInfo(directoryPath, bucketName, localObject); err != nil {
+		WriteXMLResponse(w, http.StatusInternalServerError, "Error writing object info: "+err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// findTaggedBucket loads the metadata of a bucket addressed by a ?tagging request.
+// It writes an error response and returns false when the bucket does not exist.
+func findTaggedBucket(w http.ResponseWriter, r *http.Request, directoryPath string) (models.Bucket, bool) {
+	bucketName := strings.Trim(r.URL.Path, "/")
+	if _, err := os.Stat(directoryPath + bucketName); err != nil || !ValidateBucketName(bucketName) {
+		writeErrorResponse(w, "Bucket does not exist", http.StatusNotFound)
+		return models.Bucket{}, false
+	}
+	localBucket, err := services.ReadBucketInfo(directoryPath, bucketName)
+	if err == services.ErrBucketNotFound {
+		writeErrorResponse(w, "Bucket does not exist", http.StatusNotFound)
+		return models.Bucket{}, false
+	} else if err != nil {
+		writeErrorResponse(w, "Error reading bucket info", http.StatusInternalServerError)
+		return models.Bucket{}, false
+	}
+	return localBucket, true
+}
+
+// HandleGetBucketTagging handles GET /{bucket}?tagging requests returning the tags of a bucket
+func HandleGetBucketTagging(w http.ResponseWriter, r *http.Request, directoryPath string) {
+	localBucket, ok := findTaggedBucket(w, r, directoryPath)
+	if !ok {
+		return
+	}
+
+	// Like Amazon S3, a bucket without tags has no tag set rather than an empty one
+	if len(localBucket.Tags) == 0 {
+		writeErrorResponse(w, "The TagSet does not exist", http.StatusNotFound)
+		return
+	}
+	writeXMLResult(w, tagSet(localBucket.Tags))
+}
+
+// HandlePutBucketTagging handles PUT /{bucket}?tagging requests replacing the tags of a bucket
+func HandlePutBucketTagging(w http.ResponseWriter, r *http.Request, directoryPath string) {
+	defer r.Body.Close()
+
+	localBucket, ok := findTaggedBucket(w, r, directoryPath)
+	if !ok {
+		return
+	}
+
+	tags, err := parseTagging(r.Body)
+	if err != nil {
+		writeErrorResponse(w, "Invalid tagging document: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !ValidateTags(tags, maxBucketTags) {
+		writeErrorResponse(w, "Bucket tags must number at most 50 with keys of 1-128 and values of at most 256 characters", http.StatusBadRequest)
+		return
+	}
+
+	err = services.UpdateBucketInfo(directoryPath, localBucket.Name, func(bucket *models.Bucket) {
+		bucket.Tags = tags
+	})
+	if err != nil {
+		writeErrorResponse(w, "Error writing bucket info", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleDeleteBucketTagging handles DELETE /{bucket}?tagging requests removing every tag of a bucket
+func HandleDeleteBucketTagging(w http.ResponseWriter, r *http.Request, directoryPath string) {
+	localBucket, ok := findTaggedBucket(w, r, directoryPath)
+	if !ok {
+		return
+	}
+
+	err := services.UpdateBucketInfo(directoryPath, localBucket.Name, func(bucket *models.Bucket) {
+		bucket.Tags = nil
+	})
+	if err != nil {
+		writeErrorResponse(w, "Error writing bucket info", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"triple-s/internal/models"
+)
+
+// taggingBody is a Tagging document holding key and value pairs
+func taggingBody(pairs ...string) string {
+	var tagging models.Tagging
+	for i := 0; i+1 < len(pairs); i += 2 {
+		tagging.TagSet = append(tagging.TagSet, models.Tag{Key: pairs[i], Value: pairs[i+1]})
+	}
+	data, _ := xml.Marshal(tagging)
+	return string(data)
+}
+
+// manyTags returns n distinct key and value pairs
+func manyTags(n int) []string {
+	var pairs []string
+	for i := 0; i < n; i++ {
+		pairs = append(pairs, fmt.Sprintf("key-%02d", i), "value")
+	}
+	return pairs
+}
+
+// joinTags renders a tag set as "key=value" pairs in document order
+func joinTags(tagging models.Tagging) string {
+	var pairs []string
+	for _, tag := range tagging.TagSet {
+		pairs = append(pairs, tag.Key+"="+tag.Value)
+	}
+	return strings.Join(pairs, " ")
+}
+
+func TestObjectTagging(t *testing.T) {
+	dir := newDataDir(t)
+	createBucket(t, dir, "tagged")
+	put := putObjectWith(dir, "tagged", "report.pdf", "data", http.Header{"X-Amz-Tagging": {"team=finance&year=2024"}})
+	if put.Code != http.StatusOK {
+		t.Fatalf("PUT: %d %s", put.Code, put.Body)
+	}
+	if w := putObjectWith(dir, "tagged", "bad", "data", http.Header{"X-Amz-Tagging": {"aws:reserved=x"}}); w.Code != http.StatusBadRequest {
+		t.Errorf("PUT with a reserved tag key: %d", w.Code)
+	}
+
+	// Each step changes or reads the tags of the object, in order
+	tests := []struct {
+		name    string
+		handler objectHandlerFunc
+		method  string
+		key     string
+		body    string
+		want    int
+		tags    string
+	}{
+		{"tags from the upload", HandlerGetObjectTagging, http.MethodGet, "report.pdf", "", http.StatusOK, "team=finance year=2024"},
+		{"replace tags", HandlerPutObjectTagging, http.MethodPut, "report.pdf", taggingBody("b", "2", "a", "1"), http.StatusOK, ""},
+		{"replaced tags sorted by key", HandlerGetObjectTagging, http.MethodGet, "report.pdf", "", http.StatusOK, "a=1 b=2"},
+		{"duplicate keys", HandlerPutObjectTagging, http.MethodPut, "report.pdf", taggingBody("a", "1", "a", "2"), http.StatusBadRequest, ""},
+		{"too many tags", HandlerPutObjectTagging, http.MethodPut, "report.pdf", taggingBody(manyTags(maxObjectTags + 1)...), http.StatusBadRequest, ""},
+		{"reserved key", HandlerPutObjectTagging, http.MethodPut, "report.pdf", taggingBody("aws:x", "1"), http.StatusBadRequest, ""},
+		{"long value", HandlerPutObjectTagging, http.MethodPut, "report.pdf", taggingBody("a", strings.Repeat("v", 257)), http.StatusBadRequest, ""},
+		{"malformed document", HandlerPutObjectTagging, http.MethodPut, "report.pdf", "<Tagging>", http.StatusBadRequest, ""},
+		{"rejected changes keep the tags", HandlerGetObjectTagging, http.MethodGet, "report.pdf", "", http.StatusOK, "a=1 b=2"},
+		{"delete tags", HandlerDeleteObjectTagging, http.MethodDelete, "report.pdf", "", http.StatusNoContent, ""},
+		{"no tags left", HandlerGetObjectTagging, http.MethodGet, "report.pdf", "", http.StatusOK, ""},
+		{"missing object", HandlerGetObjectTagging, http.MethodGet, "missing", "", http.StatusNotFound, ""},
+		{"tag a missing object", HandlerPutObjectTagging, http.MethodPut, "missing", taggingBody("a", "1"), http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		w := callObjectHandler(test.handler, dir, test.method, "tagged", test.key, "tagging", test.body)
+		if w.Code != test.want {
+			t.Errorf("%s: %d, want %d: %s", test.name, w.Code, test.want, w.Body)
+			continue
+		}
+		if test.method != http.MethodGet || w.Code != http.StatusOK {
+			continue
+		}
+		var tagging models.Tagging
+		if err := xml.Unmarshal(w.Body.Bytes(), &tagging); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if got := joinTags(tagging); got != test.tags {
+			t.Errorf("%s: tags %q, want %q", test.name, got, test.tags)
+		}
+	}
+
+	// Tagging leaves the object data alone
+	get := getObject(dir, "tagged", "report.pdf", nil)
+	if get.Body.String() != "data" || get.Header().Get("ETag") != put.Header().Get("ETag") {
+		t.Errorf("object after tagging: %q ETag %s", get.Body, get.Header().Get("ETag"))
+	}
+}
+
+func TestBucketTagging(t *testing.T) {
+	dir := newDataDir(t)
+	createBucket(t, dir, "tagged")
+
+	// Each step changes or reads the tags of the bucket, in order
+	tests := []struct {
+		name    string
+		handler func(http.ResponseWriter, *http.Request, string)
+		method  string
+		bucket  string
+		body    string
+		want    int
+		tags    string
+	}{
+		{"no tag set yet", HandleGetBucketTagging, http.MethodGet, "tagged", "", http.StatusNotFound, ""},
+		{"set tags", HandlePutBucketTagging, http.MethodPut, "tagged", taggingBody("env", "prod", "cost", "42"), http.StatusNoContent, ""},
+		{"tags sorted by key", HandleGetBucketTagging, http.MethodGet, "tagged", "", http.StatusOK, "cost=42 env=prod"},
+		{"fifty tags", HandlePutBucketTagging, http.MethodPut, "tagged", taggingBody(manyTags(maxBucketTags)...), http.StatusNoContent, ""},
+		{"too many tags", HandlePutBucketTagging, http.MethodPut, "tagged", taggingBody(manyTags(maxBucketTags + 1)...), http.StatusBadRequest, ""},
+		{"duplicate keys", HandlePutBucketTagging, http.MethodPut, "tagged", taggingBody("a", "1", "a", "2"), http.StatusBadRequest, ""},
+		{"empty key", HandlePutBucketTagging, http.MethodPut, "tagged", taggingBody("", "1"), http.StatusBadRequest, ""},
+		{"delete tags", HandleDeleteBucketTagging, http.MethodDelete, "tagged", "", http.StatusNoContent, ""},
+		{"tag set removed", HandleGetBucketTagging, http.MethodGet, "tagged", "", http.StatusNotFound, ""},
+		{"missing bucket", HandlePutBucketTagging, http.MethodPut, "missing", taggingBody("a", "1"), http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		test.handler(w, httptest.NewRequest(test.method, "/"+test.bucket+"?tagging", strings.NewReader(test.body)), dir)
+		if w.Code != test.want {
+			t.Errorf("%s: %d, want %d: %s", test.name, w.Code, test.want, w.Body)
+			continue
+		}
+		if test.method != http.MethodGet || w.Code != http.StatusOK {
+			continue
+		}
+		var tagging models.Tagging
+		if err := xml.Unmarshal(w.Body.Bytes(), &tagging); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if got := joinTags(tagging); got != test.tags {
+			t.Errorf("%s: tags %q, want %q", test.name, got, test.tags)
+		}
+	}
+}
@@ -3,10 +3,16 @@ package handlers
 import (
 	"net"
 	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"triple-s/internal/services"
 )
 
+// ValidateBucketName reports whether a name follows the bucket naming rules of Amazon S3 and
+// is not taken by the server state kept in the data directory
 func ValidateBucketName(s string) bool {
-	if len(s) < 3 || len(s) > 63 {
+	if len(s) < 3 || len(s) > 63 || services.ReservedBucketName(s) {
 		return false
 	}
 
@@ -29,3 +35,57 @@ func ValidateBucketName(s string) bool {
 
 	return true
 }
+
+// maxObjectKeyLength is the longest object key in bytes, as in Amazon S3
+const maxObjectKeyLength = 1024
+
+// ValidateObjectKey reports whether an object key can be stored safely inside a bucket.
+// Keys may contain slashes to form a hierarchy, including empty segments and the trailing
+// slash of folder markers such as "photos/", but no segment may be "." or ".." so that a
+// key can never resolve outside of its bucket directory.
+func ValidateObjectKey(key string) bool {
+	if len(key) == 0 || len(key) > maxObjectKeyLength {
+		return false
+	}
+	if strings.ContainsRune(key, 0) || strings.Contains(key, "\\") {
+		return false
+	}
+
+	// The bucket's internal directory is reserved for server state
+	segments := strings.Split(key, "/")
+	if segments[0] == services.InternalDirName {
+		return false
+	}
+
+	for _, segment := range segments {
+		if segment == "." || segment == ".." || services.ReservedKeySegment(segment) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ValidateTags reports whether a tag set respects the limits of Amazon S3: at most maxTags tags,
+// keys of 1 to 128 characters not starting with "aws:" and values of at most 256 characters
+func ValidateTags(tags map[string]string, maxTags int) bool {
+	if len(tags) > maxTags {
+		return false
+	}
+	for key, value := range tags {
+		keyLength := utf8.RuneCountInString(key)
+		if keyLength == 0 || keyLength > 128 || strings.HasPrefix(key, "aws:") {
+			return false
+		}
+		if utf8.RuneCountInString(value) > 256 {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateUserName reports whether a name follows the IAM user name rules: 1-64 characters
+// drawn from letters, digits and +=,.@_-
+func ValidateUserName(name string) bool {
+	return regexp.MustCompile(`^[A-Za-z0-9+=,.@_-]{1,64}$`).MatchString(name)
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateObjectKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"photo.jpg", true},
+		{"logs/2024/10/app.log", true},
+		{"folder/", true},
+		{"a//b", true},
+		{"/leading", true},
+		{".triple-s-notes/a", true},
+		{"", false},
+		{strings.Repeat("k", 1025), false},
+		{"a/./b", false},
+		{"a/../../b", false},
+		{"..", false},
+		{".triple-s/journal.log", false},
+		{"a/.triple-s-folder", false},
+		{".triple-s-empty/b", false},
+		{"a\\b", false},
+	}
+	for _, test := range tests {
+		if got := ValidateObjectKey(test.key); got != test.want {
+			t.Errorf("%q: got %v, want %v", test.key, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"triple-s/internal/models"
+	"triple-s/internal/services"
+)
+
+// apiVersionID converts a stored version ID into its API form, where the null version is "null"
+func apiVersionID(versionID string) string {
+	if versionID == "" {
+		return "null"
+	}
+	return versionID
+}
+
+// storedVersionID converts a versionId query value into its stored form
+func storedVersionID(versionID string) string {
+	if versionID == "null" {
+		return ""
+	}
+	return versionID
+}
+
+// setDeleteOutcomeHeaders reports the version removed or the delete marker created by a delete
+func setDeleteOutcomeHeaders(w http.ResponseWriter, outcome services.DeleteOutcome) {
+	w.Header().Set("x-amz-version-id", apiVersionID(outcome.VersionID))
+	if outcome.DeleteMarker {
+		w.Header().Set("x-amz-delete-marker", "true")
+	}
+}
+
+// deleteObjectVersion handles DELETE ?versionId requests permanently removing one version
+func deleteObjectVersion(w http.ResponseWriter, r *http.Request, directoryPath, bucketName, objectKey string) {
+	versionID := storedVersionID(r.URL.Query().Get("versionId"))
+	outcome, err := services.DeleteObjectVersion(directoryPath, bucketName, objectKey, versionID)
+	if err == services.ErrVersionNotFound {
+		WriteXMLResponse(w, http.StatusNotFound, "The specified version does not exist")
+		return
+	} else if err != nil {
+		WriteXMLResponse(w, http.StatusInternalServerError, "Error deleting version: "+err.Error())
+		return
+	}
+
+	setDeleteOutcomeHeaders(w, outcome)
+	WriteXMLResponse(w, http.StatusOK, "Object version successfully deleted")
+}
+
+// HandleGetBucketVersioning handles GET /{bucket}?versioning requests
+func HandleGetBucketVersioning(w http.ResponseWriter, r *http.Request, directoryPath string) {
+	localBucket, ok := findTaggedBucket(w, r, directoryPath)
+	if !ok {
+		return
+	}
+	writeXMLResult(w, models.VersioningConfiguration{Status: localBucket.Versioning})
+}
+
+// HandlePutBucketVersioning handles PUT /{bucket}?versioning requests enabling or suspending versioning.
+// Once enabled, versioning can only be suspended and never returns to the unversioned state.
+func HandlePutBucketVersioning(w http.ResponseWriter, r *http.Request, directoryPath string) {
+	defer r.Body.Close()
+
+	localBucket, ok := findTaggedBucket(w, r, directoryPath)
+	if !ok {
+		return
+	}
+
+	var configuration models.VersioningConfiguration
+	if err := xml.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&configuration); err != nil {
+		writeErrorResponse(w, "Malformed versioning configuration", http.StatusBadRequest)
+		return
+	}
+	if configuration.Status != services.VersioningEnabled && configuration.Status != services.VersioningSuspended {
+		writeErrorResponse(w, "Versioning status must be Enabled or Suspended", http.StatusBadRequest)
+		return
+	}
+
+	err := services.UpdateBucketInfo(directoryPath, localBucket.Name, func(bucket *models.Bucket) {
+		bucket.Versioning = configuration.Status
+	})
+	if err != nil {
+		writeErrorResponse(w, "Error writing bucket info", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// s3Namespace is the XML namespace of S3 response documents, which listing entries named
+// at run time must carry explicitly
+const s3Namespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+// versionEntry converts an object version into a Version or DeleteMarker listing entry
+func versionEntry(object models.Object, isDeleteMarker, isLatest bool) models.VersionEntry {
+	entry := models.VersionEntry{
+		XMLName:      xml.Name{Space: s3Namespace, Local: "Version"},
+		Key:          object.ObjectKey,
+		VersionID:    apiVersionID(object.VersionID),
+		IsLatest:     isLatest,
+		LastModified: object.LastModifiedTime,
+		Owner:        defaultOwner,
+	}
+	if modTime, err := time.Parse(time.RFC3339, object.LastModifiedTime); err == nil {
+		entry.LastModified = modTime.UTC().Format(listTimeFormat)
+	}
+	if isDeleteMarker {
+		entry.XMLName.Local = "DeleteMarker"
+		return entry
+	}
+	size := object.Size
+	entry.ETag = objectETag(object)
+	entry.Size = &size
+	entry.StorageClass = "STANDARD"
+	return entry
+}
+
+// HandleListObjectVersions handles GET /{bucket}?versions requests listing every version and
+// delete marker, ordered by key and then from newest to oldest
+func HandleListObjectVersions(w http.ResponseWriter, r *http.Request, directoryPath string) {
+	// Extract bucket name from the URL path
+	bucketName := strings.Trim(r.URL.Path, "/")
+	query := r.URL.Query()
+
+	maxKeys, ok := parseMaxKeys(query)
+	if !ok {
+		writeErrorResponse(w, "Invalid max-keys value", http.StatusBadRequest)
+		return
+	}
+
+	prefix := query.Get("prefix")
+	objects, ok := loadBucketObjects(w, directoryPath, bucketName, prefix)
+	if !ok {
+		return
+	}
+	versions, err := services.ListVersionInfo(directoryPath, bucketName)
+	if err != nil {
+		writeErrorResponse(w, "Error reading version metadata", http.StatusInternalServerError)
+		return
+	}
+
+	// Group the versions of each key, newest first
+	entriesByKey := make(map[string][]models.VersionEntry)
+	for _, object := range objects {
+		entriesByKey[object.ObjectKey] = append(entriesByKey[object.ObjectKey], versionEntry(object, false, true))
+	}
+	for i := len(versions) - 1; i >= 0; i-- {
+		key := versions[i].Object.ObjectKey
+		isLatest := len(entriesByKey[key]) == 0
+		entriesByKey[key] = append(entriesByKey[key], versionEntry(versions[i].Object, versions[i].IsDeleteMarker, isLatest))
+	}
+	keys := make([]string, 0, len(entriesByKey))
+	for key := range entriesByKey {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	keyMarker := query.Get("key-marker")
+	versionIDMarker := query.Get("version-id-marker")
+	result := models.ListVersionsResult{
+		Name:            bucketName,
+		Prefix:          prefix,
+		KeyMarker:       keyMarker,
+		VersionIDMarker: versionIDMarker,
+		MaxKeys:         maxKeys,
+	}
+
+	// Like object listings, a page of zero entries is never truncated
+	for _, key := range keys {
+		if maxKeys == 0 {
+			break
+		}
+		if !strings.HasPrefix(key, prefix) || key < keyMarker {
+			continue
+		}
+		entries := entriesByKey[key]
+		// Versions of the marker key are skipped up to and including the version ID marker
+		if key == keyMarker {
+			skip := len(entries)
+			for i, entry := range entries {
+				if versionIDMarker != "" && entry.VersionID == versionIDMarker {
+					skip = i + 1
+					break
+				}
+			}
+			entries = entries[skip:]
+		}
+		for _, entry := range entries {
+			if len(result.Entries) == maxKeys {
+				result.IsTruncated = true
+				break
+			}
+			result.Entries = append(result.Entries, entry)
+			result.NextKeyMarker = entry.Key
+			result.NextVersionIDMarker = entry.VersionID
+		}
+		if result.IsTruncated {
+			break
+		}
+	}
+	if !result.IsTruncated {
+		result.NextKeyMarker = ""
+		result.NextVersionIDMarker = ""
+	}
+
+	writeXMLResult(w, result)
+}
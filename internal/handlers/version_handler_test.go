@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"triple-s/internal/models"
+)
+
+// setVersioning changes the versioning status of a bucket through the handler
+func setVersioning(dir, bucketName, status string) int {
+	w := httptest.NewRecorder()
+	body := "<VersioningConfiguration><Status>" + status + "</Status></VersioningConfiguration>"
+	HandlePutBucketVersioning(w, httptest.NewRequest(http.MethodPut, "/"+bucketName+"?versioning", strings.NewReader(body)), dir)
+	return w.Code
+}
+
+// listVersions lists the versions of a bucket as "key:versionId" entries, marking delete
+// markers with a trailing "!" and the latest version of a key with a trailing "*"
+func listVersions(t *testing.T, dir, bucketName, query string) (string, models.ListVersionsResult) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	HandleListObjectVersions(w, httptest.NewRequest(http.MethodGet, "/"+bucketName+"?versions&"+query, nil), dir)
+	var result models.ListVersionsResult
+	if w.Code != http.StatusOK || xml.Unmarshal(w.Body.Bytes(), &result) != nil {
+		t.Fatalf("listing versions: %d %s", w.Code, w.Body)
+	}
+	var entries []string
+	for _, entry := range result.Entries {
+		name := entry.Key + ":" + entry.VersionID
+		if entry.XMLName.Local == "DeleteMarker" {
+			name += "!"
+		}
+		if entry.IsLatest {
+			name += "*"
+		}
+		entries = append(entries, name)
+	}
+	return strings.Join(entries, " "), result
+}
+
+func TestBucketVersioningConfiguration(t *testing.T) {
+	dir := newDataDir(t)
+	createBucket(t, dir, "versioned")
+
+	tests := []struct {
+		status string
+		want   int
+		stored string
+	}{
+		{"Enabled", http.StatusOK, "Enabled"},
+		{"Suspended", http.StatusOK, "Suspended"},
+		{"Disabled", http.StatusBadRequest, "Suspended"},
+		{"", http.StatusBadRequest, "Suspended"},
+	}
+	for _, test := range tests {
+		if code := setVersioning(dir, "versioned", test.status); code != test.want {
+			t.Errorf("status %q: %d, want %d", test.status, code, test.want)
+		}
+		w := httptest.NewRecorder()
+		HandleGetBucketVersioning(w, httptest.NewRequest(http.MethodGet, "/versioned?versioning", nil), dir)
+		var configuration models.VersioningConfiguration
+		if w.Code != http.StatusOK || xml.Unmarshal(w.Body.Bytes(), &configuration) != nil || configuration.Status != test.stored {
+			t.Errorf("after status %q: %d %s, want %s", test.status, w.Code, w.Body, test.stored)
+		}
+	}
+}
+
+func TestObjectVersions(t *testing.T) {
+	dir := newDataDir(t)
+	createBucket(t, dir, "versioned")
+	if code := setVersioning(dir, "versioned", "Enabled"); code != http.StatusOK {
+		t.Fatalf("enabling versioning: %d", code)
+	}
+
+	first := putObject(t, dir, "versioned", "doc", "one").Header().Get("x-amz-version-id")
+	second := putObject(t, dir, "versioned", "doc", "two").Header().Get("x-amz-version-id")
+	w := callObjectHandler(HandlerDeleteObject, dir, http.MethodDelete, "versioned", "doc", "", "")
+	marker := w.Header().Get("x-amz-version-id")
+	if first == "" || second == "" || first == second || w.Header().Get("x-amz-delete-marker") != "true" {
+		t.Fatalf("versions %q %q, delete %d %v", first, second, w.Code, w.Header())
+	}
+	if got, _ := listVersions(t, dir, "versioned", ""); got != "doc:"+marker+"!* doc:"+second+" doc:"+first {
+		t.Errorf("versions after the delete: %s", got)
+	}
+
+	// Each step reads the object or removes one of its versions, in order
+	tests := []struct {
+		name    string
+		handler objectHandlerFunc
+		method  string
+		query   string
+		want    int
+		body    string
+	}{
+		{"hidden by the marker", HandlerGetObject, http.MethodGet, "", http.StatusNotFound, ""},
+		{"older version", HandlerGetObject, http.MethodGet, "versionId=" + first, http.StatusOK, "one"},
+		{"the marker itself", HandlerGetObject, http.MethodGet, "versionId=" + marker, http.StatusMethodNotAllowed, ""},
+		{"unknown version", HandlerGetObject, http.MethodGet, "versionId=missing", http.StatusNotFound, ""},
+		{"remove the marker", HandlerDeleteObject, http.MethodDelete, "versionId=" + marker, http.StatusOK, ""},
+		{"restored by removing the marker", HandlerGetObject, http.MethodGet, "", http.StatusOK, "two"},
+		{"remove the current version", HandlerDeleteObject, http.MethodDelete, "versionId=" + second, http.StatusOK, ""},
+		{"previous version promoted", HandlerGetObject, http.MethodGet, "", http.StatusOK, "one"},
+		{"remove an unknown version", HandlerDeleteObject, http.MethodDelete, "versionId=missing", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		w := callObjectHandler(test.handler, dir, test.method, "versioned", "doc", test.query, "")
+		if w.Code != test.want {
+			t.Errorf("%s: %d, want %d", test.name, w.Code, test.want)
+			continue
+		}
+		if test.body != "" && w.Body.String() != test.body {
+			t.Errorf("%s: body %q, want %q", test.name, w.Body, test.body)
+		}
+	}
+	if got, _ := listVersions(t, dir, "versioned", ""); got != "doc:"+first+"*" {
+		t.Errorf("versions after removing the marker and the current version: %s", got)
+	}
+
+	// Under suspended versioning, writes replace the single null version
+	if code := setVersioning(dir, "versioned", "Suspended"); code != http.StatusOK {
+		t.Fatalf("suspending versioning: %d", code)
+	}
+	for _, body := range []string{"three", "four"} {
+		if id := putObject(t, dir, "versioned", "doc", body).Header().Get("x-amz-version-id"); id != "null" {
+			t.Errorf("version ID under suspended versioning: %q", id)
+		}
+	}
+	if got, _ := listVersions(t, dir, "versioned", ""); got != "doc:null* doc:"+first {
+		t.Errorf("versions under suspended versioning: %s", got)
+	}
+
+	// Version listings page like object listings
+	if got, result := listVersions(t, dir, "versioned", "max-keys=1"); got != "doc:null*" || !result.IsTruncated || result.NextVersionIDMarker != "null" {
+		t.Errorf("first page of versions: %s %+v", got, result)
+	}
+	if got, result := listVersions(t, dir, "versioned", "max-keys=0"); got != "" || result.IsTruncated {
+		t.Errorf("empty page of versions: %q truncated %v", got, result.IsTruncated)
+	}
+}
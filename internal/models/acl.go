@@ -0,0 +1,28 @@
+package models
+
+import "encoding/xml"
+
+// AccessControlPolicy is the document exchanged by the ?acl sub-resource of buckets and objects.
+// The namespace is an attribute so that documents sent without one can still be decoded.
+type AccessControlPolicy struct {
+	XMLName xml.Name `xml:"AccessControlPolicy"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
+	Owner   Owner    `xml:"Owner"`
+	Grants  []Grant  `xml:"AccessControlList>Grant"`
+}
+
+// Grant gives a permission such as READ or FULL_CONTROL to a grantee
+type Grant struct {
+	Grantee    Grantee `xml:"Grantee"`
+	Permission string  `xml:"Permission"`
+}
+
+// Grantee is a canonical user identified by ID or a predefined group identified by URI.
+// The xsi attributes are only written; decoding relies on whether ID or URI is present.
+type Grantee struct {
+	XMLNSXsi    string `xml:"xmlns:xsi,attr,omitempty"`
+	Type        string `xml:"xsi:type,attr,omitempty"`
+	ID          string `xml:"ID,omitempty"`
+	DisplayName string `xml:"DisplayName,omitempty"`
+	URI         string `xml:"URI,omitempty"`
+}
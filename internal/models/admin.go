@@ -0,0 +1,33 @@
+package models
+
+import "encoding/xml"
+
+// User is a named owner of access keys managed through the admin API
+type User struct {
+	XMLName      xml.Name `xml:"User"`
+	Name         string   `xml:"Name"`
+	CreationTime string   `xml:"CreationTime"`
+}
+
+// AccessKey is an access key ID and its secret. The secret is only filled in when the key
+// is created, since it is never returned afterwards.
+type AccessKey struct {
+	XMLName         xml.Name `xml:"AccessKey"`
+	AccessKeyID     string   `xml:"AccessKeyId"`
+	SecretAccessKey string   `xml:"SecretAccessKey,omitempty"`
+	UserName        string   `xml:"UserName"`
+	Status          string   `xml:"Status"`
+	CreationTime    string   `xml:"CreationTime"`
+}
+
+// ListUsersResult is the response of the admin API listing users
+type ListUsersResult struct {
+	XMLName xml.Name `xml:"ListUsersResult"`
+	Users   []User   `xml:"Users>User"`
+}
+
+// ListAccessKeysResult is the response of the admin API listing access keys
+type ListAccessKeysResult struct {
+	XMLName    xml.Name    `xml:"ListAccessKeysResult"`
+	AccessKeys []AccessKey `xml:"AccessKeys>AccessKey"`
+}
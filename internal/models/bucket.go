@@ -8,4 +8,10 @@ type Bucket struct {
 	CreationTime     string   `xml:"Bucket>CreationTime"`
 	LastModifiedTime string   `xml:"Bucket>LastModifiedTime"`
 	Status           string   `xml:"Bucket>Status"`
+	// Tags holds the cost-allocation tags set with the ?tagging sub-resource
+	Tags map[string]string `xml:"-"`
+	// Versioning is empty for buckets that never had versioning, otherwise Enabled or Suspended
+	Versioning string `xml:"-"`
+	// ACL is the canned ACL of the bucket, empty for the default private ACL
+	ACL string `xml:"-"`
 }
@@ -0,0 +1,56 @@
+package models
+
+import "encoding/xml"
+
+// Owner identifies the owner of an object in listings
+type Owner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+// ObjectContents describes a single object in a bucket listing
+type ObjectContents struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+	Owner        *Owner `xml:"Owner,omitempty"`
+}
+
+// CommonPrefix is a key prefix rolled up by the delimiter in a bucket listing
+type CommonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+// ListBucketResultV2 is the response of the ListObjectsV2 API
+type ListBucketResultV2 struct {
+	XMLName               xml.Name         `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name                  string           `xml:"Name"`
+	Prefix                string           `xml:"Prefix"`
+	Delimiter             string           `xml:"Delimiter,omitempty"`
+	MaxKeys               int              `xml:"MaxKeys"`
+	KeyCount              int              `xml:"KeyCount"`
+	IsTruncated           bool             `xml:"IsTruncated"`
+	EncodingType          string           `xml:"EncodingType,omitempty"`
+	ContinuationToken     string           `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string           `xml:"NextContinuationToken,omitempty"`
+	StartAfter            string           `xml:"StartAfter,omitempty"`
+	Contents              []ObjectContents `xml:"Contents"`
+	CommonPrefixes        []CommonPrefix   `xml:"CommonPrefixes"`
+}
+
+// ListBucketResult is the response of the legacy ListObjects (V1) API
+type ListBucketResult struct {
+	XMLName        xml.Name         `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name           string           `xml:"Name"`
+	Prefix         string           `xml:"Prefix"`
+	Marker         string           `xml:"Marker"`
+	NextMarker     string           `xml:"NextMarker,omitempty"`
+	MaxKeys        int              `xml:"MaxKeys"`
+	Delimiter      string           `xml:"Delimiter,omitempty"`
+	IsTruncated    bool             `xml:"IsTruncated"`
+	EncodingType   string           `xml:"EncodingType,omitempty"`
+	Contents       []ObjectContents `xml:"Contents"`
+	CommonPrefixes []CommonPrefix   `xml:"CommonPrefixes"`
+}
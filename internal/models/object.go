@@ -8,4 +8,55 @@ type Object struct {
 	Size             int64    `xml:"Object>Size"`
 	ContentType      string   `xml:"Object>ContentType"`
 	LastModifiedTime string   `xml:"Object>LastModifiedTime"`
+	ETag             string   `xml:"Object>ETag"`
+	VersionID        string   `xml:"Object>VersionId,omitempty"`
+	// Metadata holds the x-amz-meta-* headers and the stored representation headers
+	// such as Cache-Control, keyed by lower-case header name
+	Metadata map[string]string `xml:"-"`
+	// Tags holds the object tags set with x-amz-tagging or the ?tagging sub-resource
+	Tags map[string]string `xml:"-"`
+	// ACL is the canned ACL of the object, empty for the default private ACL
+	ACL string `xml:"-"`
+}
+
+// CopyObjectResult is the response of a server-side copy
+type CopyObjectResult struct {
+	XMLName      xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CopyObjectResult"`
+	LastModified string   `xml:"LastModified"`
+	ETag         string   `xml:"ETag"`
+}
+
+// DeleteObjectsRequest is the request body of a batch delete
+type DeleteObjectsRequest struct {
+	XMLName xml.Name           `xml:"Delete"`
+	Quiet   bool               `xml:"Quiet"`
+	Objects []ObjectIdentifier `xml:"Object"`
+}
+
+// ObjectIdentifier names an object in a batch delete
+type ObjectIdentifier struct {
+	Key       string `xml:"Key"`
+	VersionID string `xml:"VersionId,omitempty"`
+}
+
+// DeletedObject reports a key removed by a batch delete
+type DeletedObject struct {
+	Key                   string `xml:"Key"`
+	VersionID             string `xml:"VersionId,omitempty"`
+	DeleteMarker          bool   `xml:"DeleteMarker,omitempty"`
+	DeleteMarkerVersionID string `xml:"DeleteMarkerVersionId,omitempty"`
+}
+
+// DeleteError reports a key a batch delete failed to remove
+type DeleteError struct {
+	Key     string `xml:"Key"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// DeleteResult is the response of a batch delete
+type DeleteResult struct {
+	XMLName xml.Name        `xml:"http://s3.amazonaws.com/doc/2006-03-01/ DeleteResult"`
+	Deleted []DeletedObject `xml:"Deleted"`
+	Errors  []DeleteError   `xml:"Error"`
 }
@@ -0,0 +1,74 @@
+package models
+
+import "encoding/json"
+
+// StringList is a policy element that may be written as a single string or as a list of strings
+type StringList []string
+
+// UnmarshalJSON accepts both a string and an array of strings
+func (list *StringList) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*list = StringList{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*list = multiple
+	return nil
+}
+
+// PolicyPrincipal names who a statement applies to. The "*" shorthand is stored as {"AWS": ["*"]}.
+type PolicyPrincipal map[string]StringList
+
+// UnmarshalJSON accepts both "*" and an object mapping principal types to names
+func (principal *PolicyPrincipal) UnmarshalJSON(data []byte) error {
+	var wildcard string
+	if err := json.Unmarshal(data, &wildcard); err == nil {
+		*principal = PolicyPrincipal{"AWS": StringList{wildcard}}
+		return nil
+	}
+	var principals map[string]StringList
+	if err := json.Unmarshal(data, &principals); err != nil {
+		return err
+	}
+	*principal = principals
+	return nil
+}
+
+// PolicyStatement is a single rule of a bucket policy
+type PolicyStatement struct {
+	Sid       string                           `json:"Sid,omitempty"`
+	Effect    string                           `json:"Effect"`
+	Principal PolicyPrincipal                  `json:"Principal"`
+	Action    StringList                       `json:"Action"`
+	Resource  StringList                       `json:"Resource"`
+	Condition map[string]map[string]StringList `json:"Condition,omitempty"`
+}
+
+// PolicyStatements is the Statement element, which may be a single statement or a list
+type PolicyStatements []PolicyStatement
+
+// UnmarshalJSON accepts both a statement object and an array of statements
+func (statements *PolicyStatements) UnmarshalJSON(data []byte) error {
+	var single PolicyStatement
+	if err := json.Unmarshal(data, &single); err == nil {
+		*statements = PolicyStatements{single}
+		return nil
+	}
+	var multiple []PolicyStatement
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*statements = multiple
+	return nil
+}
+
+// BucketPolicy is the JSON policy document exchanged by the ?policy sub-resource
+type BucketPolicy struct {
+	Version    string           `json:"Version"`
+	ID         string           `json:"Id,omitempty"`
+	Statements PolicyStatements `json:"Statement"`
+}
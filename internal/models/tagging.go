@@ -0,0 +1,15 @@
+package models
+
+import "encoding/xml"
+
+// Tag is a single key/value label attached to an object or bucket
+type Tag struct {
+	Key   string `xml:"Key"`
+	Value string `xml:"Value"`
+}
+
+// Tagging is the document exchanged by the ?tagging sub-resource
+type Tagging struct {
+	XMLName xml.Name `xml:"Tagging"`
+	TagSet  []Tag    `xml:"TagSet>Tag"`
+}
@@ -0,0 +1,45 @@
+package models
+
+import "encoding/xml"
+
+// ObjectVersion is a noncurrent version of an object or a delete marker
+type ObjectVersion struct {
+	Object         Object
+	IsDeleteMarker bool
+	// ArchivedTime is when a data version stopped being the current version
+	ArchivedTime string
+}
+
+// VersioningConfiguration is the document exchanged by the ?versioning sub-resource
+type VersioningConfiguration struct {
+	XMLName xml.Name `xml:"VersioningConfiguration"`
+	Status  string   `xml:"Status,omitempty"`
+}
+
+// VersionEntry is a Version or DeleteMarker element of a version listing,
+// named through its XMLName so both kinds can be listed in order
+type VersionEntry struct {
+	XMLName      xml.Name
+	Key          string `xml:"Key"`
+	VersionID    string `xml:"VersionId"`
+	IsLatest     bool   `xml:"IsLatest"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag,omitempty"`
+	Size         *int64 `xml:"Size,omitempty"`
+	StorageClass string `xml:"StorageClass,omitempty"`
+	Owner        Owner  `xml:"Owner"`
+}
+
+// ListVersionsResult is the response of the ListObjectVersions API
+type ListVersionsResult struct {
+	XMLName             xml.Name       `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListVersionsResult"`
+	Name                string         `xml:"Name"`
+	Prefix              string         `xml:"Prefix"`
+	KeyMarker           string         `xml:"KeyMarker"`
+	VersionIDMarker     string         `xml:"VersionIdMarker"`
+	NextKeyMarker       string         `xml:"NextKeyMarker,omitempty"`
+	NextVersionIDMarker string         `xml:"NextVersionIdMarker,omitempty"`
+	MaxKeys             int            `xml:"MaxKeys"`
+	IsTruncated         bool           `xml:"IsTruncated"`
+	Entries             []VersionEntry `xml:",any"`
+}
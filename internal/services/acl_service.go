@@ -0,0 +1,111 @@
+package services
+
+import (
+	"errors"
+
+	"triple-s/internal/models"
+)
+
+// Canned ACLs accepted in the x-amz-acl header. An empty stored ACL is the default private ACL.
+const (
+	ACLPrivate           = "private"
+	ACLPublicRead        = "public-read"
+	ACLPublicReadWrite   = "public-read-write"
+	ACLAuthenticatedRead = "authenticated-read"
+)
+
+// Predefined groups granted permissions by the public and authenticated canned ACLs
+const (
+	AllUsersGroup           = "http://acs.amazonaws.com/groups/global/AllUsers"
+	AuthenticatedUsersGroup = "http://acs.amazonaws.com/groups/global/AuthenticatedUsers"
+	xmlSchemaInstance       = "http://www.w3.org/2001/XMLSchema-instance"
+	s3Namespace             = "http://s3.amazonaws.com/doc/2006-03-01/"
+)
+
+// ErrUnsupportedACL is returned for an access control list that is not one of the canned ACLs
+var ErrUnsupportedACL = errors.New("only the grants of a canned ACL are supported")
+
+// ValidCannedACL reports whether an x-amz-acl header value names a supported canned ACL
+func ValidCannedACL(acl string) bool {
+	switch acl {
+	case "", ACLPrivate, ACLPublicRead, ACLPublicReadWrite, ACLAuthenticatedRead:
+		return true
+	}
+	return false
+}
+
+// StoredACL normalizes a canned ACL for storage, where the default private ACL is empty
+func StoredACL(acl string) string {
+	if acl == ACLPrivate {
+		return ""
+	}
+	return acl
+}
+
+// ACLGrants expands a canned ACL into the access control policy reported by ?acl
+func ACLGrants(acl string, owner models.Owner) models.AccessControlPolicy {
+	grant := func(grantee models.Grantee, permission string) models.Grant {
+		grantee.XMLNSXsi = xmlSchemaInstance
+		return models.Grant{Grantee: grantee, Permission: permission}
+	}
+	group := func(uri string) models.Grantee {
+		return models.Grantee{Type: "Group", URI: uri}
+	}
+
+	policy := models.AccessControlPolicy{
+		Xmlns: s3Namespace,
+		Owner: owner,
+		Grants: []models.Grant{
+			grant(models.Grantee{Type: "CanonicalUser", ID: owner.ID, DisplayName: owner.DisplayName}, "FULL_CONTROL"),
+		},
+	}
+	switch acl {
+	case ACLPublicRead:
+		policy.Grants = append(policy.Grants, grant(group(AllUsersGroup), "READ"))
+	case ACLPublicReadWrite:
+		policy.Grants = append(policy.Grants, grant(group(AllUsersGroup), "READ"), grant(group(AllUsersGroup), "WRITE"))
+	case ACLAuthenticatedRead:
+		policy.Grants = append(policy.Grants, grant(group(AuthenticatedUsersGroup), "READ"))
+	}
+	return policy
+}
+
+// CannedACL maps an access control policy back onto the canned ACL granting the same
+// permissions. Grants to the owner are ignored, since the owner always has full control.
+func CannedACL(policy models.AccessControlPolicy, owner models.Owner) (string, error) {
+	groups := map[string]bool{}
+	for _, grant := range policy.Grants {
+		switch {
+		case grant.Grantee.URI == "" && grant.Grantee.ID == owner.ID:
+			continue
+		case grant.Grantee.URI == AllUsersGroup && (grant.Permission == "READ" || grant.Permission == "WRITE"):
+		case grant.Grantee.URI == AuthenticatedUsersGroup && grant.Permission == "READ":
+		default:
+			return "", ErrUnsupportedACL
+		}
+		groups[grant.Grantee.URI+" "+grant.Permission] = true
+	}
+
+	switch {
+	case len(groups) == 0:
+		return ACLPrivate, nil
+	case len(groups) == 1 && groups[AllUsersGroup+" READ"]:
+		return ACLPublicRead, nil
+	case len(groups) == 2 && groups[AllUsersGroup+" READ"] && groups[AllUsersGroup+" WRITE"]:
+		return ACLPublicReadWrite, nil
+	case len(groups) == 1 && groups[AuthenticatedUsersGroup+" READ"]:
+		return ACLAuthenticatedRead, nil
+	}
+	return "", ErrUnsupportedACL
+}
+
+// ACLAllowsPublicRead reports whether a canned ACL lets unsigned requests read. Signed
+// requests already have full access, so authenticated-read grants them nothing more.
+func ACLAllowsPublicRead(acl string) bool {
+	return acl == ACLPublicRead || acl == ACLPublicReadWrite
+}
+
+// ACLAllowsPublicWrite reports whether a canned bucket ACL lets unsigned requests write objects
+func ACLAllowsPublicWrite(acl string) bool {
+	return acl == ACLPublicReadWrite
+}
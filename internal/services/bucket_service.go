@@ -2,7 +2,6 @@ package services
 
 import (
 	"encoding/base64"
-	"encoding/csv"
 	"encoding/xml"
 	"errors"
 	"os"
@@ -11,23 +10,18 @@ import (
 	"triple-s/internal/models"
 )
 
-// BucketAndFileCreation creates a bucket and objects.csv file, returns an error if fails
+// BucketAndFileCreation creates the directory of a bucket, returns an error if fails
 func BucketAndFileCreation(dirPath string) error {
 	err := os.Mkdir(dirPath, os.ModePerm)
 	if err != nil {
 		return errors.New("bucket already exists")
 	}
-
-	file, err := os.Create(dirPath + "/objects.csv")
-	if err != nil {
-		return errors.New("error creating objects.csv")
-	}
-	defer file.Close()
 	return nil
 }
 
-// WriteBucketInfo writes bucket metadata to a CSV and returns an error if it fails
-func WriteBucketInfo(bucketName string, directoryPath string) (string, error) {
+// WriteBucketInfo records the metadata of a new bucket and returns its XML description.
+// The acl is the canned ACL of the new bucket, empty for the default private ACL.
+func WriteBucketInfo(bucketName string, directoryPath string, acl string) (string, error) {
 	fileInfo, err := os.Stat(directoryPath + bucketName)
 	if err != nil {
 		return "", errors.New("error getting file info")
@@ -38,38 +32,85 @@ func WriteBucketInfo(bucketName string, directoryPath string) (string, error) {
 		CreationTime:     fileInfo.ModTime().Format(time.RFC3339),
 		LastModifiedTime: fileInfo.ModTime().Format(time.RFC3339),
 		Status:           "true",
+		ACL:              acl,
 	}
 
-	bucketInfo, err := os.OpenFile(directoryPath+"buckets.csv", os.O_CREATE|os.O_APPEND|os.O_RDWR, 0o644)
+	if err := Metadata(directoryPath).CreateBucket(*localBucket); err != nil {
+		return "", errors.New("error writing bucket info: " + err.Error())
+	}
+
+	xmlData, err := xml.MarshalIndent(localBucket, "", "   ")
 	if err != nil {
-		return "", errors.New("error creating file")
+		return "", errors.New("error generating XML")
 	}
-	defer bucketInfo.Close()
 
-	encodedName := base64.StdEncoding.EncodeToString([]byte(fileInfo.Name()))
-	encodedModTime := base64.StdEncoding.EncodeToString([]byte(fileInfo.ModTime().Format(time.RFC3339)))
-	writer := csv.NewWriter(bucketInfo)
+	return string(xmlData), nil
+}
 
-	info := []string{
-		encodedName,
-		encodedModTime,
-		encodedModTime,
-		localBucket.Status,
-	}
+// ErrBucketNotFound is returned when a bucket has no active record
+var ErrBucketNotFound = errors.New("bucket not found")
 
-	if err := writer.Write(info); err != nil {
-		return "", errors.New("error writing to CSV")
-	}
-	writer.Flush()
+// ReadBucketInfo looks up the active record of a bucket
+func ReadBucketInfo(directoryPath, bucketName string) (models.Bucket, error) {
+	return Metadata(directoryPath).GetBucket(bucketName)
+}
 
-	if err := writer.Error(); err != nil {
-		return "", errors.New("error flushing CSV data")
+// UpdateBucketInfo applies a change to the active record of a bucket. The record is read
+// and written back atomically, so concurrent changes to other settings of the bucket are kept.
+func UpdateBucketInfo(directoryPath, bucketName string, update func(*models.Bucket)) error {
+	return Metadata(directoryPath).UpdateBucket(bucketName, update)
+}
+
+// encodeBucketRecord converts bucket metadata into a buckets.csv record
+func encodeBucketRecord(bucket models.Bucket) []string {
+	return []string{
+		base64.StdEncoding.EncodeToString([]byte(bucket.Name)),
+		base64.StdEncoding.EncodeToString([]byte(bucket.CreationTime)),
+		base64.StdEncoding.EncodeToString([]byte(bucket.LastModifiedTime)),
+		bucket.Status,
+		encodeMap(bucket.Tags),
+		bucket.Versioning,
+		bucket.ACL,
 	}
+}
 
-	xmlData, err := xml.MarshalIndent(localBucket, "", "   ")
+// decodeBucketRecord converts a buckets.csv record into bucket metadata
+func decodeBucketRecord(record []string) (models.Bucket, error) {
+	name, err := base64.StdEncoding.DecodeString(record[0])
 	if err != nil {
-		return "", errors.New("error generating XML")
+		return models.Bucket{}, errors.New("error decoding bucket name: " + err.Error())
 	}
+	creationTime, _ := base64.StdEncoding.DecodeString(record[1])
+	lastModifiedTime, _ := base64.StdEncoding.DecodeString(record[2])
 
-	return string(xmlData), nil
+	localBucket := models.Bucket{
+		Name:             string(name),
+		CreationTime:     string(creationTime),
+		LastModifiedTime: string(lastModifiedTime),
+		Status:           record[3],
+	}
+	// Records written before bucket tagging only have four fields
+	if len(record) > 4 {
+		localBucket.Tags = decodeMap(record[4])
+	}
+	if len(record) > 5 {
+		localBucket.Versioning = record[5]
+	}
+	if len(record) > 6 {
+		localBucket.ACL = record[6]
+	}
+	return localBucket, nil
+}
+
+// BucketVersioning returns the versioning status of a bucket, or an empty string when
+// versioning was never configured or the bucket has no record. Other errors are returned,
+// since writing as if versioning were off would lose the version being replaced.
+func BucketVersioning(directoryPath, bucketName string) (string, error) {
+	localBucket, err := ReadBucketInfo(directoryPath, bucketName)
+	if err == ErrBucketNotFound {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return localBucket.Versioning, nil
 }
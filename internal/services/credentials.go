@@ -0,0 +1,271 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"triple-s/internal/models"
+)
+
+// CredentialsFileName is the file next to buckets.csv holding the access keys accepted by the
+// server. Records hold the access key ID, the secret, the status, the owning user and the
+// creation time; only the first two fields are required in hand-written files.
+const CredentialsFileName = "credentials.csv"
+
+const (
+	// AccessKeyActive marks a key that may sign requests
+	AccessKeyActive = "Active"
+	// AccessKeyInactive marks a disabled key
+	AccessKeyInactive = "Inactive"
+)
+
+// ErrAccessKeyNotFound is returned when an access key is unknown or has been disabled
+var ErrAccessKeyNotFound = errors.New("access key not found")
+
+// Authentication modes selectable with SetAuthMode
+const (
+	// AuthAuto enforces authentication once a credentials file is found in the data
+	// directory, at startup or when the first access key is created
+	AuthAuto = "auto"
+	// AuthOn enforces authentication whether or not a credentials file exists
+	AuthOn = "on"
+	// AuthOff accepts every request as anonymous
+	AuthOff = "off"
+)
+
+var (
+	authMu   sync.Mutex
+	authMode = AuthAuto
+	// authEnforced holds the data directories in which AuthAuto found a credentials file
+	authEnforced = make(map[string]bool)
+)
+
+// SetAuthMode selects whether requests must be signed
+func SetAuthMode(mode string) error {
+	if mode != AuthAuto && mode != AuthOn && mode != AuthOff {
+		return errors.New("unknown authentication mode " + mode)
+	}
+	authMu.Lock()
+	defer authMu.Unlock()
+	authMode = mode
+	return nil
+}
+
+// AuthEnabled reports whether requests must be signed. Once a credentials file has been found,
+// authentication stays enforced for the life of the process: a credentials file that goes
+// missing or cannot be read denies every signed request rather than opening the server.
+func AuthEnabled(dirPath string) bool {
+	authMu.Lock()
+	defer authMu.Unlock()
+	switch authMode {
+	case AuthOn:
+		return true
+	case AuthOff:
+		return false
+	}
+	if !authEnforced[dirPath] {
+		if _, err := os.Stat(dirPath + CredentialsFileName); !os.IsNotExist(err) {
+			authEnforced[dirPath] = true
+		}
+	}
+	return authEnforced[dirPath]
+}
+
+// LookupSecret returns the secret access key paired with an active access key ID
+func LookupSecret(dirPath, accessKeyID string) (string, error) {
+	key, err := LookupAccessKey(dirPath, accessKeyID)
+	return key.SecretAccessKey, err
+}
+
+// LookupAccessKey returns an active access key, secret included, together with its owner
+func LookupAccessKey(dirPath, accessKeyID string) (models.AccessKey, error) {
+	keys, err := readAccessKeys(dirPath)
+	if err != nil {
+		return models.AccessKey{}, err
+	}
+	for _, key := range keys {
+		if key.AccessKeyID == accessKeyID && key.Status == AccessKeyActive {
+			return key, nil
+		}
+	}
+	return models.AccessKey{}, ErrAccessKeyNotFound
+}
+
+// readAccessKeys reads every record of the credentials file, secrets included
+func readAccessKeys(dirPath string) ([]models.AccessKey, error) {
+	records, err := readCSVFile(dirPath + CredentialsFileName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	keys := make([]models.AccessKey, 0, len(records))
+	for _, record := range records {
+		if len(record) < 2 {
+			continue
+		}
+		key := models.AccessKey{AccessKeyID: record[0], SecretAccessKey: record[1], Status: AccessKeyActive}
+		// Hand-written files may disable a key with a lower-case status
+		if len(record) > 2 && (record[2] == AccessKeyInactive || record[2] == "inactive") {
+			key.Status = AccessKeyInactive
+		}
+		if len(record) > 3 {
+			userName, _ := base64.StdEncoding.DecodeString(record[3])
+			key.UserName = string(userName)
+		}
+		if len(record) > 4 {
+			created, _ := base64.StdEncoding.DecodeString(record[4])
+			key.CreationTime = string(created)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// writeAccessKeys replaces the content of the credentials file, readable only by its owner
+func writeAccessKeys(dirPath string, keys []models.AccessKey) error {
+	records := make([][]string, 0, len(keys))
+	for _, key := range keys {
+		records = append(records, []string{
+			key.AccessKeyID,
+			key.SecretAccessKey,
+			key.Status,
+			base64.StdEncoding.EncodeToString([]byte(key.UserName)),
+			base64.StdEncoding.EncodeToString([]byte(key.CreationTime)),
+		})
+	}
+	return writeCSVFileMode(dirPath+CredentialsFileName, records, 0o600)
+}
+
+// ListAccessKeys returns every access key, optionally only those of one user, without their secrets
+func ListAccessKeys(dirPath, userName string) ([]models.AccessKey, error) {
+	keys, err := readAccessKeys(dirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	listed := make([]models.AccessKey, 0, len(keys))
+	for _, key := range keys {
+		if userName != "" && key.UserName != userName {
+			continue
+		}
+		key.SecretAccessKey = ""
+		listed = append(listed, key)
+	}
+	return listed, nil
+}
+
+// randomString returns a string of the given length drawn from the alphabet
+func randomString(alphabet string, length int) string {
+	random := make([]byte, length)
+	rand.Read(random)
+	for i := range random {
+		random[i] = alphabet[int(random[i])%len(alphabet)]
+	}
+	return string(random)
+}
+
+// newAccessKey generates a 20 character access key ID and a 40 character secret for a user
+func newAccessKey(userName string) models.AccessKey {
+	secret := make([]byte, 30)
+	rand.Read(secret)
+	return models.AccessKey{
+		AccessKeyID:     "TSAK" + randomString("ABCDEFGHIJKLMNOPQRSTUVWXYZ234567", 16),
+		SecretAccessKey: base64.StdEncoding.EncodeToString(secret),
+		UserName:        userName,
+		Status:          AccessKeyActive,
+		CreationTime:    time.Now().Format(time.RFC3339),
+	}
+}
+
+// CreateAccessKey generates a new access key for an existing user and returns it with its secret
+func CreateAccessKey(dirPath, userName string) (models.AccessKey, error) {
+	if _, err := ReadUser(dirPath, userName); err != nil {
+		return models.AccessKey{}, err
+	}
+	defer LockMetadataFile(dirPath + CredentialsFileName)()
+	keys, err := readAccessKeys(dirPath)
+	if err != nil {
+		return models.AccessKey{}, err
+	}
+
+	key := newAccessKey(userName)
+	if err := writeAccessKeys(dirPath, append(keys, key)); err != nil {
+		return models.AccessKey{}, err
+	}
+	return key, nil
+}
+
+// SetAccessKeyStatus enables or disables an access key
+func SetAccessKeyStatus(dirPath, accessKeyID, status string) (models.AccessKey, error) {
+	defer LockMetadataFile(dirPath + CredentialsFileName)()
+	keys, err := readAccessKeys(dirPath)
+	if err != nil {
+		return models.AccessKey{}, err
+	}
+	for i := range keys {
+		if keys[i].AccessKeyID == accessKeyID {
+			keys[i].Status = status
+			key := keys[i]
+			key.SecretAccessKey = ""
+			return key, writeAccessKeys(dirPath, keys)
+		}
+	}
+	return models.AccessKey{}, ErrAccessKeyNotFound
+}
+
+// RotateAccessKey replaces an access key with a new one for the same user. The old key is
+// disabled rather than removed so it can be re-enabled if a client was missed.
+func RotateAccessKey(dirPath, accessKeyID string) (models.AccessKey, error) {
+	defer LockMetadataFile(dirPath + CredentialsFileName)()
+	keys, err := readAccessKeys(dirPath)
+	if err != nil {
+		return models.AccessKey{}, err
+	}
+	for i := range keys {
+		if keys[i].AccessKeyID == accessKeyID {
+			keys[i].Status = AccessKeyInactive
+			key := newAccessKey(keys[i].UserName)
+			return key, writeAccessKeys(dirPath, append(keys, key))
+		}
+	}
+	return models.AccessKey{}, ErrAccessKeyNotFound
+}
+
+// DeleteAccessKey permanently removes an access key
+func DeleteAccessKey(dirPath, accessKeyID string) error {
+	found := false
+	err := deleteAccessKeys(dirPath, func(key models.AccessKey) bool {
+		if key.AccessKeyID == accessKeyID {
+			found = true
+			return true
+		}
+		return false
+	})
+	if err == nil && !found {
+		return ErrAccessKeyNotFound
+	}
+	return err
+}
+
+// deleteAccessKeys removes the access keys selected by the predicate with a single rewrite
+func deleteAccessKeys(dirPath string, remove func(models.AccessKey) bool) error {
+	defer LockMetadataFile(dirPath + CredentialsFileName)()
+	keys, err := readAccessKeys(dirPath)
+	if err != nil || len(keys) == 0 {
+		return err
+	}
+
+	remaining := keys[:0]
+	for _, key := range keys {
+		if !remove(key) {
+			remaining = append(remaining, key)
+		}
+	}
+	return writeAccessKeys(dirPath, remaining)
+}
@@ -0,0 +1,38 @@
+package services
+
+import (
+	"os"
+	"testing"
+)
+
+func TestAuthStaysEnabledWithoutCredentials(t *testing.T) {
+	dir := t.TempDir() + "/"
+	if AuthEnabled(dir) {
+		t.Fatal("authentication enforced without a credentials file")
+	}
+	if err := os.WriteFile(dir+CredentialsFileName, []byte("AKIAEXAMPLE,secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if !AuthEnabled(dir) {
+		t.Fatal("authentication not enforced once a credentials file exists")
+	}
+
+	// Losing the file must not open the server
+	if err := os.Remove(dir + CredentialsFileName); err != nil {
+		t.Fatal(err)
+	}
+	if !AuthEnabled(dir) {
+		t.Error("authentication turned off by removing the credentials file")
+	}
+	if _, err := LookupAccessKey(dir, "AKIAEXAMPLE"); err != ErrAccessKeyNotFound {
+		t.Errorf("access key of a removed credentials file: %v", err)
+	}
+
+	if err := SetAuthMode(AuthOn); err != nil {
+		t.Fatal(err)
+	}
+	defer SetAuthMode(AuthAuto)
+	if !AuthEnabled(t.TempDir() + "/") {
+		t.Error("authentication not enforced with -auth on")
+	}
+}
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// readCSVFile reads every record of a CSV metadata file. Records may have differing
+// field counts since older rows predate columns added later.
+func readCSVFile(path string) ([][]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, errors.New("error reading CSV file: " + err.Error())
+	}
+	return records, nil
+}
+
+// writeCSVFile replaces the content of a CSV metadata file with the given records
+func writeCSVFile(path string, records [][]string) error {
+	return writeCSVFileMode(path, records, 0o644)
+}
+
+// writeCSVFileMode replaces the content of a CSV metadata file with the given records and
+// permissions, as writeFileSynced does
+func writeCSVFileMode(path string, records [][]string, perm os.FileMode) error {
+	data, err := encodeCSV(records)
+	if err != nil {
+		return err
+	}
+	return writeFileSynced(path, data, perm)
+}
+
+// encodeCSV returns the CSV encoding of records
+func encodeCSV(records [][]string) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := csv.NewWriter(&buf).WriteAll(records); err != nil {
+		return nil, errors.New("error writing CSV data: " + err.Error())
+	}
+	return buf.Bytes(), nil
+}
+
+// writeFileSynced replaces the content of a file with the given data and permissions. The
+// data is written to a temporary file synced to disk and renamed over the old one, so
+// readers, and the server after a crash, see either the previous or the new content and
+// never a partial file.
+func writeFileSynced(path string, data []byte, perm os.FileMode) error {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return errors.New("error opening file for writing: " + err.Error())
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	if _, err := file.Write(data); err != nil {
+		return errors.New("error writing file: " + err.Error())
+	}
+	if err := file.Chmod(perm); err != nil {
+		return errors.New("error writing file: " + err.Error())
+	}
+	if err := file.Sync(); err != nil {
+		return errors.New("error syncing file: " + err.Error())
+	}
+	if err := file.Close(); err != nil {
+		return errors.New("error writing file: " + err.Error())
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		return errors.New("error replacing file: " + err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,467 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Layout of a segment file: data blocks of entries sorted by key, the block index, and a
+// fixed-size footer. An entry is a deletion flag, the key and the object record, each length
+// prefixed. The block index holds the first key, offset and length of every block, followed
+// by the last key of the segment. The footer holds the offset of the block index, the number
+// of entries and segmentMagic.
+const (
+	segmentBlockSize  = 4 << 10
+	segmentMagic      = "TSX1"
+	segmentFooterSize = 8 + 8 + len(segmentMagic)
+)
+
+// segmentEntry is a key of a segment with its encoded object record, or a deletion hiding
+// the key in older segments
+type segmentEntry struct {
+	key     string
+	value   []byte
+	deleted bool
+}
+
+// blockHandle locates a data block of a segment
+type blockHandle struct {
+	firstKey string
+	offset   int64
+	length   int64
+}
+
+// segment is an immutable sorted file of entries. Only its block index is kept in memory;
+// blocks are read on demand through the block cache.
+type segment struct {
+	path string
+	// minSeq and maxSeq are the flush sequence numbers covered by the segment. A merged
+	// segment covers the ranges of the segments it replaces.
+	minSeq, maxSeq uint64
+	file           *os.File
+	blocks         []blockHandle
+	lastKey        string
+	entries        int
+}
+
+// segmentName is the file name of a segment covering the given sequence numbers
+func segmentName(minSeq, maxSeq uint64) string {
+	return fmt.Sprintf("%016x-%016x.seg", minSeq, maxSeq)
+}
+
+// writeSegment writes the entries returned by next, sorted by key, to a new segment file.
+// The file is synced and renamed into place before it is opened, so a crash leaves either
+// no segment or a complete one. Nil is returned when there are no entries.
+func writeSegment(dir string, minSeq, maxSeq uint64, next func() (segmentEntry, bool)) (*segment, error) {
+	file, err := os.CreateTemp(dir, ".segment-*")
+	if err != nil {
+		return nil, errors.New("error creating index segment: " + err.Error())
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	var (
+		blocks  []blockHandle
+		block   bytes.Buffer
+		offset  int64
+		entries int
+		lastKey string
+	)
+	flushBlock := func() error {
+		if block.Len() == 0 {
+			return nil
+		}
+		blocks[len(blocks)-1].length = int64(block.Len())
+		n, err := writer.Write(block.Bytes())
+		offset += int64(n)
+		block.Reset()
+		return err
+	}
+
+	for entry, ok := next(); ok; entry, ok = next() {
+		if block.Len() == 0 {
+			blocks = append(blocks, blockHandle{firstKey: entry.key, offset: offset})
+		}
+		if entry.deleted {
+			block.WriteByte(1)
+		} else {
+			block.WriteByte(0)
+		}
+		writeBytes(&block, []byte(entry.key))
+		writeBytes(&block, entry.value)
+		entries++
+		lastKey = entry.key
+		if block.Len() >= segmentBlockSize {
+			if err := flushBlock(); err != nil {
+				return nil, errors.New("error writing index segment: " + err.Error())
+			}
+		}
+	}
+	if entries == 0 {
+		return nil, nil
+	}
+	if err := flushBlock(); err != nil {
+		return nil, errors.New("error writing index segment: " + err.Error())
+	}
+
+	var index bytes.Buffer
+	index.Write(binary.AppendUvarint(nil, uint64(len(blocks))))
+	for _, handle := range blocks {
+		writeBytes(&index, []byte(handle.firstKey))
+		index.Write(binary.AppendUvarint(nil, uint64(handle.offset)))
+		index.Write(binary.AppendUvarint(nil, uint64(handle.length)))
+	}
+	writeBytes(&index, []byte(lastKey))
+	footer := binary.BigEndian.AppendUint64(nil, uint64(offset))
+	footer = binary.BigEndian.AppendUint64(footer, uint64(entries))
+	footer = append(footer, segmentMagic...)
+	writer.Write(index.Bytes())
+	writer.Write(footer)
+
+	if err := writer.Flush(); err != nil {
+		return nil, errors.New("error writing index segment: " + err.Error())
+	}
+	if err := file.Sync(); err != nil {
+		return nil, errors.New("error syncing index segment: " + err.Error())
+	}
+	if err := file.Close(); err != nil {
+		return nil, errors.New("error writing index segment: " + err.Error())
+	}
+	path := filepath.Join(dir, segmentName(minSeq, maxSeq))
+	if err := os.Rename(file.Name(), path); err != nil {
+		return nil, errors.New("error moving index segment into place: " + err.Error())
+	}
+	if err := syncPaths(dir); err != nil {
+		return nil, err
+	}
+	return openSegment(path)
+}
+
+// writeBytes appends a length-prefixed byte string to a buffer
+func writeBytes(buf *bytes.Buffer, data []byte) {
+	buf.Write(binary.AppendUvarint(nil, uint64(len(data))))
+	buf.Write(data)
+}
+
+// readBytes reads a length-prefixed byte string from the start of data and returns it with
+// the rest of data
+func readBytes(data []byte) ([]byte, []byte, error) {
+	length, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data)-n) < length {
+		return nil, nil, errors.New("corrupt index segment")
+	}
+	return data[n : n+int(length)], data[n+int(length):], nil
+}
+
+// readUvarint reads a number from the start of data and returns it with the rest of data
+func readUvarint(data []byte) (uint64, []byte, error) {
+	value, n := binary.Uvarint(data)
+	if n <= 0 {
+		return 0, nil, errors.New("corrupt index segment")
+	}
+	return value, data[n:], nil
+}
+
+// openSegment opens a segment file and loads its block index
+func openSegment(path string) (*segment, error) {
+	seg := &segment{path: path}
+	if _, err := fmt.Sscanf(filepath.Base(path), "%016x-%016x.seg", &seg.minSeq, &seg.maxSeq); err != nil {
+		return nil, errors.New("invalid index segment name " + filepath.Base(path))
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.New("error opening index segment: " + err.Error())
+	}
+	info, err := file.Stat()
+	if err != nil || info.Size() < int64(segmentFooterSize) {
+		file.Close()
+		return nil, errors.New("corrupt index segment " + path)
+	}
+	footer := make([]byte, segmentFooterSize)
+	if _, err := file.ReadAt(footer, info.Size()-int64(segmentFooterSize)); err != nil || string(footer[16:]) != segmentMagic {
+		file.Close()
+		return nil, errors.New("corrupt index segment " + path)
+	}
+	indexOffset := int64(binary.BigEndian.Uint64(footer))
+	seg.entries = int(binary.BigEndian.Uint64(footer[8:]))
+
+	index := make([]byte, info.Size()-int64(segmentFooterSize)-indexOffset)
+	if _, err := file.ReadAt(index, indexOffset); err != nil {
+		file.Close()
+		return nil, errors.New("error reading index segment: " + err.Error())
+	}
+	if err := seg.decodeIndex(index); err != nil {
+		file.Close()
+		return nil, errors.New(err.Error() + " " + path)
+	}
+	seg.file = file
+	return seg, nil
+}
+
+// decodeIndex loads the block index of a segment
+func (seg *segment) decodeIndex(data []byte) error {
+	count, data, err := readUvarint(data)
+	if err != nil {
+		return err
+	}
+	seg.blocks = make([]blockHandle, 0, count)
+	for i := uint64(0); i < count; i++ {
+		var handle blockHandle
+		var key []byte
+		var offset, length uint64
+		if key, data, err = readBytes(data); err != nil {
+			return err
+		}
+		if offset, data, err = readUvarint(data); err != nil {
+			return err
+		}
+		if length, data, err = readUvarint(data); err != nil {
+			return err
+		}
+		handle.firstKey, handle.offset, handle.length = string(key), int64(offset), int64(length)
+		seg.blocks = append(seg.blocks, handle)
+	}
+	lastKey, _, err := readBytes(data)
+	if err != nil {
+		return err
+	}
+	seg.lastKey = string(lastKey)
+	return nil
+}
+
+// readBlock reads and decodes a data block of the segment
+func (seg *segment) readBlock(block int) ([]segmentEntry, error) {
+	handle := seg.blocks[block]
+	data := make([]byte, handle.length)
+	if _, err := seg.file.ReadAt(data, handle.offset); err != nil && err != io.EOF {
+		return nil, errors.New("error reading index segment: " + err.Error())
+	}
+
+	var entries []segmentEntry
+	for len(data) > 0 {
+		var entry segmentEntry
+		var key, value []byte
+		var err error
+		entry.deleted = data[0] == 1
+		if key, data, err = readBytes(data[1:]); err != nil {
+			return nil, err
+		}
+		if value, data, err = readBytes(data); err != nil {
+			return nil, err
+		}
+		entry.key, entry.value = string(key), value
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// get looks a key up in the segment, reading at most one block
+func (seg *segment) get(key string, cache *blockCache) (segmentEntry, bool, error) {
+	if len(seg.blocks) == 0 || key < seg.blocks[0].firstKey || key > seg.lastKey {
+		return segmentEntry{}, false, nil
+	}
+	block := sort.Search(len(seg.blocks), func(i int) bool { return seg.blocks[i].firstKey > key }) - 1
+	entries, err := cache.get(seg, block)
+	if err != nil {
+		return segmentEntry{}, false, err
+	}
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].key >= key })
+	if i < len(entries) && entries[i].key == key {
+		return entries[i], true, nil
+	}
+	return segmentEntry{}, false, nil
+}
+
+// close releases the file of the segment
+func (seg *segment) close() {
+	seg.file.Close()
+}
+
+// entryIterator walks entries in key order
+type entryIterator interface {
+	// peek returns the current entry, or false once the iterator is exhausted
+	peek() (segmentEntry, bool)
+	advance()
+	err() error
+}
+
+// segmentIterator walks the entries of a segment from a starting key
+type segmentIterator struct {
+	seg     *segment
+	cache   *blockCache
+	block   int
+	entries []segmentEntry
+	pos     int
+	failure error
+}
+
+// iterate returns an iterator over the entries of the segment from the first key not less
+// than start. Blocks go through the cache when one is given.
+func (seg *segment) iterate(start string, cache *blockCache) *segmentIterator {
+	it := &segmentIterator{seg: seg, cache: cache}
+	it.block = sort.Search(len(seg.blocks), func(i int) bool { return seg.blocks[i].firstKey > start }) - 1
+	if it.block < 0 {
+		it.block = 0
+	}
+	it.load()
+	it.pos = sort.Search(len(it.entries), func(i int) bool { return it.entries[i].key >= start })
+	it.skipExhausted()
+	return it
+}
+
+// load reads the current block
+func (it *segmentIterator) load() {
+	it.entries, it.pos = nil, 0
+	if it.block >= len(it.seg.blocks) || it.failure != nil {
+		return
+	}
+	if it.cache != nil {
+		it.entries, it.failure = it.cache.get(it.seg, it.block)
+	} else {
+		it.entries, it.failure = it.seg.readBlock(it.block)
+	}
+}
+
+// skipExhausted moves to the next block once the current one is consumed
+func (it *segmentIterator) skipExhausted() {
+	for it.pos >= len(it.entries) && it.block < len(it.seg.blocks) && it.failure == nil {
+		it.block++
+		it.load()
+	}
+}
+
+func (it *segmentIterator) peek() (segmentEntry, bool) {
+	if it.pos < len(it.entries) {
+		return it.entries[it.pos], true
+	}
+	return segmentEntry{}, false
+}
+
+func (it *segmentIterator) advance() {
+	it.pos++
+	it.skipExhausted()
+}
+
+func (it *segmentIterator) err() error {
+	return it.failure
+}
+
+// sliceIterator walks sorted entries held in memory
+type sliceIterator struct {
+	entries []segmentEntry
+}
+
+func (it *sliceIterator) peek() (segmentEntry, bool) {
+	if len(it.entries) > 0 {
+		return it.entries[0], true
+	}
+	return segmentEntry{}, false
+}
+
+func (it *sliceIterator) advance() {
+	it.entries = it.entries[1:]
+}
+
+func (it *sliceIterator) err() error {
+	return nil
+}
+
+// mergeEntries combines iterators ordered from newest to oldest into a single iterator
+// function. Keys present in several iterators take the entry of the newest one.
+func mergeEntries(iterators []entryIterator) func() (segmentEntry, bool) {
+	return func() (segmentEntry, bool) {
+		var smallest segmentEntry
+		found := false
+		for _, it := range iterators {
+			if entry, ok := it.peek(); ok && (!found || entry.key < smallest.key) {
+				smallest, found = entry, true
+			}
+		}
+		if !found {
+			return segmentEntry{}, false
+		}
+		for _, it := range iterators {
+			if entry, ok := it.peek(); ok && entry.key == smallest.key {
+				it.advance()
+			}
+		}
+		return smallest, true
+	}
+}
+
+// blockCache keeps recently read blocks of every segment of a store, evicting the least
+// recently used block once it holds capacity blocks
+type blockCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	blocks   map[blockID]*list.Element
+}
+
+// blockID names a block of a segment
+type blockID struct {
+	seg   *segment
+	block int
+}
+
+// cachedBlock is an element of the cache's recency list
+type cachedBlock struct {
+	id      blockID
+	entries []segmentEntry
+}
+
+func newBlockCache(capacity int) *blockCache {
+	return &blockCache{capacity: capacity, order: list.New(), blocks: make(map[blockID]*list.Element)}
+}
+
+// get returns a block of a segment, reading it on a miss
+func (cache *blockCache) get(seg *segment, block int) ([]segmentEntry, error) {
+	id := blockID{seg: seg, block: block}
+	cache.mu.Lock()
+	if element, ok := cache.blocks[id]; ok {
+		cache.order.MoveToFront(element)
+		cache.mu.Unlock()
+		return element.Value.(*cachedBlock).entries, nil
+	}
+	cache.mu.Unlock()
+
+	entries, err := seg.readBlock(block)
+	if err != nil {
+		return nil, err
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if _, ok := cache.blocks[id]; !ok {
+		cache.blocks[id] = cache.order.PushFront(&cachedBlock{id: id, entries: entries})
+		for cache.order.Len() > cache.capacity {
+			oldest := cache.order.Back()
+			cache.order.Remove(oldest)
+			delete(cache.blocks, oldest.Value.(*cachedBlock).id)
+		}
+	}
+	return entries, nil
+}
+
+// evict drops the blocks of a segment that was removed
+func (cache *blockCache) evict(seg *segment) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	for block := range seg.blocks {
+		if element, ok := cache.blocks[blockID{seg: seg, block: block}]; ok {
+			cache.order.Remove(element)
+			delete(cache.blocks, blockID{seg: seg, block: block})
+		}
+	}
+}
@@ -0,0 +1,615 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"errors"
+	"hash/crc32"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"triple-s/internal/models"
+)
+
+// journalPath is the write-ahead journal of a data directory. It lives in the internal
+// directory of the data directory, which bucket names cannot collide with since they never
+// start with a dot.
+func journalPath(dirPath string) string {
+	return filepath.Join(dirPath, InternalDirName, "journal.log")
+}
+
+// Journal operations besides the metadata store changes. File entries hold the path of a
+// bucket file relative to the data directory and its whole new content; batch entries hold
+// the encoded entries of one logical operation.
+const (
+	opWriteFile  = "write"
+	opRemoveFile = "unlink"
+	opBatch      = "batch"
+)
+
+// journal is an append-only file of metadata changes. Every entry is synced to disk before
+// the change is applied, so a change that was acknowledged survives a crash.
+//
+// Each line is a CSV record made of the operation, its fields and a CRC-32 checksum of the
+// other fields, which tells a complete entry from one torn by a crash.
+type journal struct {
+	mu      sync.Mutex
+	file    *os.File
+	entries int
+}
+
+// openJournal opens the journal of a data directory and returns the entries it holds. A torn
+// entry at the end of the journal was never acknowledged, so it is dropped along with
+// anything after it.
+func openJournal(dirPath string) (*journal, [][]string, error) {
+	path := journalPath(dirPath)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, nil, errors.New("error creating journal directory: " + err.Error())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, errors.New("error reading journal: " + err.Error())
+	}
+
+	var entries [][]string
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	valid := int64(0)
+	for {
+		record, err := reader.Read()
+		if err != nil || len(record) < 2 || journalChecksum(record[:len(record)-1]) != record[len(record)-1] {
+			break
+		}
+		entries = append(entries, record[:len(record)-1])
+		valid = reader.InputOffset()
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, nil, errors.New("error opening journal: " + err.Error())
+	}
+	if valid < int64(len(data)) {
+		log.Printf("Dropping %d bytes of incomplete journal entries\n", int64(len(data))-valid)
+		if err := file.Truncate(valid); err != nil {
+			file.Close()
+			return nil, nil, errors.New("error repairing journal: " + err.Error())
+		}
+	}
+	if _, err := file.Seek(valid, 0); err != nil {
+		file.Close()
+		return nil, nil, errors.New("error opening journal: " + err.Error())
+	}
+	return &journal{file: file, entries: len(entries)}, entries, nil
+}
+
+// journalChecksum is the checksum of the CSV encoding of an entry
+func journalChecksum(record []string) string {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Write(record)
+	writer.Flush()
+	return strconv.FormatUint(uint64(crc32.ChecksumIEEE(buf.Bytes())), 16)
+}
+
+// append writes an entry at the end of the journal and syncs it to disk
+func (j *journal) append(record ...string) error {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	if err := writer.Write(append(record, journalChecksum(record))); err != nil {
+		return errors.New("error encoding journal entry: " + err.Error())
+	}
+	writer.Flush()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.file.Write(buf.Bytes()); err != nil {
+		return errors.New("error writing journal: " + err.Error())
+	}
+	if err := j.file.Sync(); err != nil {
+		return errors.New("error syncing journal: " + err.Error())
+	}
+	j.entries++
+	return nil
+}
+
+// reset empties the journal once its changes are safely on disk elsewhere
+func (j *journal) reset() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.file.Truncate(0); err != nil {
+		return errors.New("error truncating journal: " + err.Error())
+	}
+	if _, err := j.file.Seek(0, 0); err != nil {
+		return errors.New("error truncating journal: " + err.Error())
+	}
+	if err := j.file.Sync(); err != nil {
+		return errors.New("error syncing journal: " + err.Error())
+	}
+	j.entries = 0
+	return nil
+}
+
+// syncedStore is implemented by stores that do not sync every change to disk as it is
+// applied. syncFiles flushes the files holding the bucket list, for an empty bucket name,
+// or the objects of a bucket.
+type syncedStore interface {
+	syncFiles(bucketName string) error
+}
+
+// syncPaths flushes files and directories to disk, skipping the ones that do not exist
+func syncPaths(paths ...string) error {
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return errors.New("error syncing metadata: " + err.Error())
+		}
+		err = file.Sync()
+		file.Close()
+		if err != nil {
+			return errors.New("error syncing metadata: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// journaledStore records every change of another store in the journal before applying it.
+// On opening, the entries left by the previous run are applied again; every change sets
+// records to given values, so applying an entry twice has no further effect.
+//
+// The other metadata files of a bucket, the version records of each key, its policy and
+// lifecycle configuration, are journaled with their whole new content. Operations changing several
+// records, such as archiving a version while replacing the object record, journal them as a
+// single batch entry, so a crash leaves either all or none of them. Object data is not
+// journaled: it is moved into place before its records are journaled, and a crash in
+// between leaves unrecorded files that later writes replace. Multipart upload state is not
+// journaled either, since an interrupted upload is simply started again.
+//
+// Checkpoints sync the metadata files changed since the previous checkpoint and empty the
+// journal, so the files of the underlying store are the snapshot the journal builds on.
+type journaledStore struct {
+	MetadataStore
+	dirPath string
+
+	once    sync.Once
+	openErr error
+	journal *journal
+
+	// applying is held shared by every change from journaling to applying it, and
+	// exclusively by checkpoints, so that a checkpoint never drops a change in flight
+	applying sync.RWMutex
+	// bucketMu orders the bucket changes so that the journal records them as applied
+	bucketMu sync.Mutex
+
+	dirtyMu sync.Mutex
+	dirty   map[string]bool
+}
+
+func newJournaledStore(dirPath string, store MetadataStore) *journaledStore {
+	return &journaledStore{MetadataStore: store, dirPath: dirPath, dirty: make(map[string]bool)}
+}
+
+// open replays the journal on first use and returns the error that prevented it, if any
+func (store *journaledStore) open() error {
+	store.once.Do(func() {
+		store.openErr = store.recover()
+	})
+	return store.openErr
+}
+
+// recover applies the entries of the journal left by the previous run, then checkpoints
+func (store *journaledStore) recover() error {
+	journal, entries, err := openJournal(store.dirPath)
+	if err != nil {
+		return err
+	}
+	store.journal = journal
+
+	for _, entry := range entries {
+		if err := store.replay(entry); err != nil {
+			log.Printf("Error replaying journal entry %s: %v\n", entry[0], err)
+		}
+	}
+	if len(entries) > 0 {
+		log.Printf("Replayed %d metadata changes from the journal\n", len(entries))
+	}
+	return store.Checkpoint()
+}
+
+// replay applies a journal entry to the underlying store
+func (store *journaledStore) replay(entry []string) error {
+	switch {
+	case entry[0] == opBatch:
+		for _, field := range entry[1:] {
+			change, err := decodeJournalEntry(field)
+			if err != nil {
+				return err
+			}
+			if err := store.replay(change); err != nil {
+				return err
+			}
+		}
+		return nil
+	case entry[0] == opWriteFile && len(entry) >= 3:
+		path, err := store.bucketFile(entry[1])
+		if err != nil || path == "" {
+			return err
+		}
+		data, err := base64.StdEncoding.DecodeString(entry[2])
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			return errors.New("error creating internal directory: " + err.Error())
+		}
+		return writeFileSynced(path, data, 0o644)
+	case entry[0] == opRemoveFile && len(entry) >= 2:
+		path, err := store.bucketFile(entry[1])
+		if err != nil || path == "" {
+			return err
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return errors.New("error removing file: " + err.Error())
+		}
+		return nil
+	case entry[0] == opCreateBucket && len(entry) >= 5:
+		bucket, err := decodeBucketRecord(entry[1:])
+		if err != nil {
+			return err
+		}
+		// The bucket may have been recorded before the crash
+		if current, err := store.MetadataStore.GetBucket(bucket.Name); err == nil && current.CreationTime == bucket.CreationTime {
+			return nil
+		}
+		store.markDirty("")
+		return store.MetadataStore.CreateBucket(bucket)
+	case entry[0] == opUpdateBucket && len(entry) >= 5:
+		bucket, err := decodeBucketRecord(entry[1:])
+		if err != nil {
+			return err
+		}
+		store.markDirty("")
+		err = store.MetadataStore.UpdateBucket(bucket.Name, func(current *models.Bucket) {
+			*current = bucket
+		})
+		if err == ErrBucketNotFound {
+			return nil
+		}
+		return err
+	case entry[0] == opDeleteBucket && len(entry) >= 3:
+		name, _ := base64.StdEncoding.DecodeString(entry[1])
+		modTime, _ := base64.StdEncoding.DecodeString(entry[2])
+		store.markDirty("")
+		return store.MetadataStore.DeleteBucket(string(name), string(modTime))
+	case entry[0] == opPutObject && len(entry) >= 6:
+		bucketName, _ := base64.StdEncoding.DecodeString(entry[1])
+		if !store.bucketExists(string(bucketName)) {
+			return nil
+		}
+		object, err := decodeObjectRecord(entry[2:])
+		if err != nil {
+			return err
+		}
+		store.markDirty(string(bucketName))
+		return store.MetadataStore.PutObject(string(bucketName), object)
+	case entry[0] == opDeleteObject && len(entry) >= 2:
+		bucketName, _ := base64.StdEncoding.DecodeString(entry[1])
+		if !store.bucketExists(string(bucketName)) {
+			return nil
+		}
+		var keys []string
+		for _, field := range entry[2:] {
+			key, _ := base64.StdEncoding.DecodeString(field)
+			keys = append(keys, string(key))
+		}
+		store.markDirty(string(bucketName))
+		return store.MetadataStore.DeleteObjects(string(bucketName), keys)
+	}
+	return errors.New("malformed entry")
+}
+
+// bucketExists reports whether the directory of a bucket exists. Replayed object changes of
+// buckets removed later in the journal are skipped.
+func (store *journaledStore) bucketExists(bucketName string) bool {
+	_, err := os.Stat(store.dirPath + bucketName)
+	return err == nil
+}
+
+// bucketFile resolves the journaled path of a bucket file, relative to the data directory.
+// Files of buckets removed later in the journal resolve to an empty path and are skipped.
+func (store *journaledStore) bucketFile(field string) (string, error) {
+	relPath, err := base64.StdEncoding.DecodeString(field)
+	if err != nil {
+		return "", err
+	}
+	bucketName, _, ok := strings.Cut(filepath.ToSlash(string(relPath)), "/")
+	if !ok || !filepath.IsLocal(string(relPath)) {
+		return "", errors.New("malformed file path")
+	}
+	if !store.bucketExists(bucketName) {
+		return "", nil
+	}
+	return filepath.Join(store.dirPath, string(relPath)), nil
+}
+
+// markDirty remembers that the files of a bucket, or of the bucket list for an empty name,
+// must be synced by the next checkpoint
+func (store *journaledStore) markDirty(bucketName string) {
+	store.dirtyMu.Lock()
+	store.dirty[bucketName] = true
+	store.dirtyMu.Unlock()
+}
+
+// Checkpoint syncs the metadata changed since the previous checkpoint and empties the
+// journal. Changes wait for the checkpoint to finish.
+func (store *journaledStore) Checkpoint() error {
+	if store.journal == nil {
+		return store.open()
+	}
+	store.applying.Lock()
+	defer store.applying.Unlock()
+	if store.journal.entries == 0 {
+		return nil
+	}
+
+	store.dirtyMu.Lock()
+	defer store.dirtyMu.Unlock()
+	if synced, ok := store.MetadataStore.(syncedStore); ok {
+		for bucketName := range store.dirty {
+			if err := synced.syncFiles(bucketName); err != nil {
+				return err
+			}
+		}
+	}
+	if err := store.journal.reset(); err != nil {
+		return err
+	}
+	store.dirty = make(map[string]bool)
+	return nil
+}
+
+func (store *journaledStore) ListBuckets() ([]models.Bucket, error) {
+	if err := store.open(); err != nil {
+		return nil, err
+	}
+	return store.MetadataStore.ListBuckets()
+}
+
+func (store *journaledStore) GetBucket(bucketName string) (models.Bucket, error) {
+	if err := store.open(); err != nil {
+		return models.Bucket{}, err
+	}
+	return store.MetadataStore.GetBucket(bucketName)
+}
+
+func (store *journaledStore) CreateBucket(bucket models.Bucket) error {
+	if err := store.open(); err != nil {
+		return err
+	}
+	store.applying.RLock()
+	defer store.applying.RUnlock()
+	store.bucketMu.Lock()
+	defer store.bucketMu.Unlock()
+
+	if err := store.journal.append(append([]string{opCreateBucket}, encodeBucketRecord(bucket)...)...); err != nil {
+		return err
+	}
+	store.markDirty("")
+	return store.MetadataStore.CreateBucket(bucket)
+}
+
+func (store *journaledStore) UpdateBucket(bucketName string, update func(*models.Bucket)) error {
+	if err := store.open(); err != nil {
+		return err
+	}
+	store.applying.RLock()
+	defer store.applying.RUnlock()
+	store.bucketMu.Lock()
+	defer store.bucketMu.Unlock()
+
+	// The journal needs the updated record, so the update is computed before it is applied
+	bucket, err := store.MetadataStore.GetBucket(bucketName)
+	if err != nil {
+		return err
+	}
+	update(&bucket)
+	if err := store.journal.append(append([]string{opUpdateBucket}, encodeBucketRecord(bucket)...)...); err != nil {
+		return err
+	}
+	store.markDirty("")
+	return store.MetadataStore.UpdateBucket(bucketName, func(current *models.Bucket) {
+		*current = bucket
+	})
+}
+
+func (store *journaledStore) DeleteBucket(bucketName, modTime string) error {
+	if err := store.open(); err != nil {
+		return err
+	}
+	store.applying.RLock()
+	defer store.applying.RUnlock()
+	store.bucketMu.Lock()
+	defer store.bucketMu.Unlock()
+
+	err := store.journal.append(
+		opDeleteBucket,
+		base64.StdEncoding.EncodeToString([]byte(bucketName)),
+		base64.StdEncoding.EncodeToString([]byte(modTime)),
+	)
+	if err != nil {
+		return err
+	}
+	store.markDirty("")
+	return store.MetadataStore.DeleteBucket(bucketName, modTime)
+}
+
+func (store *journaledStore) GetObject(bucketName, objectKey string) (models.Object, error) {
+	if err := store.open(); err != nil {
+		return models.Object{}, err
+	}
+	return store.MetadataStore.GetObject(bucketName, objectKey)
+}
+
+// PutObject journals and applies an object change. Changes to the same key are ordered by
+// the object locks, so the journal records them in the order they are applied.
+func (store *journaledStore) PutObject(bucketName string, object models.Object) error {
+	if err := store.open(); err != nil {
+		return err
+	}
+	store.applying.RLock()
+	defer store.applying.RUnlock()
+
+	if err := store.journal.append(putObjectEntry(bucketName, object)...); err != nil {
+		return err
+	}
+	store.markDirty(bucketName)
+	return store.MetadataStore.PutObject(bucketName, object)
+}
+
+func (store *journaledStore) DeleteObjects(bucketName string, objectKeys []string) error {
+	if err := store.open(); err != nil {
+		return err
+	}
+	store.applying.RLock()
+	defer store.applying.RUnlock()
+
+	if err := store.journal.append(deleteObjectsEntry(bucketName, objectKeys)...); err != nil {
+		return err
+	}
+	store.markDirty(bucketName)
+	return store.MetadataStore.DeleteObjects(bucketName, objectKeys)
+}
+
+func (store *journaledStore) ScanObjects(bucketName, prefix, startAfter string, fn func(models.Object) bool) error {
+	if err := store.open(); err != nil {
+		return err
+	}
+	return store.MetadataStore.ScanObjects(bucketName, prefix, startAfter, fn)
+}
+
+// apply journals the changes of one logical operation as a single entry and applies them
+func (store *journaledStore) apply(changes [][]string) error {
+	if err := store.open(); err != nil {
+		return err
+	}
+	store.applying.RLock()
+	defer store.applying.RUnlock()
+
+	entry := changes[0]
+	if len(changes) > 1 {
+		entry = []string{opBatch}
+		for _, change := range changes {
+			field, err := encodeJournalEntry(change)
+			if err != nil {
+				return err
+			}
+			entry = append(entry, field)
+		}
+	}
+	if err := store.journal.append(entry...); err != nil {
+		return err
+	}
+	for _, change := range changes {
+		if err := store.replay(change); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeJournalEntry encodes an entry as a single field of a batch entry
+func encodeJournalEntry(entry []string) (string, error) {
+	data, err := encodeCSV([][]string{entry})
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// decodeJournalEntry decodes an entry of a batch entry
+func decodeJournalEntry(field string) ([]string, error) {
+	data, err := base64.StdEncoding.DecodeString(field)
+	if err != nil {
+		return nil, err
+	}
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	entry, err := reader.Read()
+	if err != nil {
+		return nil, errors.New("malformed batch entry")
+	}
+	return entry, nil
+}
+
+// putObjectEntry is the journal entry storing the metadata of an object
+func putObjectEntry(bucketName string, object models.Object) []string {
+	return append([]string{opPutObject, base64.StdEncoding.EncodeToString([]byte(bucketName))}, encodeObjectRecord(object)...)
+}
+
+// deleteObjectsEntry is the journal entry removing the metadata of objects
+func deleteObjectsEntry(bucketName string, objectKeys []string) []string {
+	entry := []string{opDeleteObject, base64.StdEncoding.EncodeToString([]byte(bucketName))}
+	for _, key := range objectKeys {
+		entry = append(entry, base64.StdEncoding.EncodeToString([]byte(key)))
+	}
+	return entry
+}
+
+// metadataChanges collects the changes of one logical operation, such as archiving a version
+// while removing the object record, so that they are journaled as a single entry
+type metadataChanges struct {
+	dirPath string
+	entries [][]string
+}
+
+func newMetadataChanges(dirPath string) *metadataChanges {
+	return &metadataChanges{dirPath: dirPath}
+}
+
+// putObject stores the metadata of an object
+func (changes *metadataChanges) putObject(bucketName string, object models.Object) {
+	changes.entries = append(changes.entries, putObjectEntry(bucketName, object))
+}
+
+// deleteObjects removes the metadata of objects
+func (changes *metadataChanges) deleteObjects(bucketName string, objectKeys ...string) {
+	changes.entries = append(changes.entries, deleteObjectsEntry(bucketName, objectKeys))
+}
+
+// writeFile replaces the content of a file inside a bucket
+func (changes *metadataChanges) writeFile(path string, data []byte) error {
+	relPath, err := filepath.Rel(changes.dirPath, path)
+	if err != nil {
+		return err
+	}
+	changes.entries = append(changes.entries, []string{
+		opWriteFile,
+		base64.StdEncoding.EncodeToString([]byte(relPath)),
+		base64.StdEncoding.EncodeToString(data),
+	})
+	return nil
+}
+
+// removeFile removes a file inside a bucket, if it exists
+func (changes *metadataChanges) removeFile(path string) error {
+	relPath, err := filepath.Rel(changes.dirPath, path)
+	if err != nil {
+		return err
+	}
+	changes.entries = append(changes.entries, []string{opRemoveFile, base64.StdEncoding.EncodeToString([]byte(relPath))})
+	return nil
+}
+
+// apply journals and applies the collected changes
+func (changes *metadataChanges) apply() error {
+	if len(changes.entries) == 0 {
+		return nil
+	}
+	return journaledMetadata(changes.dirPath).apply(changes.entries)
+}
@@ -0,0 +1,313 @@
+package services
+
+import (
+	"encoding/xml"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"triple-s/internal/models"
+)
+
+// ErrLifecycleNotFound is returned when a bucket has no lifecycle configuration
+var ErrLifecycleNotFound = errors.New("lifecycle configuration not found")
+
+// lifecycleFile returns the path of the file holding the lifecycle configuration of a bucket
+func lifecycleFile(dirPath, bucketName string) string {
+	return filepath.Join(dirPath+bucketName, InternalDirName, "lifecycle.xml")
+}
+
+// ReadLifecycle loads the lifecycle configuration of a bucket
+func ReadLifecycle(dirPath, bucketName string) (models.LifecycleConfiguration, error) {
+	var configuration models.LifecycleConfiguration
+	data, err := os.ReadFile(lifecycleFile(dirPath, bucketName))
+	if os.IsNotExist(err) {
+		return configuration, ErrLifecycleNotFound
+	} else if err != nil {
+		return configuration, errors.New("error reading lifecycle configuration: " + err.Error())
+	}
+	if err := xml.Unmarshal(data, &configuration); err != nil {
+		return configuration, errors.New("error decoding lifecycle configuration: " + err.Error())
+	}
+	return configuration, nil
+}
+
+// WriteLifecycle replaces the lifecycle configuration of a bucket through the journal
+func WriteLifecycle(dirPath, bucketName string, configuration models.LifecycleConfiguration) error {
+	data, err := xml.MarshalIndent(configuration, "", "  ")
+	if err != nil {
+		return errors.New("error encoding lifecycle configuration: " + err.Error())
+	}
+	changes := newMetadataChanges(dirPath)
+	if err := changes.writeFile(lifecycleFile(dirPath, bucketName), data); err != nil {
+		return errors.New("error writing lifecycle configuration: " + err.Error())
+	}
+	if err := changes.apply(); err != nil {
+		return errors.New("error writing lifecycle configuration: " + err.Error())
+	}
+	return nil
+}
+
+// DeleteLifecycle removes the lifecycle configuration of a bucket, if any, through the journal
+func DeleteLifecycle(dirPath, bucketName string) error {
+	changes := newMetadataChanges(dirPath)
+	if err := changes.removeFile(lifecycleFile(dirPath, bucketName)); err != nil {
+		return errors.New("error removing lifecycle configuration: " + err.Error())
+	}
+	if err := changes.apply(); err != nil {
+		return errors.New("error removing lifecycle configuration: " + err.Error())
+	}
+	return nil
+}
+
+// RuleFilter returns the key prefix and the tags an object must carry for a rule to apply
+func RuleFilter(rule models.LifecycleRule) (string, []models.Tag) {
+	if rule.Filter == nil {
+		return rule.Prefix, nil
+	}
+	if rule.Filter.And != nil {
+		return rule.Filter.And.Prefix, rule.Filter.And.Tags
+	}
+	if rule.Filter.Tag != nil {
+		return rule.Filter.Prefix, []models.Tag{*rule.Filter.Tag}
+	}
+	return rule.Filter.Prefix, nil
+}
+
+// ruleMatches reports whether a rule applies to an object with the given key and tags
+func ruleMatches(rule models.LifecycleRule, objectKey string, tags map[string]string) bool {
+	prefix, ruleTags := RuleFilter(rule)
+	if !strings.HasPrefix(objectKey, prefix) {
+		return false
+	}
+	for _, tag := range ruleTags {
+		if value, ok := tags[tag.Key]; !ok || value != tag.Value {
+			return false
+		}
+	}
+	return true
+}
+
+// expiryTime returns when something that started at the given time is due after a number of
+// days. As in Amazon S3, the time is rounded up to the following midnight UTC.
+func expiryTime(start time.Time, days int) time.Time {
+	due := start.UTC().AddDate(0, 0, days)
+	midnight := due.Truncate(24 * time.Hour)
+	if midnight.Before(due) {
+		midnight = midnight.Add(24 * time.Hour)
+	}
+	return midnight
+}
+
+// objectExpired reports whether the Expiration action of a rule is due for a current object
+func objectExpired(expiration *models.LifecycleExpiration, object models.Object, now time.Time) bool {
+	if expiration.Date != "" {
+		date, err := time.Parse(time.RFC3339, expiration.Date)
+		return err == nil && !now.Before(date)
+	}
+	if expiration.Days <= 0 {
+		return false
+	}
+	modTime, err := time.Parse(time.RFC3339, object.LastModifiedTime)
+	return err == nil && !now.Before(expiryTime(modTime, expiration.Days))
+}
+
+// ApplyLifecycle applies the lifecycle rules of a bucket as of the given time, logging each action
+func ApplyLifecycle(dirPath, bucketName string, now time.Time) {
+	configuration, err := ReadLifecycle(dirPath, bucketName)
+	if err == ErrLifecycleNotFound {
+		return
+	} else if err != nil {
+		log.Println("Error reading lifecycle of bucket", bucketName+":", err)
+		return
+	}
+
+	versioning, err := BucketVersioning(dirPath, bucketName)
+	if err != nil {
+		log.Println("Error reading versioning of bucket", bucketName+":", err)
+		return
+	}
+	for _, rule := range configuration.Rules {
+		if rule.Status != "Enabled" {
+			continue
+		}
+		if rule.Expiration != nil {
+			expireObjects(dirPath, bucketName, versioning, rule, now)
+		}
+		if rule.NoncurrentVersionExpiration != nil || (rule.Expiration != nil && rule.Expiration.ExpiredObjectDeleteMarker) {
+			expireVersions(dirPath, bucketName, rule, now)
+		}
+		if rule.AbortIncompleteMultipartUpload != nil {
+			abortExpiredUploads(dirPath, bucketName, rule, now)
+		}
+	}
+}
+
+// expireObjects applies the Expiration action of a rule to the current versions of objects.
+// Buckets with versioning configured keep the data behind a delete marker.
+func expireObjects(dirPath, bucketName, versioning string, rule models.LifecycleRule, now time.Time) {
+	objects, err := ListObjectInfo(dirPath, bucketName, "")
+	if err != nil {
+		log.Println("Error listing objects of bucket", bucketName+":", err)
+		return
+	}
+	var candidates []string
+	isCandidate := make(map[string]bool)
+	for _, object := range objects {
+		if ruleMatches(rule, object.ObjectKey, object.Tags) && objectExpired(rule.Expiration, object, now) {
+			candidates = append(candidates, object.ObjectKey)
+			isCandidate[object.ObjectKey] = true
+		}
+	}
+	if len(candidates) == 0 {
+		return
+	}
+
+	// Uploads may have replaced the candidates since they were listed, so they are checked
+	// again while locked
+	defer LockObjects(dirPath, bucketName, candidates)()
+	objects, err = ListObjectInfo(dirPath, bucketName, "")
+	if err != nil {
+		log.Println("Error listing objects of bucket", bucketName+":", err)
+		return
+	}
+
+	var expiredKeys []string
+	for _, object := range objects {
+		if !isCandidate[object.ObjectKey] || !ruleMatches(rule, object.ObjectKey, object.Tags) || !objectExpired(rule.Expiration, object, now) {
+			continue
+		}
+		if versioning != "" {
+			if _, err := CreateDeleteMarker(dirPath, bucketName, object.ObjectKey, versioning); err != nil {
+				log.Println("Error expiring object", bucketName+"/"+object.ObjectKey+":", err)
+				continue
+			}
+		} else {
+			objectPath, err := ObjectPath(dirPath, bucketName, object.ObjectKey)
+			if err != nil {
+				continue
+			}
+			if err := os.Remove(objectPath); err != nil && !os.IsNotExist(err) {
+				log.Println("Error expiring object", bucketName+"/"+object.ObjectKey+":", err)
+				continue
+			}
+			RemoveEmptyParents(dirPath, bucketName, objectPath)
+			expiredKeys = append(expiredKeys, object.ObjectKey)
+		}
+		log.Printf("Lifecycle rule %q expired %s/%s\n", rule.ID, bucketName, object.ObjectKey)
+	}
+
+	// Metadata of unversioned objects is removed in a single rewrite
+	if len(expiredKeys) > 0 {
+		if err := DeleteObjectInfos(dirPath, bucketName, expiredKeys); err != nil {
+			log.Println("Error writing object metadata of bucket", bucketName+":", err)
+		}
+	}
+}
+
+// expireVersions applies the NoncurrentVersionExpiration action of a rule and removes delete
+// markers left without any other version when the rule asks for it
+func expireVersions(dirPath, bucketName string, rule models.LifecycleRule, now time.Time) {
+	versions, err := ListVersionInfo(dirPath, bucketName)
+	if err != nil {
+		log.Println("Error listing versions of bucket", bucketName+":", err)
+		return
+	}
+
+	// Count the versions of each key to find delete markers standing alone
+	versionCount := make(map[string]int)
+	for _, version := range versions {
+		versionCount[version.Object.ObjectKey]++
+	}
+
+	for _, version := range versions {
+		object := version.Object
+		if !ruleMatches(rule, object.ObjectKey, object.Tags) {
+			continue
+		}
+
+		var action string
+		if version.IsDeleteMarker {
+			if rule.Expiration == nil || !rule.Expiration.ExpiredObjectDeleteMarker || versionCount[object.ObjectKey] != 1 {
+				continue
+			}
+			if _, err := ReadObjectInfo(dirPath, bucketName, object.ObjectKey); err != ErrObjectNotFound {
+				continue
+			}
+			action = "removed expired delete marker"
+		} else {
+			if rule.NoncurrentVersionExpiration == nil || rule.NoncurrentVersionExpiration.NoncurrentDays <= 0 {
+				continue
+			}
+			archived, err := time.Parse(time.RFC3339, version.ArchivedTime)
+			if err != nil || now.Before(expiryTime(archived, rule.NoncurrentVersionExpiration.NoncurrentDays)) {
+				continue
+			}
+			action = "expired noncurrent version"
+		}
+
+		unlock := LockObject(dirPath, bucketName, object.ObjectKey)
+		_, err := DeleteObjectVersion(dirPath, bucketName, object.ObjectKey, object.VersionID)
+		unlock()
+		if err != nil {
+			log.Println("Error expiring version of", bucketName+"/"+object.ObjectKey+":", err)
+			continue
+		}
+		versionCount[object.ObjectKey]--
+		log.Printf("Lifecycle rule %q %s %s of %s/%s\n", rule.ID, action, versionLabel(object.VersionID), bucketName, object.ObjectKey)
+	}
+}
+
+// versionLabel names a version ID in log messages
+func versionLabel(versionID string) string {
+	if versionID == "" {
+		return "null"
+	}
+	return versionID
+}
+
+// abortExpiredUploads applies the AbortIncompleteMultipartUpload action of a rule
+func abortExpiredUploads(dirPath, bucketName string, rule models.LifecycleRule, now time.Time) {
+	days := rule.AbortIncompleteMultipartUpload.DaysAfterInitiation
+	if days <= 0 {
+		return
+	}
+	uploads, err := ListMultipartUploads(dirPath, bucketName)
+	if err != nil {
+		log.Println("Error listing uploads of bucket", bucketName+":", err)
+		return
+	}
+
+	prefix, _ := RuleFilter(rule)
+	for _, upload := range uploads {
+		initiated, err := time.Parse(time.RFC3339, upload.Initiated)
+		if err != nil || !strings.HasPrefix(upload.Key, prefix) || now.Before(expiryTime(initiated, days)) {
+			continue
+		}
+		unlock := LockUpload(dirPath, bucketName, upload.UploadID)
+		err = AbortMultipartUpload(dirPath, bucketName, upload.UploadID)
+		unlock()
+		if err != nil {
+			log.Println("Error aborting upload", upload.UploadID+":", err)
+			continue
+		}
+		log.Printf("Lifecycle rule %q aborted upload %s of %s/%s\n", rule.ID, upload.UploadID, bucketName, upload.Key)
+	}
+}
+
+// RunLifecycle applies the lifecycle rules of every bucket in the data directory
+func RunLifecycle(dirPath string, now time.Time) {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		log.Println("Error reading data directory:", err)
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			ApplyLifecycle(dirPath, entry.Name(), now)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"sort"
+	"sync"
+)
+
+// lockTable hands out a lock per name and forgets it once nobody holds or waits for it,
+// so that locking many buckets and keys does not grow memory without bound
+type lockTable struct {
+	mu    sync.Mutex
+	locks map[string]*namedLock
+}
+
+// namedLock is a lock of a lockTable with the number of its holders and waiters
+type namedLock struct {
+	sync.RWMutex
+	refs int
+}
+
+// acquire locks a name, shared or exclusively, and returns the function releasing it
+func (table *lockTable) acquire(name string, shared bool) func() {
+	table.mu.Lock()
+	if table.locks == nil {
+		table.locks = make(map[string]*namedLock)
+	}
+	lock := table.locks[name]
+	if lock == nil {
+		lock = &namedLock{}
+		table.locks[name] = lock
+	}
+	lock.refs++
+	table.mu.Unlock()
+
+	if shared {
+		lock.RLock()
+	} else {
+		lock.Lock()
+	}
+
+	return func() {
+		if shared {
+			lock.RUnlock()
+		} else {
+			lock.Unlock()
+		}
+		table.mu.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(table.locks, name)
+		}
+		table.mu.Unlock()
+	}
+}
+
+var (
+	// bucketLocks are held shared while objects change and exclusively while a bucket is removed
+	bucketLocks lockTable
+	// objectLocks serialize the requests changing the same object key
+	objectLocks lockTable
+	// uploadLocks serialize the part commits, completion and abort of a multipart upload
+	uploadLocks lockTable
+	// fileLocks serialize the read-modify-write cycles of each metadata file
+	fileLocks lockTable
+	// dirLocks are held shared while the directories of a key are created and an object is
+	// moved into them, and exclusively while a delete removes the directories it left empty
+	dirLocks lockTable
+)
+
+// LockBucket waits until no object of the bucket is being changed and keeps new changes
+// out until the returned function is called. It is meant for removing a bucket.
+func LockBucket(dirPath, bucketName string) func() {
+	return bucketLocks.acquire(dirPath+bucketName, false)
+}
+
+// LockObject serializes the changes to an object key, such as uploads, copies, deletes and
+// tag updates, and returns the function releasing the lock. Changes to different keys of
+// the same bucket proceed in parallel; their metadata updates are serialized per file.
+//
+// Object locks are taken before any file lock, and a request never takes a second object
+// lock while holding one, except through LockObjects.
+func LockObject(dirPath, bucketName, objectKey string) func() {
+	return LockObjects(dirPath, bucketName, []string{objectKey})
+}
+
+// LockObjects locks several keys of a bucket for a batch change. The keys are locked in
+// sorted order so that two batches sharing keys cannot deadlock.
+func LockObjects(dirPath, bucketName string, objectKeys []string) func() {
+	keys := append([]string{}, objectKeys...)
+	sort.Strings(keys)
+
+	unlockBucket := bucketLocks.acquire(dirPath+bucketName, true)
+	unlocks := make([]func(), 0, len(keys))
+	for i, key := range keys {
+		if i > 0 && key == keys[i-1] {
+			continue
+		}
+		unlocks = append(unlocks, objectLocks.acquire(dirPath+bucketName+"/"+key, false))
+	}
+
+	return func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+		unlockBucket()
+	}
+}
+
+// LockUpload keeps a multipart upload from being completed or aborted while a part is
+// committed to it, and the other way around. It is taken before the object lock of the key
+// being completed and before any file lock.
+func LockUpload(dirPath, bucketName, uploadID string) func() {
+	return uploadLocks.acquire(dirPath+bucketName+"/"+uploadID, false)
+}
+
+// LockMetadataFile serializes a read-modify-write cycle of a metadata file such as
+// buckets.csv or objects.csv. It is held for the cycle only and never while taking an
+// object or bucket lock. Deleting a user is the one case holding two file locks, always
+// users.csv before credentials.csv.
+func LockMetadataFile(path string) func() {
+	return fileLocks.acquire(path, false)
+}
+
+// lockBucketDirs guards the directories of the hierarchical keys of a bucket. It is taken
+// last, after any object or file lock, and held for a few file system calls only.
+func lockBucketDirs(dirPath, bucketName string, shared bool) func() {
+	return dirLocks.acquire(dirPath+bucketName, shared)
+}
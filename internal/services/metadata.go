@@ -0,0 +1,93 @@
+package services
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// UserMetadataPrefix starts the name of every user-defined metadata header
+const UserMetadataPrefix = "x-amz-meta-"
+
+// storedHeaders are the representation headers kept with an object and returned on GET and HEAD
+var storedHeaders = []string{"Cache-Control", "Content-Disposition", "Content-Encoding", "Content-Language", "Expires"}
+
+// ExtractObjectMetadata collects the x-amz-meta-* and stored representation headers of a request,
+// keyed by lower-case header name
+func ExtractObjectMetadata(header http.Header) map[string]string {
+	metadata := make(map[string]string)
+	for name, values := range header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, UserMetadataPrefix) && len(values) > 0 {
+			metadata[name] = strings.Join(values, ",")
+		}
+	}
+	for _, name := range storedHeaders {
+		if value := header.Get(name); value != "" {
+			metadata[strings.ToLower(name)] = value
+		}
+	}
+	return metadata
+}
+
+// UserMetadataSize returns the size that counts against the user-defined metadata limit:
+// the bytes of each name after the x-amz-meta- prefix plus the bytes of its value
+func UserMetadataSize(metadata map[string]string) int {
+	size := 0
+	for name, value := range metadata {
+		if suffix, ok := strings.CutPrefix(name, UserMetadataPrefix); ok {
+			size += len(suffix) + len(value)
+		}
+	}
+	return size
+}
+
+// encodeMap converts metadata or tags into the base64 form stored in a CSV field
+func encodeMap(m map[string]string) string {
+	if len(m) == 0 {
+		return ""
+	}
+	values := url.Values{}
+	for name, value := range m {
+		values.Set(name, value)
+	}
+	return base64.StdEncoding.EncodeToString([]byte(values.Encode()))
+}
+
+// decodeMap converts a CSV field written by encodeMap back into a map
+func decodeMap(field string) map[string]string {
+	if field == "" {
+		return nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(field)
+	if err != nil {
+		return nil
+	}
+	values, err := url.ParseQuery(string(decoded))
+	if err != nil {
+		return nil
+	}
+	m := make(map[string]string, len(values))
+	for name := range values {
+		m[name] = values.Get(name)
+	}
+	return m
+}
+
+// ParseTaggingHeader parses the URL query encoded tags of an x-amz-tagging header
+func ParseTaggingHeader(header string) (map[string]string, error) {
+	values, err := url.ParseQuery(header)
+	if err != nil {
+		return nil, err
+	}
+	tags := make(map[string]string, len(values))
+	for key, value := range values {
+		if len(value) != 1 {
+			return nil, errors.New("duplicate tag key " + key)
+		}
+		tags[key] = value[0]
+	}
+	return tags, nil
+}
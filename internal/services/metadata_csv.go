@@ -0,0 +1,230 @@
+package services
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+
+	"triple-s/internal/models"
+)
+
+// csvStore keeps metadata in buckets.csv and in the objects.csv of every bucket. Every
+// change rewrites the whole file under its file lock.
+type csvStore struct {
+	dirPath string
+}
+
+// bucketsPath is the path of buckets.csv
+func (store csvStore) bucketsPath() string {
+	return store.dirPath + "buckets.csv"
+}
+
+// objectsPath is the path of the objects.csv of a bucket
+func (store csvStore) objectsPath(bucketName string) string {
+	return store.dirPath + bucketName + "/objects.csv"
+}
+
+// readBuckets decodes every record of buckets.csv, skipping malformed rows
+func (store csvStore) readBuckets() ([]models.Bucket, error) {
+	records, err := readCSVFile(store.bucketsPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	buckets := make([]models.Bucket, 0, len(records))
+	for _, record := range records {
+		if len(record) < 4 {
+			continue
+		}
+		localBucket, err := decodeBucketRecord(record)
+		if err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, localBucket)
+	}
+	return buckets, nil
+}
+
+// writeBuckets replaces the content of buckets.csv
+func (store csvStore) writeBuckets(buckets []models.Bucket) error {
+	records := make([][]string, 0, len(buckets))
+	for _, bucket := range buckets {
+		records = append(records, encodeBucketRecord(bucket))
+	}
+	return writeCSVFile(store.bucketsPath(), records)
+}
+
+// syncFiles flushes buckets.csv or the objects.csv of a bucket, along with the directory
+// holding it so that the rename replacing it is durable too
+func (store csvStore) syncFiles(bucketName string) error {
+	if bucketName == "" {
+		return syncPaths(store.bucketsPath(), store.dirPath)
+	}
+	return syncPaths(store.objectsPath(bucketName), store.dirPath+bucketName)
+}
+
+func (store csvStore) ListBuckets() ([]models.Bucket, error) {
+	return store.readBuckets()
+}
+
+func (store csvStore) GetBucket(bucketName string) (models.Bucket, error) {
+	buckets, err := store.readBuckets()
+	if err != nil {
+		return models.Bucket{}, err
+	}
+	index := activeBucket(buckets, bucketName)
+	if index < 0 {
+		return models.Bucket{}, ErrBucketNotFound
+	}
+	return buckets[index], nil
+}
+
+func (store csvStore) CreateBucket(bucket models.Bucket) error {
+	// New buckets start with an empty objects.csv. The bucket may already be gone when the
+	// journal replays its creation, which only leaves the record to write.
+	file, err := os.OpenFile(store.objectsPath(bucket.Name), os.O_CREATE|os.O_WRONLY, 0o644)
+	if err == nil {
+		file.Close()
+	} else if !os.IsNotExist(err) {
+		return errors.New("error creating objects.csv")
+	}
+
+	defer LockMetadataFile(store.bucketsPath())()
+	buckets, err := store.readBuckets()
+	if err != nil {
+		return err
+	}
+	return store.writeBuckets(append(buckets, bucket))
+}
+
+func (store csvStore) UpdateBucket(bucketName string, update func(*models.Bucket)) error {
+	defer LockMetadataFile(store.bucketsPath())()
+	buckets, err := store.readBuckets()
+	if err != nil {
+		return err
+	}
+	index := activeBucket(buckets, bucketName)
+	if index < 0 {
+		return ErrBucketNotFound
+	}
+	update(&buckets[index])
+	return store.writeBuckets(buckets)
+}
+
+func (store csvStore) DeleteBucket(bucketName, modTime string) error {
+	defer LockMetadataFile(store.bucketsPath())()
+	buckets, err := store.readBuckets()
+	if err != nil {
+		return err
+	}
+	markBucketDeleted(buckets, bucketName, modTime)
+	return store.writeBuckets(buckets)
+}
+
+// readObjectRecords reads the raw records of the bucket's objects.csv, skipping malformed rows
+func (store csvStore) readObjectRecords(bucketName string) ([][]string, error) {
+	records, err := readCSVFile(store.objectsPath(bucketName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.New("error reading CSV file: " + err.Error())
+	}
+
+	valid := records[:0]
+	for _, record := range records {
+		if len(record) >= 4 {
+			valid = append(valid, record)
+		}
+	}
+	return valid, nil
+}
+
+func (store csvStore) GetObject(bucketName, objectKey string) (models.Object, error) {
+	records, err := store.readObjectRecords(bucketName)
+	if err != nil {
+		return models.Object{}, err
+	}
+
+	encodedName := base64.StdEncoding.EncodeToString([]byte(objectKey))
+	for _, record := range records {
+		if record[0] == encodedName {
+			return decodeObjectRecord(record)
+		}
+	}
+	return models.Object{}, ErrObjectNotFound
+}
+
+func (store csvStore) PutObject(bucketName string, object models.Object) error {
+	defer LockMetadataFile(store.objectsPath(bucketName))()
+	records, err := store.readObjectRecords(bucketName)
+	if err != nil {
+		return err
+	}
+
+	// Replace the existing record of the key or append a new one
+	encodedName := base64.StdEncoding.EncodeToString([]byte(object.ObjectKey))
+	found := false
+	for i, record := range records {
+		if record[0] == encodedName {
+			records[i] = encodeObjectRecord(object)
+			found = true
+		}
+	}
+	if !found {
+		records = append(records, encodeObjectRecord(object))
+	}
+	return writeCSVFile(store.objectsPath(bucketName), records)
+}
+
+func (store csvStore) DeleteObjects(bucketName string, objectKeys []string) error {
+	defer LockMetadataFile(store.objectsPath(bucketName))()
+	records, err := store.readObjectRecords(bucketName)
+	if err != nil {
+		return err
+	}
+
+	removed := make(map[string]bool, len(objectKeys))
+	for _, key := range objectKeys {
+		removed[base64.StdEncoding.EncodeToString([]byte(key))] = true
+	}
+
+	var updatedRecords [][]string
+	for _, record := range records {
+		if !removed[record[0]] {
+			updatedRecords = append(updatedRecords, record)
+		}
+	}
+	return writeCSVFile(store.objectsPath(bucketName), updatedRecords)
+}
+
+func (store csvStore) ScanObjects(bucketName, prefix, startAfter string, fn func(models.Object) bool) error {
+	records, err := store.readObjectRecords(bucketName)
+	if err != nil {
+		return err
+	}
+
+	objects := make([]models.Object, 0, len(records))
+	for _, record := range records {
+		localObject, err := decodeObjectRecord(record)
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(localObject.ObjectKey, prefix) && localObject.ObjectKey > startAfter {
+			objects = append(objects, localObject)
+		}
+	}
+
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].ObjectKey < objects[j].ObjectKey
+	})
+	for _, object := range objects {
+		if !fn(object) {
+			break
+		}
+	}
+	return nil
+}
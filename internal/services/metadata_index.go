@@ -0,0 +1,521 @@
+package services
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"triple-s/internal/models"
+)
+
+const (
+	// indexMemtableLimit is the number of changes kept in memory before they are written
+	// to a new segment
+	indexMemtableLimit = 4096
+	// indexCacheBlocks is the number of segment blocks cached by a store, about 16 MB
+	indexCacheBlocks = 4096
+)
+
+// indexStore keeps the objects of every bucket in a log-structured merge index under
+// .triple-s/index. Changes collect in an in-memory table that is written out as an immutable
+// segment sorted by key once it is full, or at the next checkpoint; until then the journal
+// holds them. Newer segments are merged into older ones of similar size, so a bucket keeps a
+// number of segments logarithmic in its size, and a lookup reads at most one block of each.
+//
+// Buckets are kept as in the log format. An index is built from the objects.log or
+// objects.csv of a bucket the first time it is used.
+type indexStore struct {
+	*logStore
+	cache *blockCache
+	// memtableLimit is indexMemtableLimit, lowered by tests to exercise flushes
+	memtableLimit int
+
+	// mu guards the indexes map only; each index has its own locks
+	mu      sync.RWMutex
+	indexes map[string]*bucketIndex
+}
+
+// bucketIndex is the index of the objects of a bucket. Segments are written while only
+// flushMu is held, so reads and writes of the bucket, and every other bucket, go on while
+// a flush or merge runs; mu is held exclusively just to swap the new segments in.
+type bucketIndex struct {
+	dir string
+
+	// flushMu serializes the flushes and merges of the index
+	flushMu sync.Mutex
+
+	// mu guards the fields below
+	mu       sync.RWMutex
+	memtable map[string]segmentEntry
+	// flushing is the in-memory table being written to a segment, read after memtable
+	flushing map[string]segmentEntry
+	// segments are ordered from oldest to newest
+	segments []*segment
+	nextSeq  uint64
+	closed   bool
+}
+
+func newIndexStore(dirPath string) *indexStore {
+	return &indexStore{
+		logStore:      newLogStore(dirPath),
+		cache:         newBlockCache(indexCacheBlocks),
+		memtableLimit: indexMemtableLimit,
+		indexes:       make(map[string]*bucketIndex),
+	}
+}
+
+// indexDir is the directory holding the index of a bucket
+func (store *indexStore) indexDir(bucketName string) string {
+	return filepath.Join(store.dirPath+bucketName, InternalDirName, "index")
+}
+
+// loadIndex opens the index of a bucket, building it on first use. A nil index is returned
+// for buckets that do not exist. The store must be locked exclusively.
+func (store *indexStore) loadIndex(bucketName string) (*bucketIndex, error) {
+	if index, ok := store.indexes[bucketName]; ok {
+		return index, nil
+	}
+	if _, err := os.Stat(store.dirPath + bucketName); err != nil {
+		return nil, nil
+	}
+
+	dir := store.indexDir(bucketName)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if err := store.buildIndex(bucketName); err != nil {
+			return nil, err
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.New("error reading index: " + err.Error())
+	}
+	index := &bucketIndex{dir: dir, memtable: make(map[string]segmentEntry), nextSeq: 1}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".segment-") {
+			// Left by a flush or merge interrupted by a crash
+			os.Remove(filepath.Join(dir, entry.Name()))
+			continue
+		}
+		if !strings.HasSuffix(entry.Name(), ".seg") {
+			continue
+		}
+		seg, err := openSegment(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			index.close()
+			return nil, err
+		}
+		index.segments = append(index.segments, seg)
+	}
+
+	// A crash between writing a merged segment and removing its inputs leaves segments
+	// covered by the merged one, which are removed now
+	sort.Slice(index.segments, func(i, j int) bool {
+		a, b := index.segments[i], index.segments[j]
+		return a.maxSeq < b.maxSeq || a.maxSeq == b.maxSeq && a.minSeq < b.minSeq
+	})
+	var live []*segment
+	for i, seg := range index.segments {
+		covered := false
+		for _, other := range index.segments[i+1:] {
+			if other.minSeq <= seg.minSeq && seg.maxSeq <= other.maxSeq {
+				covered = true
+			}
+		}
+		if covered {
+			seg.close()
+			os.Remove(seg.path)
+			continue
+		}
+		live = append(live, seg)
+		index.nextSeq = seg.maxSeq + 1
+	}
+	index.segments = live
+
+	store.indexes[bucketName] = index
+	return index, nil
+}
+
+// buildIndex writes the objects found in the objects.log or objects.csv of a bucket as its
+// first segment. The index is assembled in a temporary directory renamed into place, so an
+// interrupted build starts over.
+func (store *indexStore) buildIndex(bucketName string) error {
+	internalDir := filepath.Join(store.dirPath+bucketName, InternalDirName)
+	if err := os.Mkdir(internalDir, os.ModePerm); err != nil && !os.IsExist(err) {
+		return errors.New("error creating index directory: " + err.Error())
+	}
+	// Remove what an earlier build interrupted by a crash left behind
+	if leftovers, err := filepath.Glob(filepath.Join(internalDir, ".index-*")); err == nil {
+		for _, leftover := range leftovers {
+			os.RemoveAll(leftover)
+		}
+	}
+	tmpDir, err := os.MkdirTemp(internalDir, ".index-")
+	if err != nil {
+		return errors.New("error creating index directory: " + err.Error())
+	}
+	defer os.RemoveAll(tmpDir)
+
+	objects, _, err := readBucketObjects(store.dirPath, bucketName)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(objects))
+	for key := range objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	seg, err := writeSegment(tmpDir, 0, 0, func() (segmentEntry, bool) {
+		if len(keys) == 0 {
+			return segmentEntry{}, false
+		}
+		object := objects[keys[0]]
+		keys = keys[1:]
+		return segmentEntry{key: object.ObjectKey, value: encodeObjectValue(object)}, true
+	})
+	if err != nil {
+		return err
+	}
+	if seg != nil {
+		seg.close()
+	}
+
+	if err := os.Rename(tmpDir, store.indexDir(bucketName)); err != nil {
+		return errors.New("error moving index into place: " + err.Error())
+	}
+	return syncPaths(internalDir)
+}
+
+// encodeObjectValue encodes object metadata as a segment value
+func encodeObjectValue(object models.Object) []byte {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Write(encodeObjectRecord(object))
+	writer.Flush()
+	return buf.Bytes()
+}
+
+// decodeObjectValue decodes a segment value into object metadata
+func decodeObjectValue(value []byte) (models.Object, error) {
+	reader := csv.NewReader(bytes.NewReader(value))
+	reader.FieldsPerRecord = -1
+	record, err := reader.Read()
+	if err != nil || len(record) < 4 {
+		return models.Object{}, errors.New("corrupt index entry")
+	}
+	return decodeObjectRecord(record)
+}
+
+// close releases the segment files of the index
+func (index *bucketIndex) close() {
+	for _, seg := range index.segments {
+		seg.close()
+	}
+}
+
+// get looks a key up in the in-memory tables, then in the segments from newest to oldest.
+// The index must be locked for reading.
+func (index *bucketIndex) get(key string, cache *blockCache) (segmentEntry, bool, error) {
+	if entry, ok := index.memtable[key]; ok {
+		return entry, true, nil
+	}
+	if entry, ok := index.flushing[key]; ok {
+		return entry, true, nil
+	}
+	for i := len(index.segments) - 1; i >= 0; i-- {
+		entry, ok, err := index.segments[i].get(key, cache)
+		if err != nil || ok {
+			return entry, ok, err
+		}
+	}
+	return segmentEntry{}, false, nil
+}
+
+// flush writes the in-memory table as a new segment, then merges segments. Changes made
+// meanwhile go to a fresh in-memory table.
+func (index *bucketIndex) flush(cache *blockCache) error {
+	index.flushMu.Lock()
+	defer index.flushMu.Unlock()
+
+	index.mu.Lock()
+	if index.closed || len(index.memtable) == 0 {
+		index.mu.Unlock()
+		return nil
+	}
+	flushing, seq := index.memtable, index.nextSeq
+	index.flushing = flushing
+	index.memtable = make(map[string]segmentEntry)
+	index.nextSeq++
+	index.mu.Unlock()
+
+	entries := make([]segmentEntry, 0, len(flushing))
+	for _, entry := range flushing {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+	it := &sliceIterator{entries: entries}
+	seg, err := writeSegment(index.dir, seq, seq, mergeEntries([]entryIterator{it}))
+
+	index.mu.Lock()
+	index.flushing = nil
+	if err != nil {
+		// Keep the changes in memory, under the ones made since
+		for key, entry := range flushing {
+			if _, ok := index.memtable[key]; !ok {
+				index.memtable[key] = entry
+			}
+		}
+		index.mu.Unlock()
+		return err
+	}
+	index.segments = append(index.segments, seg)
+	index.mu.Unlock()
+	return index.compact(cache)
+}
+
+// compact merges the newest segment into the one before it as long as that one is not
+// much larger, like carrying in a binary counter. Deletions are dropped once the oldest
+// segment takes part, since nothing older remains for them to hide. The caller holds
+// flushMu, so the segments only change here.
+func (index *bucketIndex) compact(cache *blockCache) error {
+	for {
+		index.mu.RLock()
+		n := len(index.segments)
+		if n < 2 {
+			index.mu.RUnlock()
+			return nil
+		}
+		older, newer := index.segments[n-2], index.segments[n-1]
+		index.mu.RUnlock()
+		if older.entries > 2*newer.entries {
+			return nil
+		}
+
+		iterators := []entryIterator{newer.iterate("", nil), older.iterate("", nil)}
+		next := mergeEntries(iterators)
+		dropDeleted := n == 2
+		merged, err := writeSegment(index.dir, older.minSeq, newer.maxSeq, func() (segmentEntry, bool) {
+			for {
+				entry, ok := next()
+				if !ok || !entry.deleted || !dropDeleted {
+					return entry, ok
+				}
+			}
+		})
+		if err == nil {
+			for _, it := range iterators {
+				if err = it.err(); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			if merged != nil {
+				merged.close()
+				os.Remove(merged.path)
+			}
+			return err
+		}
+
+		// Readers hold the index for reading, so none uses the merged segments once swapped
+		index.mu.Lock()
+		segments := append([]*segment{}, index.segments[:n-2]...)
+		if merged != nil {
+			segments = append(segments, merged)
+		}
+		index.segments = segments
+		index.mu.Unlock()
+		for _, seg := range []*segment{older, newer} {
+			cache.evict(seg)
+			seg.close()
+			os.Remove(seg.path)
+		}
+	}
+}
+
+// index returns the index of a bucket, loading it on first use, or nil when the bucket does
+// not exist. The store is only locked exclusively while an index is loaded.
+func (store *indexStore) index(bucketName string) (*bucketIndex, error) {
+	store.mu.RLock()
+	index, ok := store.indexes[bucketName]
+	store.mu.RUnlock()
+	if ok {
+		return index, nil
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.loadIndex(bucketName)
+}
+
+// readIndex runs read with the index of a bucket, nil when the bucket does not exist,
+// while the index is locked for reading
+func (store *indexStore) readIndex(bucketName string, read func(*bucketIndex) error) error {
+	index, err := store.index(bucketName)
+	if err != nil {
+		return err
+	}
+	if index == nil {
+		return read(nil)
+	}
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+	if index.closed {
+		return read(nil)
+	}
+	return read(index)
+}
+
+// writeIndex applies changes to the in-memory table of a bucket and flushes it once full
+func (store *indexStore) writeIndex(bucketName string, entries []segmentEntry) error {
+	index, err := store.index(bucketName)
+	if err != nil {
+		return err
+	}
+	if index == nil {
+		return ErrBucketNotFound
+	}
+
+	index.mu.Lock()
+	if index.closed {
+		index.mu.Unlock()
+		return ErrBucketNotFound
+	}
+	for _, entry := range entries {
+		index.memtable[entry.key] = entry
+	}
+	full := len(index.memtable) >= store.memtableLimit
+	index.mu.Unlock()
+	if full {
+		return index.flush(store.cache)
+	}
+	return nil
+}
+
+// syncFiles writes the in-memory table of a bucket to a segment, or flushes buckets.log
+func (store *indexStore) syncFiles(bucketName string) error {
+	if bucketName == "" {
+		return store.logStore.syncFiles("")
+	}
+	store.mu.RLock()
+	index, ok := store.indexes[bucketName]
+	store.mu.RUnlock()
+	if ok {
+		return index.flush(store.cache)
+	}
+	return nil
+}
+
+func (store *indexStore) DeleteBucket(bucketName, modTime string) error {
+	if err := store.logStore.DeleteBucket(bucketName, modTime); err != nil {
+		return err
+	}
+	store.mu.Lock()
+	index, ok := store.indexes[bucketName]
+	delete(store.indexes, bucketName)
+	store.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	// Wait for a flush in progress before closing the segments
+	index.flushMu.Lock()
+	defer index.flushMu.Unlock()
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	for _, seg := range index.segments {
+		store.cache.evict(seg)
+	}
+	index.close()
+	index.closed = true
+	return nil
+}
+
+func (store *indexStore) GetObject(bucketName, objectKey string) (models.Object, error) {
+	var object models.Object
+	err := store.readIndex(bucketName, func(index *bucketIndex) error {
+		if index == nil {
+			return ErrObjectNotFound
+		}
+		entry, ok, err := index.get(objectKey, store.cache)
+		if err != nil {
+			return err
+		}
+		if !ok || entry.deleted {
+			return ErrObjectNotFound
+		}
+		object, err = decodeObjectValue(entry.value)
+		return err
+	})
+	return object, err
+}
+
+func (store *indexStore) PutObject(bucketName string, object models.Object) error {
+	return store.writeIndex(bucketName, []segmentEntry{{key: object.ObjectKey, value: encodeObjectValue(object)}})
+}
+
+func (store *indexStore) DeleteObjects(bucketName string, objectKeys []string) error {
+	entries := make([]segmentEntry, 0, len(objectKeys))
+	for _, key := range objectKeys {
+		entries = append(entries, segmentEntry{key: key, deleted: true})
+	}
+	return store.writeIndex(bucketName, entries)
+}
+
+// ScanObjects merges the in-memory table with the segments from the first key with the
+// prefix after startAfter on, so listing a page of a prefix only reads the blocks holding
+// it. fn runs while the index is locked for reading and must not call into the store.
+func (store *indexStore) ScanObjects(bucketName, prefix, startAfter string, fn func(models.Object) bool) error {
+	return store.readIndex(bucketName, func(index *bucketIndex) error {
+		if index == nil {
+			return nil
+		}
+		start := prefix
+		if startAfter > start {
+			start = startAfter
+		}
+
+		var pending []segmentEntry
+		for key, entry := range index.memtable {
+			if strings.HasPrefix(key, prefix) && key > startAfter {
+				pending = append(pending, entry)
+			}
+		}
+		for key, entry := range index.flushing {
+			if _, ok := index.memtable[key]; !ok && strings.HasPrefix(key, prefix) && key > startAfter {
+				pending = append(pending, entry)
+			}
+		}
+		sort.Slice(pending, func(i, j int) bool { return pending[i].key < pending[j].key })
+
+		iterators := []entryIterator{&sliceIterator{entries: pending}}
+		for i := len(index.segments) - 1; i >= 0; i-- {
+			iterators = append(iterators, index.segments[i].iterate(start, store.cache))
+		}
+		next := mergeEntries(iterators)
+		for entry, ok := next(); ok && strings.HasPrefix(entry.key, prefix); entry, ok = next() {
+			if entry.deleted || entry.key <= startAfter {
+				continue
+			}
+			object, err := decodeObjectValue(entry.value)
+			if err != nil {
+				return err
+			}
+			if !fn(object) {
+				return nil
+			}
+		}
+		for _, it := range iterators {
+			if err := it.err(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
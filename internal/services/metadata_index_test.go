@@ -0,0 +1,231 @@
+package services
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"triple-s/internal/models"
+)
+
+// checkIndexStore compares every key and a prefix scan of the store with the expected objects
+func checkIndexStore(t *testing.T, store *indexStore, keys []string, want map[string]int64) {
+	t.Helper()
+	for _, key := range keys {
+		object, err := store.GetObject("bucket", key)
+		size, ok := want[key]
+		if !ok && err != ErrObjectNotFound {
+			t.Fatalf("deleted key %s: %v, %v", key, object, err)
+		}
+		if ok && (err != nil || object.Size != size) {
+			t.Fatalf("key %s: got %v, %v, want size %d", key, object, err, size)
+		}
+	}
+
+	var wantKeys, gotKeys []string
+	for key := range want {
+		if strings.HasPrefix(key, "k/1") {
+			wantKeys = append(wantKeys, key)
+		}
+	}
+	sort.Strings(wantKeys)
+	err := store.ScanObjects("bucket", "k/1", "", func(object models.Object) bool {
+		gotKeys = append(gotKeys, object.ObjectKey)
+		return true
+	})
+	if err != nil || strings.Join(gotKeys, ",") != strings.Join(wantKeys, ",") {
+		t.Fatalf("scan: got %v, %v, want %v", gotKeys, err, wantKeys)
+	}
+
+	// A page starts after its marker and ends where fn stops the scan
+	var wantPage, gotPage []string
+	for _, key := range wantKeys {
+		if key > "k/150" && len(wantPage) < 5 {
+			wantPage = append(wantPage, key)
+		}
+	}
+	err = store.ScanObjects("bucket", "k/1", "k/150", func(object models.Object) bool {
+		gotPage = append(gotPage, object.ObjectKey)
+		return len(gotPage) < 5
+	})
+	if err != nil || strings.Join(gotPage, ",") != strings.Join(wantPage, ",") {
+		t.Fatalf("page after k/150: got %v, %v, want %v", gotPage, err, wantPage)
+	}
+}
+
+func TestIndexStoreMatchesModel(t *testing.T) {
+	dir := t.TempDir() + "/"
+	if err := os.Mkdir(dir+"bucket", os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	store := newIndexStore(dir)
+	store.memtableLimit = 8
+
+	keys := make([]string, 300)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("k/%03d", i)
+	}
+	want := make(map[string]int64)
+	random := rand.New(rand.NewSource(1))
+	for op := 1; op <= 3000; op++ {
+		key := keys[random.Intn(len(keys))]
+		if random.Intn(4) == 0 {
+			if err := store.DeleteObjects("bucket", []string{key}); err != nil {
+				t.Fatal(err)
+			}
+			delete(want, key)
+		} else {
+			if err := store.PutObject("bucket", models.Object{ObjectKey: key, Size: int64(op)}); err != nil {
+				t.Fatal(err)
+			}
+			want[key] = int64(op)
+		}
+		if op%500 == 0 {
+			checkIndexStore(t, store, keys, want)
+		}
+	}
+
+	// Merging keeps the number of segments logarithmic in the number of flushes
+	if segments := len(store.indexes["bucket"].segments); segments > 10 {
+		t.Errorf("%d segments after 3000 changes", segments)
+	}
+
+	if err := store.syncFiles("bucket"); err != nil {
+		t.Fatal(err)
+	}
+	checkIndexStore(t, newIndexStore(dir), keys, want)
+}
+
+func TestIndexStoreConcurrentFlushes(t *testing.T) {
+	dir := t.TempDir() + "/"
+	for _, bucketName := range []string{"busy", "other"} {
+		if err := os.Mkdir(dir+bucketName, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store := newIndexStore(dir)
+	store.memtableLimit = 8
+	if err := store.PutObject("other", models.Object{ObjectKey: "key", Size: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Writes flush and merge segments while other writers and readers go on; a change must
+	// stay visible while its in-memory table is written out
+	var wg sync.WaitGroup
+	for worker := 0; worker < 4; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				key := fmt.Sprintf("w%d/%03d", worker, i)
+				if err := store.PutObject("busy", models.Object{ObjectKey: key, Size: int64(i)}); err != nil {
+					t.Error(err)
+					return
+				}
+				if object, err := store.GetObject("busy", key); err != nil || object.Size != int64(i) {
+					t.Errorf("%s after writing it: %v, %v", key, object, err)
+				}
+				if _, err := store.GetObject("other", "key"); err != nil {
+					t.Errorf("object of another bucket: %v", err)
+				}
+			}
+		}(worker)
+	}
+	wg.Wait()
+
+	count := 0
+	err := store.ScanObjects("busy", "", "", func(models.Object) bool {
+		count++
+		return true
+	})
+	if err != nil || count != 800 {
+		t.Errorf("%d objects listed, want 800: %v", count, err)
+	}
+}
+
+// benchmarkKey names the objects of the benchmark buckets, ten objects per prefix
+func benchmarkKey(i int) string {
+	return fmt.Sprintf("group-%06d/object-%02d.jpg", i/10, i%10)
+}
+
+// benchmarkStore opens a store of the given format on a bucket holding size objects
+func benchmarkStore(b *testing.B, format string, size int) MetadataStore {
+	b.Helper()
+	dir := b.TempDir() + "/"
+	if err := os.Mkdir(dir+"bench", os.ModePerm); err != nil {
+		b.Fatal(err)
+	}
+	records := make([][]string, size)
+	for i := range records {
+		records[i] = encodeObjectRecord(models.Object{
+			ObjectKey:        benchmarkKey(i),
+			Size:             int64(i),
+			ContentType:      "image/jpeg",
+			LastModifiedTime: "2024-01-01T00:00:00Z",
+			ETag:             "d41d8cd98f00b204e9800998ecf8427e",
+		})
+	}
+	if err := writeCSVFile(dir+"bench/objects.csv", records); err != nil {
+		b.Fatal(err)
+	}
+
+	var store MetadataStore = csvStore{dirPath: dir}
+	switch format {
+	case MetadataLog:
+		store = newLogStore(dir)
+	case MetadataIndex:
+		store = newIndexStore(dir)
+	}
+	// Replaying the log or building the index happens once and is not measured
+	if _, err := store.GetObject("bench", benchmarkKey(0)); err != nil {
+		b.Fatal(err)
+	}
+	return store
+}
+
+// benchmarkSizes runs a benchmark for every format and bucket size. The CSV format reads
+// the whole objects.csv on every request, so it is left out of the largest size.
+func benchmarkSizes(b *testing.B, run func(b *testing.B, store MetadataStore, size int)) {
+	for _, format := range []string{MetadataCSV, MetadataLog, MetadataIndex} {
+		for _, size := range []int{1000, 10000, 100000, 1000000} {
+			if format == MetadataCSV && size > 100000 {
+				continue
+			}
+			b.Run(fmt.Sprintf("%s/%d", format, size), func(b *testing.B) {
+				store := benchmarkStore(b, format, size)
+				b.ResetTimer()
+				run(b, store, size)
+			})
+		}
+	}
+}
+
+func BenchmarkGetObject(b *testing.B) {
+	benchmarkSizes(b, func(b *testing.B, store MetadataStore, size int) {
+		for i := 0; i < b.N; i++ {
+			if _, err := store.GetObject("bench", benchmarkKey(i*7919%size)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkScanPrefix(b *testing.B) {
+	benchmarkSizes(b, func(b *testing.B, store MetadataStore, size int) {
+		for i := 0; i < b.N; i++ {
+			prefix := benchmarkKey(i * 7919 % size)[:len("group-000000/")]
+			found := 0
+			err := store.ScanObjects("bench", prefix, "", func(models.Object) bool {
+				found++
+				return true
+			})
+			if err != nil || found != 10 {
+				b.Fatalf("scan of %s: %d objects, %v", prefix, found, err)
+			}
+		}
+	})
+}
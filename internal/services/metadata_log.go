@@ -0,0 +1,465 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"triple-s/internal/models"
+)
+
+// Operations recorded in the metadata logs. Each log line is a CSV record made of the
+// operation followed by its fields, which reuse the buckets.csv and objects.csv encodings.
+const (
+	opCreateBucket = "create"
+	opUpdateBucket = "update"
+	opDeleteBucket = "delete"
+	opPutObject    = "put"
+	opDeleteObject = "remove"
+)
+
+// compactionSlack is the number of superseded log lines tolerated before a log is rewritten
+// as a snapshot of its live records
+const compactionSlack = 1024
+
+// logStore keeps metadata in append-only logs replayed into memory when first used. Changes
+// append a single line instead of rewriting a file, and reads never touch the disk.
+//
+// A data directory without logs starts from its CSV metadata, so switching an existing
+// directory to the log format keeps its buckets and objects. The CSV files are left as they
+// were and do not follow later changes.
+type logStore struct {
+	dirPath string
+
+	mu      sync.Mutex
+	buckets []models.Bucket
+	// bucketLog is nil until buckets.log has been replayed
+	bucketLog *opLog
+	objects   map[string]*objectIndex
+}
+
+// objectIndex is the replayed state of the objects.log of a bucket
+type objectIndex struct {
+	log     *opLog
+	objects map[string]models.Object
+}
+
+func newLogStore(dirPath string) *logStore {
+	return &logStore{dirPath: dirPath, objects: make(map[string]*objectIndex)}
+}
+
+// opLog is an append-only file of operations
+type opLog struct {
+	path string
+	// lines counts the operations in the file, live or superseded
+	lines int
+}
+
+// append writes operations at the end of the log with a single write
+func (l *opLog) append(records ...[]string) error {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	if err := writer.WriteAll(records); err != nil {
+		return errors.New("error encoding log record: " + err.Error())
+	}
+
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return errors.New("error opening metadata log: " + err.Error())
+	}
+	defer file.Close()
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		return errors.New("error writing metadata log: " + err.Error())
+	}
+	l.lines += len(records)
+	return nil
+}
+
+// compact rewrites the log as the given snapshot once enough of it is superseded
+func (l *opLog) compact(live int, snapshot func() [][]string) error {
+	if l.lines <= 2*live+compactionSlack {
+		return nil
+	}
+	records := snapshot()
+	if err := writeCSVFile(l.path, records); err != nil {
+		return err
+	}
+	l.lines = len(records)
+	return nil
+}
+
+// readLogFile reads the operations of a log. A crash may interrupt an append and leave an
+// incomplete last line, which is cut off; the journal still holds the lost operation.
+func readLogFile(path string) ([][]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.New("error replaying metadata log: " + err.Error())
+	}
+	if complete := bytes.LastIndexByte(data, '\n') + 1; complete < len(data) {
+		data = data[:complete]
+		if err := os.Truncate(path, int64(complete)); err != nil {
+			return nil, errors.New("error repairing metadata log: " + err.Error())
+		}
+	}
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, errors.New("error replaying metadata log: " + err.Error())
+	}
+	return records, nil
+}
+
+// loadBuckets replays buckets.log, importing buckets.csv when there is no log yet.
+// The store mutex must be held.
+func (store *logStore) loadBuckets() error {
+	if store.bucketLog != nil {
+		return nil
+	}
+	bucketLog := &opLog{path: store.dirPath + "buckets.log"}
+
+	if _, err := os.Stat(bucketLog.path); os.IsNotExist(err) {
+		buckets, err := csvStore{dirPath: store.dirPath}.readBuckets()
+		if err != nil {
+			return err
+		}
+		if len(buckets) > 0 {
+			if err := os.MkdirAll(store.dirPath, os.ModePerm); err != nil {
+				return errors.New("error creating data directory: " + err.Error())
+			}
+			if err := writeCSVFile(bucketLog.path, bucketSnapshot(buckets)); err != nil {
+				return err
+			}
+		}
+		store.buckets = buckets
+		bucketLog.lines = len(buckets)
+		store.bucketLog = bucketLog
+		return nil
+	}
+
+	records, err := readLogFile(bucketLog.path)
+	if err != nil {
+		return err
+	}
+	var buckets []models.Bucket
+	for _, record := range records {
+		if len(record) < 2 {
+			continue
+		}
+		switch record[0] {
+		case opCreateBucket, opUpdateBucket:
+			if len(record) < 5 {
+				continue
+			}
+			bucket, err := decodeBucketRecord(record[1:])
+			if err != nil {
+				return err
+			}
+			if record[0] == opCreateBucket {
+				buckets = append(buckets, bucket)
+			} else if index := activeBucket(buckets, bucket.Name); index >= 0 {
+				buckets[index] = bucket
+			}
+		case opDeleteBucket:
+			if len(record) < 3 {
+				continue
+			}
+			name, _ := base64.StdEncoding.DecodeString(record[1])
+			modTime, _ := base64.StdEncoding.DecodeString(record[2])
+			markBucketDeleted(buckets, string(name), string(modTime))
+		}
+	}
+	store.buckets = buckets
+	bucketLog.lines = len(records)
+	store.bucketLog = bucketLog
+	return nil
+}
+
+// bucketSnapshot lists bucket records as the log operations recreating them
+func bucketSnapshot(buckets []models.Bucket) [][]string {
+	records := make([][]string, 0, len(buckets))
+	for _, bucket := range buckets {
+		records = append(records, append([]string{opCreateBucket}, encodeBucketRecord(bucket)...))
+	}
+	return records
+}
+
+// syncFiles flushes buckets.log or the objects.log of a bucket along with their directories
+func (store *logStore) syncFiles(bucketName string) error {
+	if bucketName == "" {
+		return syncPaths(store.dirPath+"buckets.log", store.dirPath)
+	}
+	internalDir := filepath.Join(store.dirPath+bucketName, InternalDirName)
+	return syncPaths(objectLogPath(store.dirPath, bucketName), internalDir, store.dirPath+bucketName)
+}
+
+func (store *logStore) ListBuckets() ([]models.Bucket, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if err := store.loadBuckets(); err != nil {
+		return nil, err
+	}
+	return append([]models.Bucket{}, store.buckets...), nil
+}
+
+func (store *logStore) GetBucket(bucketName string) (models.Bucket, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if err := store.loadBuckets(); err != nil {
+		return models.Bucket{}, err
+	}
+	index := activeBucket(store.buckets, bucketName)
+	if index < 0 {
+		return models.Bucket{}, ErrBucketNotFound
+	}
+	return store.buckets[index], nil
+}
+
+func (store *logStore) CreateBucket(bucket models.Bucket) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if err := store.loadBuckets(); err != nil {
+		return err
+	}
+	if err := store.bucketLog.append(append([]string{opCreateBucket}, encodeBucketRecord(bucket)...)); err != nil {
+		return err
+	}
+	store.buckets = append(store.buckets, bucket)
+	return nil
+}
+
+func (store *logStore) UpdateBucket(bucketName string, update func(*models.Bucket)) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if err := store.loadBuckets(); err != nil {
+		return err
+	}
+	index := activeBucket(store.buckets, bucketName)
+	if index < 0 {
+		return ErrBucketNotFound
+	}
+
+	bucket := store.buckets[index]
+	update(&bucket)
+	if err := store.bucketLog.append(append([]string{opUpdateBucket}, encodeBucketRecord(bucket)...)); err != nil {
+		return err
+	}
+	store.buckets[index] = bucket
+	return store.bucketLog.compact(len(store.buckets), func() [][]string {
+		return bucketSnapshot(store.buckets)
+	})
+}
+
+func (store *logStore) DeleteBucket(bucketName, modTime string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if err := store.loadBuckets(); err != nil {
+		return err
+	}
+	err := store.bucketLog.append([]string{
+		opDeleteBucket,
+		base64.StdEncoding.EncodeToString([]byte(bucketName)),
+		base64.StdEncoding.EncodeToString([]byte(modTime)),
+	})
+	if err != nil {
+		return err
+	}
+	markBucketDeleted(store.buckets, bucketName, modTime)
+	delete(store.objects, bucketName)
+	return nil
+}
+
+// loadObjects returns the replayed objects.log of a bucket, importing its objects.csv when
+// there is no log yet. The store mutex must be held.
+func (store *logStore) loadObjects(bucketName string) (*objectIndex, error) {
+	if index, ok := store.objects[bucketName]; ok {
+		return index, nil
+	}
+	index := &objectIndex{
+		log:     &opLog{path: objectLogPath(store.dirPath, bucketName)},
+		objects: make(map[string]models.Object),
+	}
+
+	// Buckets that do not exist are not remembered, so that requests naming them cost no memory
+	if _, err := os.Stat(store.dirPath + bucketName); err != nil {
+		return index, nil
+	}
+
+	objects, lines, err := readBucketObjects(store.dirPath, bucketName)
+	if err != nil {
+		return nil, err
+	}
+	index.objects = objects
+	index.log.lines = lines
+	if lines < 0 {
+		// Imported from objects.csv, which the log now replaces
+		index.log.lines = len(objects)
+		if len(objects) > 0 {
+			if err := index.ensureDir(); err != nil {
+				return nil, err
+			}
+			if err := writeCSVFile(index.log.path, index.snapshot()); err != nil {
+				return nil, err
+			}
+		}
+	}
+	store.objects[bucketName] = index
+	return index, nil
+}
+
+// objectLogPath is the path of the objects.log of a bucket
+func objectLogPath(dirPath, bucketName string) string {
+	return filepath.Join(dirPath+bucketName, InternalDirName, "objects.log")
+}
+
+// readBucketObjects reads the objects of a bucket from its objects.log, returning the number
+// of lines of the log, or from its objects.csv when there is no log, returning -1 lines
+func readBucketObjects(dirPath, bucketName string) (map[string]models.Object, int, error) {
+	objects := make(map[string]models.Object)
+	path := objectLogPath(dirPath, bucketName)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		records, err := csvStore{dirPath: dirPath}.readObjectRecords(bucketName)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, record := range records {
+			object, err := decodeObjectRecord(record)
+			if err != nil {
+				return nil, 0, err
+			}
+			objects[object.ObjectKey] = object
+		}
+		return objects, -1, nil
+	}
+
+	records, err := readLogFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, record := range records {
+		switch {
+		case record[0] == opPutObject && len(record) >= 5:
+			object, err := decodeObjectRecord(record[1:])
+			if err != nil {
+				return nil, 0, err
+			}
+			objects[object.ObjectKey] = object
+		case record[0] == opDeleteObject:
+			for _, field := range record[1:] {
+				key, _ := base64.StdEncoding.DecodeString(field)
+				delete(objects, string(key))
+			}
+		}
+	}
+	return objects, len(records), nil
+}
+
+// ensureDir creates the internal directory holding the log. The bucket directory itself is
+// never created, so a bucket removed concurrently is not brought back.
+func (index *objectIndex) ensureDir() error {
+	err := os.Mkdir(filepath.Dir(index.log.path), os.ModePerm)
+	if err != nil && !os.IsExist(err) {
+		return errors.New("error creating metadata directory: " + err.Error())
+	}
+	return nil
+}
+
+// snapshot lists the live objects as the log operations recreating them
+func (index *objectIndex) snapshot() [][]string {
+	records := make([][]string, 0, len(index.objects))
+	for _, object := range index.objects {
+		records = append(records, append([]string{opPutObject}, encodeObjectRecord(object)...))
+	}
+	return records
+}
+
+func (store *logStore) GetObject(bucketName, objectKey string) (models.Object, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	index, err := store.loadObjects(bucketName)
+	if err != nil {
+		return models.Object{}, err
+	}
+	object, ok := index.objects[objectKey]
+	if !ok {
+		return models.Object{}, ErrObjectNotFound
+	}
+	return object, nil
+}
+
+func (store *logStore) PutObject(bucketName string, object models.Object) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	index, err := store.loadObjects(bucketName)
+	if err != nil {
+		return err
+	}
+	if err := index.ensureDir(); err != nil {
+		return err
+	}
+	if err := index.log.append(append([]string{opPutObject}, encodeObjectRecord(object)...)); err != nil {
+		return err
+	}
+	index.objects[object.ObjectKey] = object
+	return index.log.compact(len(index.objects), index.snapshot)
+}
+
+func (store *logStore) DeleteObjects(bucketName string, objectKeys []string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	index, err := store.loadObjects(bucketName)
+	if err != nil {
+		return err
+	}
+
+	record := []string{opDeleteObject}
+	for _, key := range objectKeys {
+		if _, ok := index.objects[key]; ok {
+			record = append(record, base64.StdEncoding.EncodeToString([]byte(key)))
+		}
+	}
+	if len(record) == 1 {
+		return nil
+	}
+	if err := index.log.append(record); err != nil {
+		return err
+	}
+	for _, key := range objectKeys {
+		delete(index.objects, key)
+	}
+	return index.log.compact(len(index.objects), index.snapshot)
+}
+
+func (store *logStore) ScanObjects(bucketName, prefix, startAfter string, fn func(models.Object) bool) error {
+	// Matching objects are copied out so that fn runs without holding the store
+	store.mu.Lock()
+	index, err := store.loadObjects(bucketName)
+	if err != nil {
+		store.mu.Unlock()
+		return err
+	}
+	var objects []models.Object
+	for key, object := range index.objects {
+		if strings.HasPrefix(key, prefix) && key > startAfter {
+			objects = append(objects, object)
+		}
+	}
+	store.mu.Unlock()
+
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].ObjectKey < objects[j].ObjectKey
+	})
+	for _, object := range objects {
+		if !fn(object) {
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"triple-s/internal/models"
+)
+
+// MetadataStore keeps the metadata of the buckets of a data directory and of their objects.
+// Implementations are safe for concurrent use; read-modify-write cycles spanning several
+// calls, such as changing the tags of an object, are serialized by the object locks.
+type MetadataStore interface {
+	// ListBuckets returns every bucket record in creation order, including deleted buckets
+	ListBuckets() ([]models.Bucket, error)
+	// GetBucket returns the active record of a bucket, or ErrBucketNotFound
+	GetBucket(bucketName string) (models.Bucket, error)
+	// CreateBucket adds the record of a new bucket
+	CreateBucket(bucket models.Bucket) error
+	// UpdateBucket applies a change to the active record of a bucket atomically
+	UpdateBucket(bucketName string, update func(*models.Bucket)) error
+	// DeleteBucket marks the records of a bucket as deleted and forgets its objects
+	DeleteBucket(bucketName, modTime string) error
+
+	// GetObject returns the metadata of an object, or ErrObjectNotFound
+	GetObject(bucketName, objectKey string) (models.Object, error)
+	// PutObject inserts or replaces the metadata of an object
+	PutObject(bucketName string, object models.Object) error
+	// DeleteObjects removes the metadata of the given keys, ignoring unknown ones
+	DeleteObjects(bucketName string, objectKeys []string) error
+	// ScanObjects calls fn with the objects whose key starts with prefix and sorts after
+	// startAfter in key order, stopping early when fn returns false
+	ScanObjects(bucketName, prefix, startAfter string, fn func(models.Object) bool) error
+}
+
+// Metadata formats selectable with SetMetadataFormat
+const (
+	// MetadataCSV keeps buckets.csv in the data directory and objects.csv in every bucket
+	MetadataCSV = "csv"
+	// MetadataLog appends every change to buckets.log and to .triple-s/objects.log of every
+	// bucket, and serves reads from memory
+	MetadataLog = "log"
+	// MetadataIndex keeps buckets like MetadataLog and the objects of every bucket in a
+	// sorted index under .triple-s/index
+	MetadataIndex = "index"
+)
+
+var (
+	metadataMu     sync.Mutex
+	metadataFormat = MetadataCSV
+	metadataStores = make(map[string]*journaledStore)
+)
+
+// SetMetadataFormat selects the implementation of the stores opened afterwards
+func SetMetadataFormat(format string) error {
+	if format != MetadataCSV && format != MetadataLog && format != MetadataIndex {
+		return errors.New("unknown metadata format " + format)
+	}
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	metadataFormat = format
+	return nil
+}
+
+// Metadata returns the metadata store of a data directory, opening it on first use.
+// Every caller shares the same store, so the log format keeps a single in-memory state.
+// Changes go through the write-ahead journal of the data directory.
+func Metadata(dirPath string) MetadataStore {
+	return journaledMetadata(dirPath)
+}
+
+// journaledMetadata returns the journaled store of a data directory
+func journaledMetadata(dirPath string) *journaledStore {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	store, ok := metadataStores[dirPath]
+	if !ok {
+		var inner MetadataStore
+		switch metadataFormat {
+		case MetadataLog:
+			inner = newLogStore(dirPath)
+		case MetadataIndex:
+			inner = newIndexStore(dirPath)
+		default:
+			inner = csvStore{dirPath: dirPath}
+		}
+		store = newJournaledStore(dirPath, inner)
+		metadataStores[dirPath] = store
+	}
+	return store
+}
+
+// OpenMetadata opens the metadata store of a data directory, replaying the changes left in
+// its journal by a crash. It is meant to run before the server accepts requests.
+func OpenMetadata(dirPath string) error {
+	return journaledMetadata(dirPath).open()
+}
+
+// CheckpointMetadata syncs the metadata of a data directory to disk and empties its journal
+func CheckpointMetadata(dirPath string) error {
+	return journaledMetadata(dirPath).Checkpoint()
+}
+
+// reservedNames are the files of a data directory holding server state
+var reservedNames = map[string]bool{
+	"buckets.csv":       true,
+	"buckets.log":       true,
+	CredentialsFileName: true,
+	UsersFileName:       true,
+}
+
+// ReservedBucketName reports whether a name is taken by a file of the data directory holding
+// server state, so that no bucket request can reach it
+func ReservedBucketName(name string) bool {
+	return reservedNames[name] || strings.HasPrefix(name, ".")
+}
+
+// activeBucket returns the index of the last active record of a bucket, or -1. A bucket may
+// have been deleted and recreated, so the last active record wins.
+func activeBucket(buckets []models.Bucket, bucketName string) int {
+	index := -1
+	for i, bucket := range buckets {
+		if bucket.Name == bucketName && bucket.Status == "true" {
+			index = i
+		}
+	}
+	return index
+}
+
+// markBucketDeleted marks every record of a bucket as inactive
+func markBucketDeleted(buckets []models.Bucket, bucketName, modTime string) {
+	for i := range buckets {
+		if buckets[i].Name == bucketName {
+			buckets[i].Status = "false"
+			buckets[i].LastModifiedTime = modTime
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"triple-s/internal/models"
+)
+
+// InternalDirName is the directory inside each bucket holding server state such as
+// staged multipart uploads. Object keys may not start with it.
+const InternalDirName = ".triple-s"
+
+// ErrUploadNotFound is returned when a multipart upload does not exist
+var ErrUploadNotFound = errors.New("upload not found")
+
+// uploadDir returns the staging directory of a multipart upload
+func uploadDir(dirPath, bucketName, uploadID string) string {
+	return filepath.Join(dirPath+bucketName, InternalDirName, "multipart", uploadID)
+}
+
+// validUploadID reports whether an upload ID has the form generated by CreateMultipartUpload,
+// which keeps client supplied IDs from escaping the staging directory
+func validUploadID(uploadID string) bool {
+	if len(uploadID) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(uploadID)
+	return err == nil
+}
+
+// CreateMultipartUpload stages a new multipart upload for the object and returns its upload ID.
+// The content type, metadata, tags and canned ACL are applied to the object once the upload completes.
+func CreateMultipartUpload(dirPath, bucketName, objectKey, contentType string, metadata, tags map[string]string, acl string) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", errors.New("error generating upload ID: " + err.Error())
+	}
+	uploadID := hex.EncodeToString(id)
+
+	dir := uploadDir(dirPath, bucketName, uploadID)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", errors.New("error creating upload directory: " + err.Error())
+	}
+
+	record := []string{
+		base64.StdEncoding.EncodeToString([]byte(objectKey)),
+		base64.StdEncoding.EncodeToString([]byte(time.Now().Format(time.RFC3339))),
+		contentType,
+		encodeMap(metadata),
+		encodeMap(tags),
+		acl,
+	}
+	if err := writeCSVFile(filepath.Join(dir, "upload.csv"), [][]string{record}); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	if err := writeCSVFile(filepath.Join(dir, "parts.csv"), nil); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+
+	return uploadID, nil
+}
+
+// ReadMultipartUpload returns the description of a staged multipart upload
+func ReadMultipartUpload(dirPath, bucketName, uploadID string) (models.MultipartUpload, error) {
+	if !validUploadID(uploadID) {
+		return models.MultipartUpload{}, ErrUploadNotFound
+	}
+
+	records, err := readCSVFile(filepath.Join(uploadDir(dirPath, bucketName, uploadID), "upload.csv"))
+	if os.IsNotExist(err) {
+		return models.MultipartUpload{}, ErrUploadNotFound
+	} else if err != nil {
+		return models.MultipartUpload{}, err
+	}
+	if len(records) != 1 || len(records[0]) < 3 {
+		return models.MultipartUpload{}, errors.New("malformed upload record")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(records[0][0])
+	if err != nil {
+		return models.MultipartUpload{}, errors.New("error decoding object key: " + err.Error())
+	}
+	initiated, err := base64.StdEncoding.DecodeString(records[0][1])
+	if err != nil {
+		return models.MultipartUpload{}, errors.New("error decoding initiation time: " + err.Error())
+	}
+
+	upload := models.MultipartUpload{
+		Key:          string(key),
+		UploadID:     uploadID,
+		Initiated:    string(initiated),
+		StorageClass: "STANDARD",
+		ContentType:  records[0][2],
+	}
+	if len(records[0]) > 3 {
+		upload.Metadata = decodeMap(records[0][3])
+	}
+	if len(records[0]) > 4 {
+		upload.Tags = decodeMap(records[0][4])
+	}
+	if len(records[0]) > 5 {
+		upload.ACL = records[0][5]
+	}
+	return upload, nil
+}
+
+// ListMultipartUploads returns the in-progress uploads of a bucket sorted by key and upload ID
+func ListMultipartUploads(dirPath, bucketName string) ([]models.MultipartUpload, error) {
+	entries, err := os.ReadDir(filepath.Join(dirPath+bucketName, InternalDirName, "multipart"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.New("error reading uploads: " + err.Error())
+	}
+
+	var uploads []models.MultipartUpload
+	for _, entry := range entries {
+		upload, err := ReadMultipartUpload(dirPath, bucketName, entry.Name())
+		if err != nil {
+			continue
+		}
+		uploads = append(uploads, upload)
+	}
+
+	sort.Slice(uploads, func(i, j int) bool {
+		if uploads[i].Key != uploads[j].Key {
+			return uploads[i].Key < uploads[j].Key
+		}
+		return uploads[i].UploadID < uploads[j].UploadID
+	})
+	return uploads, nil
+}
+
+// PartPath returns the file holding the content of an uploaded part
+func PartPath(dirPath, bucketName, uploadID string, partNumber int) string {
+	return filepath.Join(uploadDir(dirPath, bucketName, uploadID), strconv.Itoa(partNumber))
+}
+
+// WritePartInfo records an uploaded part in the upload's parts.csv, replacing an earlier
+// upload of the same part number
+func WritePartInfo(dirPath, bucketName, uploadID string, part models.Part) error {
+	partsPath := filepath.Join(uploadDir(dirPath, bucketName, uploadID), "parts.csv")
+	defer LockMetadataFile(partsPath)()
+	records, err := readCSVFile(partsPath)
+	if err != nil {
+		return errors.New("error reading parts: " + err.Error())
+	}
+
+	number := strconv.Itoa(part.PartNumber)
+	var updatedRecords [][]string
+	for _, record := range records {
+		if len(record) > 0 && record[0] != number {
+			updatedRecords = append(updatedRecords, record)
+		}
+	}
+	updatedRecords = append(updatedRecords, []string{
+		number,
+		part.ETag,
+		strconv.FormatInt(part.Size, 10),
+		base64.StdEncoding.EncodeToString([]byte(part.LastModified)),
+	})
+
+	return writeCSVFile(partsPath, updatedRecords)
+}
+
+// ListPartInfo returns the uploaded parts of a multipart upload sorted by part number
+func ListPartInfo(dirPath, bucketName, uploadID string) ([]models.Part, error) {
+	records, err := readCSVFile(filepath.Join(uploadDir(dirPath, bucketName, uploadID), "parts.csv"))
+	if err != nil {
+		return nil, errors.New("error reading parts: " + err.Error())
+	}
+
+	var parts []models.Part
+	for _, record := range records {
+		if len(record) < 4 {
+			continue
+		}
+		number, err := strconv.Atoi(record[0])
+		if err != nil {
+			continue
+		}
+		size, _ := strconv.ParseInt(record[2], 10, 64)
+		modTime, _ := base64.StdEncoding.DecodeString(record[3])
+		parts = append(parts, models.Part{
+			PartNumber:   number,
+			ETag:         record[1],
+			Size:         size,
+			LastModified: string(modTime),
+		})
+	}
+
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].PartNumber < parts[j].PartNumber
+	})
+	return parts, nil
+}
+
+// AbortMultipartUpload discards a multipart upload and every part staged for it. The caller
+// holds the lock of the upload.
+func AbortMultipartUpload(dirPath, bucketName, uploadID string) error {
+	if !validUploadID(uploadID) {
+		return ErrUploadNotFound
+	}
+	dir := uploadDir(dirPath, bucketName, uploadID)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return ErrUploadNotFound
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return errors.New("error removing upload: " + err.Error())
+	}
+	return nil
+}
+
+// AbortStaleUploads aborts the multipart uploads of a bucket initiated longer than maxAge ago
+// and returns how many were removed
+func AbortStaleUploads(dirPath, bucketName string, maxAge time.Duration) int {
+	uploads, err := ListMultipartUploads(dirPath, bucketName)
+	if err != nil {
+		log.Println("Error listing uploads of bucket", bucketName+":", err)
+		return 0
+	}
+
+	removed := 0
+	for _, upload := range uploads {
+		initiated, err := time.Parse(time.RFC3339, upload.Initiated)
+		if err != nil || time.Since(initiated) < maxAge {
+			continue
+		}
+		unlock := LockUpload(dirPath, bucketName, upload.UploadID)
+		err = AbortMultipartUpload(dirPath, bucketName, upload.UploadID)
+		unlock()
+		if err != nil {
+			log.Println("Error aborting upload", upload.UploadID+":", err)
+			continue
+		}
+		log.Printf("Aborted abandoned upload %s of %s/%s\n", upload.UploadID, bucketName, upload.Key)
+		removed++
+	}
+	return removed
+}
+
+// CleanupAbandonedUploads aborts stale multipart uploads in every bucket of the data directory
+func CleanupAbandonedUploads(dirPath string, maxAge time.Duration) {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		log.Println("Error reading data directory:", err)
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			AbortStaleUploads(dirPath, entry.Name(), maxAge)
+		}
+	}
+}
@@ -2,22 +2,30 @@ package services
 
 import (
 	"encoding/base64"
-	"encoding/csv"
 	"errors"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"triple-s/internal/models"
 )
 
-// WriteObjectInfo writes or updates object metadata to a CSV file and returns an error if something goes wrong.
-func WriteObjectInfo(r *http.Request, dirPath, bucketName, objectKey string) error {
+// NewObjectInfo builds the metadata of an object written by a request and returns an error if
+// something goes wrong. The etag is the hex MD5 digest of the object content and the versionID
+// is empty for the null version.
+func NewObjectInfo(r *http.Request, dirPath, bucketName, objectKey, etag, versionID string) (models.Object, error) {
 	// Get file information
-	fileInfo, err := os.Stat(dirPath + bucketName + "/" + objectKey)
+	objectPath, err := ObjectPath(dirPath, bucketName, objectKey)
 	if err != nil {
-		return errors.New("cannot read file: " + err.Error())
+		return models.Object{}, err
+	}
+	fileInfo, err := os.Stat(objectPath)
+	if err != nil {
+		return models.Object{}, errors.New("cannot read file: " + err.Error())
 	}
 	contType := r.Header.Get("Content-Type")
 
@@ -27,77 +35,177 @@ func WriteObjectInfo(r *http.Request, dirPath, bucketName, objectKey string) err
 		Size:             fileInfo.Size(),
 		ContentType:      contType,
 		LastModifiedTime: fileInfo.ModTime().Format(time.RFC3339),
+		ETag:             etag,
+		Metadata:         ExtractObjectMetadata(r.Header),
+		VersionID:        versionID,
+	}
+	if header := r.Header.Get("x-amz-tagging"); header != "" {
+		localObject.Tags, _ = ParseTaggingHeader(header)
 	}
+	localObject.ACL = StoredACL(r.Header.Get("x-amz-acl"))
+
+	return localObject, nil
+}
 
-	// Prepare the CSV file path
-	csvFilePath := dirPath + bucketName + "/objects.csv"
+// PutObjectInfo inserts or replaces the metadata record of an object
+func PutObjectInfo(dirPath, bucketName string, localObject models.Object) error {
+	return Metadata(dirPath).PutObject(bucketName, localObject)
+}
 
-	// Read existing CSV data
-	var updatedRecords [][]string
-	encodedName := base64.StdEncoding.EncodeToString([]byte(localObject.ObjectKey))
-	encodedModTime := base64.StdEncoding.EncodeToString([]byte(localObject.LastModifiedTime))
+// ErrObjectNotFound is returned when an object has no metadata record
+var ErrObjectNotFound = errors.New("object not found")
 
-	// Open the CSV file for reading
-	objectInfo, err := os.Open(csvFilePath)
-	if err != nil {
-		return errors.New("error opening CSV file: " + err.Error())
-	}
-	defer objectInfo.Close()
+// ReadObjectInfo looks up the metadata of a single object
+func ReadObjectInfo(dirPath, bucketName, objectKey string) (models.Object, error) {
+	return Metadata(dirPath).GetObject(bucketName, objectKey)
+}
 
-	// Create a CSV reader
-	reader := csv.NewReader(objectInfo)
-	records, err := reader.ReadAll()
+// ListObjectInfo returns the metadata of the objects of the bucket whose key starts with
+// prefix, sorted by key
+func ListObjectInfo(dirPath, bucketName, prefix string) ([]models.Object, error) {
+	var objects []models.Object
+	err := ScanObjectInfo(dirPath, bucketName, prefix, "", func(object models.Object) bool {
+		objects = append(objects, object)
+		return true
+	})
 	if err != nil {
-		return errors.New("error reading CSV file: " + err.Error())
-	}
-
-	// Update existing records or append new record
-	found := false
-	for _, record := range records {
-		if record[0] == encodedName {
-			// Update the existing record with new values
-			updatedRecords = append(updatedRecords, []string{
-				encodedName,
-				strconv.Itoa(int(localObject.Size)),
-				localObject.ContentType,
-				encodedModTime,
-			})
-			found = true
-		} else {
-			// Keep the existing record
-			updatedRecords = append(updatedRecords, record)
-		}
+		return nil, err
 	}
+	return objects, nil
+}
+
+// ScanObjectInfo calls fn with the metadata of the objects of the bucket whose key starts
+// with prefix and sorts after startAfter, in key order, until fn returns false. Listings
+// read a page this way without loading every object of the prefix.
+func ScanObjectInfo(dirPath, bucketName, prefix, startAfter string, fn func(models.Object) bool) error {
+	return Metadata(dirPath).ScanObjects(bucketName, prefix, startAfter, fn)
+}
 
-	if !found {
-		// Append new metadata if the object is not found
-		updatedRecords = append(updatedRecords, []string{
-			encodedName,
-			strconv.Itoa(int(localObject.Size)),
-			localObject.ContentType,
-			encodedModTime,
-		})
+// encodeObjectRecord converts object metadata into an objects.csv record
+func encodeObjectRecord(object models.Object) []string {
+	return []string{
+		base64.StdEncoding.EncodeToString([]byte(object.ObjectKey)),
+		strconv.FormatInt(object.Size, 10),
+		object.ContentType,
+		base64.StdEncoding.EncodeToString([]byte(object.LastModifiedTime)),
+		object.ETag,
+		encodeMap(object.Metadata),
+		encodeMap(object.Tags),
+		object.VersionID,
+		object.ACL,
 	}
+}
 
-	// Open the CSV file for writing (this will truncate the file)
-	objectInfo, err = os.OpenFile(csvFilePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+// decodeObjectRecord converts an objects.csv record into object metadata
+func decodeObjectRecord(record []string) (models.Object, error) {
+	name, err := base64.StdEncoding.DecodeString(record[0])
+	if err != nil {
+		return models.Object{}, errors.New("error decoding object name: " + err.Error())
+	}
+	size, _ := strconv.ParseInt(record[1], 10, 64)
+	modTime, err := base64.StdEncoding.DecodeString(record[3])
 	if err != nil {
-		return errors.New("error opening CSV file for writing: " + err.Error())
+		return models.Object{}, errors.New("error decoding modification time: " + err.Error())
+	}
+
+	localObject := models.Object{
+		ObjectKey:        string(name),
+		Size:             size,
+		ContentType:      record[2],
+		LastModifiedTime: string(modTime),
+	}
+	// Records written before ETags were tracked only have four fields
+	if len(record) > 4 {
+		localObject.ETag = record[4]
 	}
-	defer objectInfo.Close()
+	if len(record) > 5 {
+		localObject.Metadata = decodeMap(record[5])
+	}
+	if len(record) > 6 {
+		localObject.Tags = decodeMap(record[6])
+	}
+	if len(record) > 7 {
+		localObject.VersionID = record[7]
+	}
+	if len(record) > 8 {
+		localObject.ACL = record[8]
+	}
+	return localObject, nil
+}
 
-	// Write updated records to the CSV file
-	writer := csv.NewWriter(objectInfo)
-	if err := writer.WriteAll(updatedRecords); err != nil {
-		return errors.New("error writing updated CSV data: " + err.Error())
+const (
+	// folderMarkerName is the file holding a key that ends with a slash, such as the "photos/"
+	// folder markers created by S3 consoles, inside the directory of the key
+	folderMarkerName = ".triple-s-folder"
+	// emptySegmentName is the directory standing for an empty segment of a key, as in "a//b"
+	emptySegmentName = ".triple-s-empty"
+)
+
+// ReservedKeySegment reports whether a key segment is one of the names ObjectPath uses to
+// encode empty segments, which keys may not contain themselves
+func ReservedKeySegment(segment string) bool {
+	return segment == folderMarkerName || segment == emptySegmentName
+}
+
+// ObjectPath maps an object key to its file inside the bucket directory.
+// Keys containing slashes are stored in nested directories, and an error is
+// returned for any key that would resolve outside of the bucket. A key ending with a
+// slash is stored in a marker file inside its directory and an empty segment in a reserved
+// directory, so that "a/", "a//b" and "a/b" never collide.
+func ObjectPath(dirPath, bucketName, objectKey string) (string, error) {
+	segments := strings.Split(objectKey, "/")
+	for i, segment := range segments {
+		if segment == "" && i == len(segments)-1 {
+			segments[i] = folderMarkerName
+		} else if segment == "" {
+			segments[i] = emptySegmentName
+		}
 	}
-	writer.Flush()
+	bucketPath := filepath.Clean(dirPath + bucketName)
+	objectPath := filepath.Join(append([]string{bucketPath}, segments...)...)
+	if !strings.HasPrefix(objectPath, bucketPath+string(filepath.Separator)) {
+		return "", errors.New("object key escapes bucket directory")
+	}
+	return objectPath, nil
+}
 
-	// Check if there were any errors during the flush process
-	if err := writer.Error(); err != nil {
-		return errors.New("error flushing CSV data: " + err.Error())
+// RemoveEmptyParents removes the directories left empty after deleting an object,
+// walking up from the object path until the bucket directory is reached.
+func RemoveEmptyParents(dirPath, bucketName, objectPath string) {
+	defer lockBucketDirs(dirPath, bucketName, false)()
+	bucketPath := filepath.Clean(dirPath + bucketName)
+	for dir := filepath.Dir(objectPath); dir != bucketPath && strings.HasPrefix(dir, bucketPath); dir = filepath.Dir(dir) {
+		// os.Remove fails on non-empty directories, which ends the walk
+		if err := os.Remove(dir); err != nil {
+			return
+		}
 	}
+}
+
+// ErrKeyConflict is returned when an object cannot be moved into place because an object is
+// stored under a prefix of its key, or objects are stored under the key itself
+var ErrKeyConflict = errors.New("object key conflicts with an existing object")
 
-	// Return nil if everything succeeded
+// placeFile renames a file to an object path, creating the directories of a hierarchical key.
+// Both happen under the bucket's directory lock, so that a delete of a sibling key cannot
+// remove the directories it left empty between the two.
+func placeFile(dirPath, bucketName, path, objectPath string) error {
+	defer lockBucketDirs(dirPath, bucketName, true)()
+	if err := os.MkdirAll(filepath.Dir(objectPath), os.ModePerm); errors.Is(err, syscall.ENOTDIR) {
+		return ErrKeyConflict
+	} else if err != nil {
+		return errors.New("error creating object directory: " + err.Error())
+	}
+	if err := os.Rename(path, objectPath); err != nil {
+		if info, statErr := os.Stat(objectPath); statErr == nil && info.IsDir() {
+			return ErrKeyConflict
+		}
+		return errors.New("error moving object into place: " + err.Error())
+	}
 	return nil
 }
+
+// DeleteObjectInfos removes the metadata records of the given keys in a single change
+func DeleteObjectInfos(dirPath, bucketName string, objectKeys []string) error {
+	return Metadata(dirPath).DeleteObjects(bucketName, objectKeys)
+}
@@ -0,0 +1,326 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"triple-s/internal/models"
+)
+
+// maxPolicySize is the largest bucket policy accepted, 20 KB as in Amazon S3
+const maxPolicySize = 20 << 10
+
+// ErrPolicyNotFound is returned when a bucket has no policy
+var ErrPolicyNotFound = errors.New("bucket policy not found")
+
+// PolicyDecision is the outcome of evaluating a bucket policy for a request
+type PolicyDecision int
+
+const (
+	// PolicyNoMatch means no statement applies, leaving the decision to the default rules
+	PolicyNoMatch PolicyDecision = iota
+	// PolicyAllow means an Allow statement applies and no Deny statement does
+	PolicyAllow
+	// PolicyDeny means a Deny statement applies, which overrides any Allow
+	PolicyDeny
+)
+
+// PolicyRequest is the context a bucket policy is evaluated against
+type PolicyRequest struct {
+	// Principals are the names the requester is known by; empty for anonymous requests
+	Principals []string
+	Action     string
+	Resource   string
+	SourceIP   net.IP
+	Secure     bool
+	// Keys holds the remaining condition keys present in the request, such as s3:prefix
+	Keys map[string]string
+}
+
+// supportedConditions lists the condition operators understood by the policy engine
+var supportedConditions = map[string]bool{
+	"StringEquals":    true,
+	"StringNotEquals": true,
+	"StringLike":      true,
+	"StringNotLike":   true,
+	"IpAddress":       true,
+	"NotIpAddress":    true,
+	"Bool":            true,
+}
+
+// policyFile returns the path of the file holding the policy of a bucket
+func policyFile(dirPath, bucketName string) string {
+	return filepath.Join(dirPath+bucketName, InternalDirName, "policy.json")
+}
+
+// ParseBucketPolicy decodes a policy document and checks that every statement is well formed
+// and only refers to the given bucket
+func ParseBucketPolicy(document []byte, bucketName string) (models.BucketPolicy, error) {
+	var policy models.BucketPolicy
+	if len(document) > maxPolicySize {
+		return policy, errors.New("policies may be at most 20 KB")
+	}
+	if err := json.Unmarshal(document, &policy); err != nil {
+		return policy, errors.New("policy is not valid JSON: " + err.Error())
+	}
+	if policy.Version != "2012-10-17" && policy.Version != "2008-10-17" {
+		return policy, errors.New("policy version must be 2012-10-17 or 2008-10-17")
+	}
+	if len(policy.Statements) == 0 {
+		return policy, errors.New("policy must contain at least one statement")
+	}
+
+	bucketARN := "arn:aws:s3:::" + bucketName
+	for _, statement := range policy.Statements {
+		if statement.Effect != "Allow" && statement.Effect != "Deny" {
+			return policy, errors.New("statement effect must be Allow or Deny")
+		}
+		if len(statement.Principal) == 0 {
+			return policy, errors.New("statement must name a principal")
+		}
+		if len(statement.Action) == 0 {
+			return policy, errors.New("statement must name an action")
+		}
+		for _, action := range statement.Action {
+			if action != "*" && !strings.HasPrefix(strings.ToLower(action), "s3:") {
+				return policy, errors.New("invalid action " + action)
+			}
+		}
+		if len(statement.Resource) == 0 {
+			return policy, errors.New("statement must name a resource")
+		}
+		for _, resource := range statement.Resource {
+			if resource != bucketARN && !strings.HasPrefix(resource, bucketARN+"/") {
+				return policy, errors.New("resource " + resource + " is outside of the bucket")
+			}
+		}
+		for operator, conditions := range statement.Condition {
+			if !supportedConditions[operator] {
+				return policy, errors.New("unsupported condition operator " + operator)
+			}
+			if operator == "IpAddress" || operator == "NotIpAddress" {
+				for _, values := range conditions {
+					for _, value := range values {
+						if parseCIDR(value) == nil {
+							return policy, errors.New("invalid IP address or range " + value)
+						}
+					}
+				}
+			}
+		}
+	}
+	return policy, nil
+}
+
+// ReadBucketPolicy returns the policy document of a bucket as it was stored
+func ReadBucketPolicy(dirPath, bucketName string) ([]byte, error) {
+	document, err := os.ReadFile(policyFile(dirPath, bucketName))
+	if os.IsNotExist(err) {
+		return nil, ErrPolicyNotFound
+	} else if err != nil {
+		return nil, errors.New("error reading bucket policy: " + err.Error())
+	}
+	return document, nil
+}
+
+// LoadBucketPolicy reads and decodes the policy of a bucket
+func LoadBucketPolicy(dirPath, bucketName string) (models.BucketPolicy, error) {
+	document, err := ReadBucketPolicy(dirPath, bucketName)
+	if err != nil {
+		return models.BucketPolicy{}, err
+	}
+	return ParseBucketPolicy(document, bucketName)
+}
+
+// WriteBucketPolicy stores the policy document of a bucket through the journal
+func WriteBucketPolicy(dirPath, bucketName string, document []byte) error {
+	changes := newMetadataChanges(dirPath)
+	if err := changes.writeFile(policyFile(dirPath, bucketName), document); err != nil {
+		return errors.New("error writing bucket policy: " + err.Error())
+	}
+	if err := changes.apply(); err != nil {
+		return errors.New("error writing bucket policy: " + err.Error())
+	}
+	return nil
+}
+
+// DeleteBucketPolicy removes the policy of a bucket, if any, through the journal
+func DeleteBucketPolicy(dirPath, bucketName string) error {
+	changes := newMetadataChanges(dirPath)
+	if err := changes.removeFile(policyFile(dirPath, bucketName)); err != nil {
+		return errors.New("error removing bucket policy: " + err.Error())
+	}
+	if err := changes.apply(); err != nil {
+		return errors.New("error removing bucket policy: " + err.Error())
+	}
+	return nil
+}
+
+// EvaluatePolicy decides a request against a bucket policy. An applicable Deny statement
+// always wins over Allow statements.
+func EvaluatePolicy(policy models.BucketPolicy, request PolicyRequest) PolicyDecision {
+	decision := PolicyNoMatch
+	for _, statement := range policy.Statements {
+		if !statementApplies(statement, request) {
+			continue
+		}
+		if statement.Effect == "Deny" {
+			return PolicyDeny
+		}
+		decision = PolicyAllow
+	}
+	return decision
+}
+
+// statementApplies reports whether every element of a statement matches the request
+func statementApplies(statement models.PolicyStatement, request PolicyRequest) bool {
+	if !principalMatches(statement.Principal, request.Principals) {
+		return false
+	}
+
+	actionMatched := false
+	for _, action := range statement.Action {
+		if WildcardMatch(strings.ToLower(action), strings.ToLower(request.Action)) {
+			actionMatched = true
+			break
+		}
+	}
+	if !actionMatched {
+		return false
+	}
+
+	resourceMatched := false
+	for _, resource := range statement.Resource {
+		if WildcardMatch(resource, request.Resource) {
+			resourceMatched = true
+			break
+		}
+	}
+	if !resourceMatched {
+		return false
+	}
+
+	for operator, conditions := range statement.Condition {
+		for key, values := range conditions {
+			if !conditionMatches(operator, key, values, request) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// principalMatches reports whether a statement principal covers the requester. "*" covers
+// everyone, anonymous requesters included; other entries name a user, an access key ID or
+// an IAM user ARN ending in ":user/{name}".
+func principalMatches(principal models.PolicyPrincipal, principals []string) bool {
+	for _, names := range principal {
+		for _, name := range names {
+			if name == "*" {
+				return true
+			}
+			if _, user, found := strings.Cut(name, ":user/"); found {
+				name = user
+			}
+			for _, requester := range principals {
+				if requester != "" && name == requester {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// conditionMatches evaluates one condition key of a statement. As in IAM, a key missing from
+// the request only satisfies the negated operators.
+func conditionMatches(operator, key string, values models.StringList, request PolicyRequest) bool {
+	switch operator {
+	case "IpAddress", "NotIpAddress":
+		inRange := false
+		if strings.EqualFold(key, "aws:SourceIp") && request.SourceIP != nil {
+			for _, value := range values {
+				if network := parseCIDR(value); network != nil && network.Contains(request.SourceIP) {
+					inRange = true
+					break
+				}
+			}
+		}
+		return inRange == (operator == "IpAddress")
+	case "Bool":
+		if !strings.EqualFold(key, "aws:SecureTransport") {
+			return false
+		}
+		for _, value := range values {
+			if strings.EqualFold(value, "true") == request.Secure {
+				return true
+			}
+		}
+		return false
+	}
+
+	actual, present := request.Keys[strings.ToLower(key)]
+	matched := false
+	if present {
+		for _, value := range values {
+			if operator == "StringLike" || operator == "StringNotLike" {
+				matched = WildcardMatch(value, actual)
+			} else {
+				matched = value == actual
+			}
+			if matched {
+				break
+			}
+		}
+	}
+	if operator == "StringNotEquals" || operator == "StringNotLike" {
+		return !matched
+	}
+	return matched
+}
+
+// parseCIDR parses an IP range, accepting a bare address as a single-address range
+func parseCIDR(value string) *net.IPNet {
+	if _, network, err := net.ParseCIDR(value); err == nil {
+		return network
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil
+	}
+	bits := 128
+	if ip.To4() != nil {
+		ip, bits = ip.To4(), 32
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+}
+
+// WildcardMatch reports whether a value matches a pattern where "*" stands for any run of
+// characters and "?" for any single character
+func WildcardMatch(pattern, value string) bool {
+	p, v := 0, 0
+	star, resume := -1, 0
+	for v < len(value) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == value[v]):
+			p++
+			v++
+		case p < len(pattern) && pattern[p] == '*':
+			star, resume = p, v
+			p++
+		case star >= 0:
+			resume++
+			p, v = star+1, resume
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
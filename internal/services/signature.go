@@ -0,0 +1,517 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// SigningAlgorithm is the only signature algorithm accepted by the server
+	SigningAlgorithm = "AWS4-HMAC-SHA256"
+	// AmzDateFormat is the layout of X-Amz-Date values
+	AmzDateFormat = "20060102T150405Z"
+	// UnsignedPayload marks a request whose body is not covered by the signature
+	UnsignedPayload = "UNSIGNED-PAYLOAD"
+	// StreamingPayload marks an aws-chunked body where every chunk carries its own signature
+	StreamingPayload = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	// StreamingUnsignedTrailer marks an aws-chunked body without chunk signatures followed by trailers
+	StreamingUnsignedTrailer = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+
+	// MaxPresignExpiry is the longest validity of a presigned URL, seven days as in Amazon S3
+	MaxPresignExpiry = 7 * 24 * time.Hour
+
+	// maxClockSkew is how far the request time may be from the server time
+	maxClockSkew = 15 * time.Minute
+	// maxChunkSize bounds the memory used to verify a single aws-chunked chunk
+	maxChunkSize = 16 << 20
+	// emptySHA256 is the hex SHA-256 digest of an empty payload
+	emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+var (
+	// ErrMalformedAuthorization is returned for an Authorization header that cannot be parsed
+	ErrMalformedAuthorization = errors.New("malformed authorization")
+	// ErrSignatureMismatch is returned when the computed signature differs from the one sent
+	ErrSignatureMismatch = errors.New("signature does not match")
+	// ErrRequestTimeSkewed is returned when the request time is too far from the server time
+	ErrRequestTimeSkewed = errors.New("request time too skewed")
+	// ErrMissingContentSHA256 is returned when a signed request has no x-amz-content-sha256 header
+	ErrMissingContentSHA256 = errors.New("missing x-amz-content-sha256")
+	// ErrContentSHA256Mismatch is returned when a body does not match its declared SHA-256 digest
+	ErrContentSHA256Mismatch = errors.New("content SHA-256 does not match")
+	// ErrMalformedQueryAuth is returned for presigned URL parameters that cannot be parsed
+	ErrMalformedQueryAuth = errors.New("malformed query authentication")
+	// ErrRequestExpired is returned for a presigned URL used after its expiry
+	ErrRequestExpired = errors.New("request has expired")
+	// ErrMalformedChunk is returned when an aws-chunked body cannot be decoded
+	ErrMalformedChunk = errors.New("malformed aws-chunked body")
+)
+
+// SignatureAuth holds the fields of a Signature Version 4 Authorization header
+type SignatureAuth struct {
+	AccessKeyID   string
+	Date          string
+	Region        string
+	Service       string
+	SignedHeaders []string
+	Signature     string
+}
+
+// Scope returns the credential scope the signature was computed for
+func (auth SignatureAuth) Scope() string {
+	return auth.Date + "/" + auth.Region + "/" + auth.Service + "/aws4_request"
+}
+
+// ParseAuthorization parses an Authorization header of the form
+// "AWS4-HMAC-SHA256 Credential=AKID/date/region/s3/aws4_request, SignedHeaders=a;b, Signature=hex"
+func ParseAuthorization(header string) (SignatureAuth, error) {
+	algorithm, fields, found := strings.Cut(header, " ")
+	if !found || algorithm != SigningAlgorithm {
+		return SignatureAuth{}, ErrMalformedAuthorization
+	}
+
+	var auth SignatureAuth
+	var credential, signedHeaders string
+	for _, field := range strings.Split(fields, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(field), "=")
+		switch name {
+		case "Credential":
+			credential = value
+		case "SignedHeaders":
+			signedHeaders = value
+		case "Signature":
+			auth.Signature = value
+		}
+	}
+	if err := parseCredential(&auth, credential); err != nil {
+		return SignatureAuth{}, err
+	}
+	if signedHeaders == "" || auth.Signature == "" {
+		return SignatureAuth{}, ErrMalformedAuthorization
+	}
+	auth.SignedHeaders = strings.Split(signedHeaders, ";")
+	return auth, nil
+}
+
+// parseCredential fills the access key and scope of a signature from a Credential value
+func parseCredential(auth *SignatureAuth, credential string) error {
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[0] == "" || parts[4] != "aws4_request" || parts[3] != "s3" {
+		return ErrMalformedAuthorization
+	}
+	if _, err := time.Parse("20060102", parts[1]); err != nil {
+		return ErrMalformedAuthorization
+	}
+	auth.AccessKeyID, auth.Date, auth.Region, auth.Service = parts[0], parts[1], parts[2], parts[3]
+	return nil
+}
+
+// uriEncode percent-encodes a string as required by Signature Version 4, leaving only
+// unreserved characters and, unless encodeSlash is set, slashes as they are
+func uriEncode(value string, encodeSlash bool) string {
+	var encoded strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '.', c == '_', c == '~':
+			encoded.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			encoded.WriteByte(c)
+		default:
+			encoded.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{c})))
+		}
+	}
+	return encoded.String()
+}
+
+// canonicalQuery sorts and encodes the query parameters of a request, leaving out the signature itself
+func canonicalQuery(query url.Values) string {
+	var pairs []string
+	for name, values := range query {
+		if name == "X-Amz-Signature" {
+			continue
+		}
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// canonicalHeaderValue trims a header value and collapses its inner runs of spaces
+func canonicalHeaderValue(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
+
+// CanonicalRequest builds the canonical form of a request that is hashed into the string to sign
+func CanonicalRequest(r *http.Request, signedHeaders []string, payloadHash string) string {
+	path := r.URL.Path
+	if path == "" {
+		path = "/"
+	}
+
+	var headers strings.Builder
+	for _, name := range signedHeaders {
+		var values []string
+		if name == "host" {
+			// The Host header is moved out of the header map by net/http
+			values = []string{r.Host}
+		} else {
+			for _, value := range r.Header.Values(name) {
+				values = append(values, canonicalHeaderValue(value))
+			}
+		}
+		headers.WriteString(name + ":" + strings.Join(values, ",") + "\n")
+	}
+
+	return strings.Join([]string{
+		r.Method,
+		uriEncode(path, false),
+		canonicalQuery(r.URL.Query()),
+		headers.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+}
+
+// StringToSign builds the string signed with the signing key for a canonical request
+func StringToSign(amzDate, scope, canonicalRequest string) string {
+	digest := sha256.Sum256([]byte(canonicalRequest))
+	return SigningAlgorithm + "\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(digest[:])
+}
+
+// hmacSHA256 computes an HMAC-SHA256 of the data with the key
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// SigningKey derives the key signing requests of one day, region and service from a secret
+func SigningKey(secret, date, region, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+// Sign returns the hex signature of a string to sign
+func Sign(signingKey []byte, stringToSign string) string {
+	return hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+}
+
+// VerifyRequest checks the Signature Version 4 signature of a request carried in its
+// Authorization header. A body whose digest is declared is verified while it is read,
+// and aws-chunked bodies are decoded so handlers see the plain object data.
+func VerifyRequest(r *http.Request, auth SignatureAuth, secret string, now time.Time) error {
+	amzDate := r.Header.Get("X-Amz-Date")
+	requestTime, err := time.Parse(AmzDateFormat, amzDate)
+	if err != nil {
+		// The Date header may stand in for X-Amz-Date
+		amzDate = r.Header.Get("Date")
+		parsed, dateErr := http.ParseTime(amzDate)
+		if dateErr != nil {
+			return ErrMalformedAuthorization
+		}
+		requestTime = parsed
+		amzDate = parsed.UTC().Format(AmzDateFormat)
+	}
+	if requestTime.Sub(now) > maxClockSkew || now.Sub(requestTime) > maxClockSkew {
+		return ErrRequestTimeSkewed
+	}
+	if auth.Date != amzDate[:8] {
+		return ErrMalformedAuthorization
+	}
+
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		return ErrMissingContentSHA256
+	}
+
+	signingKey := SigningKey(secret, auth.Date, auth.Region, auth.Service)
+	stringToSign := StringToSign(amzDate, auth.Scope(), CanonicalRequest(r, auth.SignedHeaders, payloadHash))
+	expected := Sign(signingKey, stringToSign)
+	if !hmac.Equal([]byte(expected), []byte(auth.Signature)) {
+		return ErrSignatureMismatch
+	}
+
+	switch payloadHash {
+	case UnsignedPayload:
+	case StreamingPayload, StreamingUnsignedTrailer:
+		reader := &chunkedReader{body: r.Body, reader: bufio.NewReader(r.Body), trailer: payloadHash == StreamingUnsignedTrailer}
+		if payloadHash == StreamingPayload {
+			reader.signingKey = signingKey
+			reader.amzDate = amzDate
+			reader.scope = auth.Scope()
+			reader.previousSignature = expected
+		}
+		if decodedLength := r.Header.Get("X-Amz-Decoded-Content-Length"); decodedLength != "" {
+			length, err := strconv.ParseInt(decodedLength, 10, 64)
+			if err != nil || length < 0 {
+				return ErrMalformedChunk
+			}
+			reader.expectedLength = length
+			r.ContentLength = length
+		} else {
+			reader.expectedLength = -1
+			r.ContentLength = -1
+		}
+		r.Body = reader
+		r.Header.Del("Content-Length")
+		removeChunkedEncoding(r.Header)
+	default:
+		if _, err := hex.DecodeString(payloadHash); err != nil || len(payloadHash) != 64 {
+			return ErrContentSHA256Mismatch
+		}
+		r.Body = &digestReader{body: r.Body, hash: sha256.New(), expected: payloadHash}
+	}
+	return nil
+}
+
+// removeChunkedEncoding drops the aws-chunked coding from Content-Encoding so it is not
+// stored as a representation header of the object
+func removeChunkedEncoding(header http.Header) {
+	var codings []string
+	for _, coding := range strings.Split(header.Get("Content-Encoding"), ",") {
+		if coding = strings.TrimSpace(coding); coding != "" && coding != "aws-chunked" {
+			codings = append(codings, coding)
+		}
+	}
+	if len(codings) == 0 {
+		header.Del("Content-Encoding")
+	} else {
+		header.Set("Content-Encoding", strings.Join(codings, ","))
+	}
+}
+
+// digestReader verifies the SHA-256 digest of a body once it has been read to the end
+type digestReader struct {
+	body     io.ReadCloser
+	hash     hash.Hash
+	expected string
+}
+
+func (d *digestReader) Read(p []byte) (int, error) {
+	n, err := d.body.Read(p)
+	d.hash.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(d.hash.Sum(nil)) != d.expected {
+		return n, ErrContentSHA256Mismatch
+	}
+	return n, err
+}
+
+func (d *digestReader) Close() error {
+	return d.body.Close()
+}
+
+// chunkedReader decodes an aws-chunked body. Each chunk is buffered and, for signed
+// streaming uploads, its signature is checked before any of its data is returned.
+type chunkedReader struct {
+	body   io.Closer
+	reader *bufio.Reader
+
+	signingKey        []byte
+	amzDate           string
+	scope             string
+	previousSignature string
+	trailer           bool
+
+	expectedLength int64
+	decodedLength  int64
+	chunk          []byte
+	done           bool
+	err            error
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	for len(c.chunk) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		if c.done {
+			return 0, io.EOF
+		}
+		c.err = c.readChunk()
+	}
+	n := copy(p, c.chunk)
+	c.chunk = c.chunk[n:]
+	return n, nil
+}
+
+func (c *chunkedReader) Close() error {
+	return c.body.Close()
+}
+
+// readLine reads a CRLF-terminated line of bounded length
+func (c *chunkedReader) readLine() (string, error) {
+	line, err := c.reader.ReadSlice('\n')
+	if err != nil {
+		return "", ErrMalformedChunk
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return "", ErrMalformedChunk
+	}
+	return string(line[:len(line)-2]), nil
+}
+
+// readChunk reads and verifies the next chunk of the body
+func (c *chunkedReader) readChunk() error {
+	header, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	sizeField, extension, _ := strings.Cut(header, ";")
+	size, err := strconv.ParseInt(sizeField, 16, 64)
+	if err != nil || size < 0 || size > maxChunkSize {
+		return ErrMalformedChunk
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(c.reader, data); err != nil {
+		return ErrMalformedChunk
+	}
+
+	if c.signingKey != nil {
+		signature, found := strings.CutPrefix(extension, "chunk-signature=")
+		if !found {
+			return ErrMalformedChunk
+		}
+		digest := sha256.Sum256(data)
+		stringToSign := "AWS4-HMAC-SHA256-PAYLOAD\n" + c.amzDate + "\n" + c.scope + "\n" +
+			c.previousSignature + "\n" + emptySHA256 + "\n" + hex.EncodeToString(digest[:])
+		expected := Sign(c.signingKey, stringToSign)
+		if !hmac.Equal([]byte(expected), []byte(signature)) {
+			return ErrSignatureMismatch
+		}
+		c.previousSignature = expected
+	}
+
+	if size == 0 {
+		// The last chunk is followed by optional trailers and an empty line
+		for {
+			line, err := c.readLine()
+			if err != nil {
+				return err
+			}
+			if line == "" {
+				break
+			}
+			if !c.trailer {
+				return ErrMalformedChunk
+			}
+		}
+		if c.expectedLength >= 0 && c.decodedLength != c.expectedLength {
+			return ErrMalformedChunk
+		}
+		c.done = true
+		return nil
+	}
+
+	if line, err := c.readLine(); err != nil || line != "" {
+		return ErrMalformedChunk
+	}
+	c.decodedLength += size
+	if c.expectedLength >= 0 && c.decodedLength > c.expectedLength {
+		return ErrMalformedChunk
+	}
+	c.chunk = data
+	return nil
+}
+
+// ParsePresignedQuery reads the signature of a presigned URL from its X-Amz-* query parameters
+func ParsePresignedQuery(query url.Values) (SignatureAuth, error) {
+	if query.Get("X-Amz-Algorithm") != SigningAlgorithm {
+		return SignatureAuth{}, ErrMalformedQueryAuth
+	}
+
+	var auth SignatureAuth
+	if err := parseCredential(&auth, query.Get("X-Amz-Credential")); err != nil {
+		return SignatureAuth{}, ErrMalformedQueryAuth
+	}
+	auth.Signature = query.Get("X-Amz-Signature")
+	signedHeaders := query.Get("X-Amz-SignedHeaders")
+	if signedHeaders == "" || auth.Signature == "" {
+		return SignatureAuth{}, ErrMalformedQueryAuth
+	}
+	auth.SignedHeaders = strings.Split(signedHeaders, ";")
+	return auth, nil
+}
+
+// VerifyPresignedRequest checks the signature of a request authenticated through presigned
+// URL query parameters. The body of such requests is never covered by the signature.
+func VerifyPresignedRequest(r *http.Request, auth SignatureAuth, secret string, now time.Time) error {
+	query := r.URL.Query()
+	amzDate := query.Get("X-Amz-Date")
+	requestTime, err := time.Parse(AmzDateFormat, amzDate)
+	if err != nil || auth.Date != amzDate[:8] {
+		return ErrMalformedQueryAuth
+	}
+	expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+	if err != nil || expires <= 0 || time.Duration(expires)*time.Second > MaxPresignExpiry {
+		return ErrMalformedQueryAuth
+	}
+	if requestTime.Sub(now) > maxClockSkew {
+		return ErrRequestTimeSkewed
+	}
+	if now.After(requestTime.Add(time.Duration(expires) * time.Second)) {
+		return ErrRequestExpired
+	}
+
+	payloadHash := query.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		payloadHash = UnsignedPayload
+	}
+	signingKey := SigningKey(secret, auth.Date, auth.Region, auth.Service)
+	expected := Sign(signingKey, StringToSign(amzDate, auth.Scope(), CanonicalRequest(r, auth.SignedHeaders, payloadHash)))
+	if !hmac.Equal([]byte(expected), []byte(auth.Signature)) {
+		return ErrSignatureMismatch
+	}
+	return nil
+}
+
+// PresignURL builds a presigned URL letting anyone holding it send one kind of request to an
+// object until it expires. The endpoint is the base URL of the server, such as http://localhost:8080.
+func PresignURL(endpoint, method, bucketName, objectKey, accessKeyID, secret, region string, expires time.Duration, now time.Time) (string, error) {
+	base, err := url.Parse(endpoint)
+	if err != nil || base.Host == "" {
+		return "", errors.New("invalid endpoint: " + endpoint)
+	}
+	if expires <= 0 || expires > MaxPresignExpiry {
+		return "", errors.New("expiry must be between one second and seven days")
+	}
+
+	now = now.UTC()
+	date := now.Format("20060102")
+	scope := date + "/" + region + "/s3/aws4_request"
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", SigningAlgorithm)
+	query.Set("X-Amz-Credential", accessKeyID+"/"+scope)
+	query.Set("X-Amz-Date", now.Format(AmzDateFormat))
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expires/time.Second)))
+	query.Set("X-Amz-SignedHeaders", "host")
+
+	path := "/" + bucketName + "/" + objectKey
+	request := &http.Request{
+		Method: method,
+		URL:    &url.URL{Path: path, RawQuery: query.Encode()},
+		Host:   base.Host,
+		Header: http.Header{},
+	}
+	signingKey := SigningKey(secret, date, region, "s3")
+	query.Set("X-Amz-Signature", Sign(signingKey, StringToSign(now.Format(AmzDateFormat), scope, CanonicalRequest(request, []string{"host"}, UnsignedPayload))))
+
+	return base.Scheme + "://" + base.Host + uriEncode(path, false) + "?" + query.Encode(), nil
+}
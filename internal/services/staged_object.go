@@ -0,0 +1,109 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// stagingDir is the directory holding object data while it is being written. It lives in the
+// bucket so that committing an object is a rename within a single file system.
+func stagingDir(dirPath, bucketName string) string {
+	return filepath.Join(dirPath+bucketName, InternalDirName, "tmp")
+}
+
+// StagedObject is the data of an object being written. Readers keep seeing the previous
+// content until the staged data is complete and committed over the object path.
+type StagedObject struct {
+	dirPath    string
+	bucketName string
+	file       *os.File
+	size       int64
+	committed  bool
+}
+
+// StageObject creates a temporary file for new object data in the bucket's staging directory
+func StageObject(dirPath, bucketName string) (*StagedObject, error) {
+	dir := stagingDir(dirPath, bucketName)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, errors.New("error creating staging directory: " + err.Error())
+	}
+	file, err := os.CreateTemp(dir, "object-*")
+	if err != nil {
+		return nil, errors.New("error creating staged object: " + err.Error())
+	}
+	return &StagedObject{dirPath: dirPath, bucketName: bucketName, file: file}, nil
+}
+
+// Write appends data to the staged object
+func (staged *StagedObject) Write(p []byte) (int, error) {
+	n, err := staged.file.Write(p)
+	staged.size += int64(n)
+	return n, err
+}
+
+// Size returns the number of bytes written to the staged object
+func (staged *StagedObject) Size() int64 {
+	return staged.size
+}
+
+// Commit flushes the staged data to disk and atomically renames it over the object path,
+// creating the directories of a hierarchical key, then syncs the parent directory so that
+// the rename itself survives a crash. ErrKeyConflict is returned when the key collides with
+// another object.
+func (staged *StagedObject) Commit(objectPath string) error {
+	if err := staged.file.Sync(); err != nil {
+		return errors.New("error syncing object data: " + err.Error())
+	}
+	if err := staged.file.Close(); err != nil {
+		return errors.New("error closing object data: " + err.Error())
+	}
+	if err := placeFile(staged.dirPath, staged.bucketName, staged.file.Name(), objectPath); err != nil {
+		return err
+	}
+	staged.committed = true
+
+	if dir, err := os.Open(filepath.Dir(objectPath)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+// Discard removes the staged data unless it was committed. It is meant to be deferred right
+// after StageObject so that failed or aborted writes leave nothing behind.
+func (staged *StagedObject) Discard() {
+	if staged.committed {
+		return
+	}
+	staged.file.Close()
+	os.Remove(staged.file.Name())
+}
+
+// CleanupStagedObjects removes the staged data left in every bucket by writes that were
+// interrupted by a crash. It must run before the server accepts requests.
+func CleanupStagedObjects(dirPath string) {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		log.Println("Error reading data directory:", err)
+		return
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := stagingDir(dirPath, entry.Name())
+		staged, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, file := range staged {
+			if err := os.RemoveAll(filepath.Join(dir, file.Name())); err != nil {
+				log.Println("Error removing staged object:", err)
+				continue
+			}
+			log.Printf("Removed interrupted upload %s of bucket %s\n", file.Name(), entry.Name())
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"time"
+
+	"triple-s/internal/models"
+)
+
+// UsersFileName is the file next to buckets.csv listing the users managed through the admin API
+const UsersFileName = "users.csv"
+
+var (
+	// ErrUserNotFound is returned when a user has no record in users.csv
+	ErrUserNotFound = errors.New("user not found")
+	// ErrUserExists is returned when creating a user whose name is taken
+	ErrUserExists = errors.New("user already exists")
+)
+
+// ListUsers returns every user in the order they were created
+func ListUsers(dirPath string) ([]models.User, error) {
+	records, err := readCSVFile(dirPath + UsersFileName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	users := make([]models.User, 0, len(records))
+	for _, record := range records {
+		if len(record) < 2 {
+			continue
+		}
+		name, err := base64.StdEncoding.DecodeString(record[0])
+		if err != nil {
+			return nil, errors.New("error decoding user name: " + err.Error())
+		}
+		created, _ := base64.StdEncoding.DecodeString(record[1])
+		users = append(users, models.User{Name: string(name), CreationTime: string(created)})
+	}
+	return users, nil
+}
+
+// ReadUser looks up a single user
+func ReadUser(dirPath, userName string) (models.User, error) {
+	users, err := ListUsers(dirPath)
+	if err != nil {
+		return models.User{}, err
+	}
+	for _, user := range users {
+		if user.Name == userName {
+			return user, nil
+		}
+	}
+	return models.User{}, ErrUserNotFound
+}
+
+// CreateUser adds a user to users.csv
+func CreateUser(dirPath, userName string) (models.User, error) {
+	defer LockMetadataFile(dirPath + UsersFileName)()
+	users, err := ListUsers(dirPath)
+	if err != nil {
+		return models.User{}, err
+	}
+	for _, user := range users {
+		if user.Name == userName {
+			return models.User{}, ErrUserExists
+		}
+	}
+
+	user := models.User{Name: userName, CreationTime: time.Now().Format(time.RFC3339)}
+	users = append(users, user)
+	return user, writeUsers(dirPath, users)
+}
+
+// DeleteUser removes a user together with every access key attached to it
+func DeleteUser(dirPath, userName string) error {
+	defer LockMetadataFile(dirPath + UsersFileName)()
+	users, err := ListUsers(dirPath)
+	if err != nil {
+		return err
+	}
+
+	remaining := users[:0]
+	for _, user := range users {
+		if user.Name != userName {
+			remaining = append(remaining, user)
+		}
+	}
+	if len(remaining) == len(users) {
+		return ErrUserNotFound
+	}
+
+	if err := deleteAccessKeys(dirPath, func(key models.AccessKey) bool { return key.UserName == userName }); err != nil {
+		return err
+	}
+	return writeUsers(dirPath, remaining)
+}
+
+// writeUsers replaces the content of users.csv
+func writeUsers(dirPath string, users []models.User) error {
+	records := make([][]string, 0, len(users))
+	for _, user := range users {
+		records = append(records, []string{
+			base64.StdEncoding.EncodeToString([]byte(user.Name)),
+			base64.StdEncoding.EncodeToString([]byte(user.CreationTime)),
+		})
+	}
+	return writeCSVFile(dirPath+UsersFileName, records)
+}
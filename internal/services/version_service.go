@@ -0,0 +1,499 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"triple-s/internal/models"
+)
+
+const (
+	// VersioningEnabled keeps every version of an object
+	VersioningEnabled = "Enabled"
+	// VersioningSuspended stores new writes as the null version while keeping existing versions
+	VersioningSuspended = "Suspended"
+)
+
+// ErrVersionNotFound is returned when an object has no version with the requested ID
+var ErrVersionNotFound = errors.New("version not found")
+
+// DeleteOutcome describes what a delete did in a bucket with versioning configured
+type DeleteOutcome struct {
+	VersionID    string
+	DeleteMarker bool
+}
+
+// NewVersionID generates a version ID. IDs start with the creation time so they sort by age.
+func NewVersionID() string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("%016x%s", time.Now().UnixNano(), hex.EncodeToString(suffix))
+}
+
+// NextVersionID returns the version ID a new write receives under the versioning status
+func NextVersionID(versioning string) string {
+	if versioning == VersioningEnabled {
+		return NewVersionID()
+	}
+	return ""
+}
+
+// keyVersionsDir returns the directory holding the noncurrent versions of a key
+func keyVersionsDir(dirPath, bucketName, objectKey string) string {
+	keyHash := sha256.Sum256([]byte(objectKey))
+	return filepath.Join(dirPath+bucketName, InternalDirName, "versions", hex.EncodeToString(keyHash[:16]))
+}
+
+// VersionPath returns the file holding the data of a noncurrent object version
+func VersionPath(dirPath, bucketName, objectKey, versionID string) string {
+	if versionID == "" {
+		versionID = "null"
+	}
+	return filepath.Join(keyVersionsDir(dirPath, bucketName, objectKey), versionID)
+}
+
+// versionsFile returns the path of the CSV file listing the noncurrent versions of a key.
+// Every key has its own file, next to the data of its versions, so that recording a version
+// rewrites and journals the versions of that key only.
+func versionsFile(dirPath, bucketName, objectKey string) string {
+	return filepath.Join(keyVersionsDir(dirPath, bucketName, objectKey), "versions.csv")
+}
+
+// ListVersionInfo returns the noncurrent versions and delete markers of a bucket sorted by
+// key, oldest first for each key
+func ListVersionInfo(dirPath, bucketName string) ([]models.ObjectVersion, error) {
+	paths, err := filepath.Glob(filepath.Join(dirPath+bucketName, InternalDirName, "versions", "*", "versions.csv"))
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []models.ObjectVersion
+	for _, path := range paths {
+		keyVersions, err := readVersionsFile(path)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, keyVersions...)
+	}
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].Object.ObjectKey < versions[j].Object.ObjectKey
+	})
+	return versions, nil
+}
+
+// listKeyVersions returns the noncurrent versions and delete markers of a key, oldest first
+func listKeyVersions(dirPath, bucketName, objectKey string) ([]models.ObjectVersion, error) {
+	return readVersionsFile(versionsFile(dirPath, bucketName, objectKey))
+}
+
+// readVersionsFile decodes the records of a versions.csv file
+func readVersionsFile(path string) ([]models.ObjectVersion, error) {
+	records, err := readCSVFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var versions []models.ObjectVersion
+	for _, record := range records {
+		if len(record) < 10 {
+			continue
+		}
+		// The version fields follow the eight original object fields, and the object
+		// fields added since are appended after them
+		localObject, err := decodeObjectRecord(append(record[:8:8], record[10:]...))
+		if err != nil {
+			return nil, err
+		}
+		archived, _ := base64.StdEncoding.DecodeString(record[9])
+		versions = append(versions, models.ObjectVersion{
+			Object:         localObject,
+			IsDeleteMarker: record[8] == "true",
+			ArchivedTime:   string(archived),
+		})
+	}
+	return versions, nil
+}
+
+// encodeVersionRecords encodes versions as the records of versions.csv
+func encodeVersionRecords(versions []models.ObjectVersion) [][]string {
+	records := make([][]string, 0, len(versions))
+	for _, version := range versions {
+		record := encodeObjectRecord(version.Object)
+		records = append(records, append(append(record[:8:8],
+			fmt.Sprint(version.IsDeleteMarker),
+			base64.StdEncoding.EncodeToString([]byte(version.ArchivedTime)),
+		), record[8:]...))
+	}
+	return records
+}
+
+// updateVersions applies a change to the version records of a key together with the other
+// changes of the same operation, journaling them as a single entry. The records file of a key
+// left without versions is removed.
+func updateVersions(dirPath, bucketName, objectKey string, changes *metadataChanges, update func([]models.ObjectVersion) []models.ObjectVersion) error {
+	path := versionsFile(dirPath, bucketName, objectKey)
+	defer LockMetadataFile(path)()
+	versions, err := listKeyVersions(dirPath, bucketName, objectKey)
+	if err != nil {
+		return err
+	}
+	if versions = update(versions); len(versions) == 0 {
+		err = changes.removeFile(path)
+	} else {
+		var data []byte
+		if data, err = encodeCSV(encodeVersionRecords(versions)); err == nil {
+			err = changes.writeFile(path, data)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return changes.apply()
+}
+
+// withoutVersions returns the versions other than the given ones
+func withoutVersions(versions []models.ObjectVersion, removed ...models.ObjectVersion) []models.ObjectVersion {
+	remaining := versions[:0:0]
+	for _, version := range versions {
+		keep := true
+		for _, r := range removed {
+			if version.Object.ObjectKey == r.Object.ObjectKey && version.Object.VersionID == r.Object.VersionID {
+				keep = false
+			}
+		}
+		if keep {
+			remaining = append(remaining, version)
+		}
+	}
+	return remaining
+}
+
+// latestVersion returns the newest noncurrent version or delete marker of a key
+func latestVersion(versions []models.ObjectVersion, objectKey string) (models.ObjectVersion, bool) {
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].Object.ObjectKey == objectKey {
+			return versions[i], true
+		}
+	}
+	return models.ObjectVersion{}, false
+}
+
+// nullVersion returns the noncurrent null version of a key, which has at most one
+func nullVersion(versions []models.ObjectVersion, objectKey string) (models.ObjectVersion, bool) {
+	for _, version := range versions {
+		if version.Object.ObjectKey == objectKey && version.Object.VersionID == "" {
+			return version, true
+		}
+	}
+	return models.ObjectVersion{}, false
+}
+
+// removeVersionData deletes the data file of a noncurrent version whose record is gone
+func removeVersionData(dirPath, bucketName string, version models.ObjectVersion) {
+	if version.IsDeleteMarker {
+		return
+	}
+	path := VersionPath(dirPath, bucketName, version.Object.ObjectKey, version.Object.VersionID)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Println("Error removing version data:", err)
+	}
+	os.Remove(filepath.Dir(path))
+}
+
+// ArchiveObject hard-links the data of the current version of an object, or copies it where
+// links are not supported, into the version store and returns the noncurrent version to
+// record. The object stays readable until the caller replaces or removes its file, and the
+// caller records the version with the same journal entry that replaces or removes the object
+// record.
+func ArchiveObject(dirPath, bucketName string, current models.Object) (models.ObjectVersion, error) {
+	objectPath, err := ObjectPath(dirPath, bucketName, current.ObjectKey)
+	if err != nil {
+		return models.ObjectVersion{}, err
+	}
+	versionPath := VersionPath(dirPath, bucketName, current.ObjectKey, current.VersionID)
+	if err := os.MkdirAll(filepath.Dir(versionPath), os.ModePerm); err != nil {
+		return models.ObjectVersion{}, errors.New("error creating versions directory: " + err.Error())
+	}
+	os.Remove(versionPath)
+	if err := os.Link(objectPath, versionPath); err != nil {
+		if err := copyFile(objectPath, versionPath); err != nil {
+			return models.ObjectVersion{}, errors.New("error archiving object: " + err.Error())
+		}
+	}
+
+	return models.ObjectVersion{
+		Object:       current,
+		ArchivedTime: time.Now().Format(time.RFC3339),
+	}, nil
+}
+
+// copyFile copies the content of a file to a new file synced to disk
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, in); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return nil
+}
+
+// ReplaceObject moves the data of a new version over an object with commit, which returns
+// the metadata of the new version, and records it while preserving the version it replaces
+// under the bucket's versioning status. Unversioned buckets and null versions under
+// suspended versioning are simply overwritten. The replaced data stays in place until
+// commit renames the new data over it, so readers never miss the object, and the new record
+// and the archived version are journaled as a single entry once commit succeeds.
+func ReplaceObject(dirPath, bucketName, objectKey, versioning string, commit func() (models.Object, error)) error {
+	if versioning == "" {
+		object, err := commit()
+		if err != nil {
+			return err
+		}
+		return PutObjectInfo(dirPath, bucketName, object)
+	}
+
+	var archived *models.ObjectVersion
+	current, err := ReadObjectInfo(dirPath, bucketName, objectKey)
+	if err == nil && (versioning == VersioningEnabled || current.VersionID != "") {
+		version, err := ArchiveObject(dirPath, bucketName, current)
+		if err != nil {
+			return errors.New("error preserving previous version: " + err.Error())
+		}
+		archived = &version
+	} else if err != nil && err != ErrObjectNotFound {
+		return err
+	}
+
+	object, err := commit()
+	if err != nil {
+		if archived != nil {
+			removeVersionData(dirPath, bucketName, *archived)
+		}
+		return err
+	}
+
+	// Under suspended versioning the new null version replaces the noncurrent one
+	var dropped []models.ObjectVersion
+	changes := newMetadataChanges(dirPath)
+	changes.putObject(bucketName, object)
+	err = updateVersions(dirPath, bucketName, objectKey, changes, func(versions []models.ObjectVersion) []models.ObjectVersion {
+		if null, ok := nullVersion(versions, objectKey); ok && versioning == VersioningSuspended {
+			dropped = append(dropped, null)
+			versions = withoutVersions(versions, null)
+		}
+		if archived != nil {
+			versions = append(versions, *archived)
+		}
+		return versions
+	})
+	if err != nil {
+		return err
+	}
+	for _, version := range dropped {
+		removeVersionData(dirPath, bucketName, version)
+	}
+	return nil
+}
+
+// ReadObjectVersion resolves a version of an object, current or noncurrent, and returns it with
+// the path of its data
+func ReadObjectVersion(dirPath, bucketName, objectKey, versionID string) (models.ObjectVersion, string, error) {
+	current, err := ReadObjectInfo(dirPath, bucketName, objectKey)
+	if err == nil && current.VersionID == versionID {
+		objectPath, err := ObjectPath(dirPath, bucketName, objectKey)
+		return models.ObjectVersion{Object: current}, objectPath, err
+	} else if err != nil && err != ErrObjectNotFound {
+		return models.ObjectVersion{}, "", err
+	}
+
+	versions, err := listKeyVersions(dirPath, bucketName, objectKey)
+	if err != nil {
+		return models.ObjectVersion{}, "", err
+	}
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].Object.ObjectKey == objectKey && versions[i].Object.VersionID == versionID {
+			return versions[i], VersionPath(dirPath, bucketName, objectKey, versionID), nil
+		}
+	}
+	return models.ObjectVersion{}, "", ErrVersionNotFound
+}
+
+// LatestDeleteMarker returns the delete marker hiding an object that has no current version
+func LatestDeleteMarker(dirPath, bucketName, objectKey string) (models.ObjectVersion, bool) {
+	versions, err := listKeyVersions(dirPath, bucketName, objectKey)
+	if err != nil {
+		return models.ObjectVersion{}, false
+	}
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].Object.ObjectKey == objectKey {
+			return versions[i], versions[i].IsDeleteMarker
+		}
+	}
+	return models.ObjectVersion{}, false
+}
+
+// CreateDeleteMarker deletes an object in a bucket with versioning configured by placing a
+// delete marker on top of its versions. The removal of the object record, its archived
+// version and the marker are journaled as a single entry before the object file is removed.
+func CreateDeleteMarker(dirPath, bucketName, objectKey, versioning string) (DeleteOutcome, error) {
+	changes := newMetadataChanges(dirPath)
+	var archived *models.ObjectVersion
+	objectPath := ""
+	current, err := ReadObjectInfo(dirPath, bucketName, objectKey)
+	if err == nil {
+		objectPath, err = ObjectPath(dirPath, bucketName, objectKey)
+		if err != nil {
+			return DeleteOutcome{}, err
+		}
+		// Under suspended versioning the null version is replaced by the delete marker
+		if versioning == VersioningEnabled || current.VersionID != "" {
+			version, err := ArchiveObject(dirPath, bucketName, current)
+			if err != nil {
+				return DeleteOutcome{}, err
+			}
+			archived = &version
+		}
+		changes.deleteObjects(bucketName, objectKey)
+	} else if err != ErrObjectNotFound {
+		return DeleteOutcome{}, err
+	}
+
+	marker := models.ObjectVersion{
+		Object: models.Object{
+			ObjectKey:        objectKey,
+			LastModifiedTime: time.Now().Format(time.RFC3339),
+			VersionID:        NextVersionID(versioning),
+		},
+		IsDeleteMarker: true,
+	}
+	var dropped []models.ObjectVersion
+	err = updateVersions(dirPath, bucketName, objectKey, changes, func(versions []models.ObjectVersion) []models.ObjectVersion {
+		if null, ok := nullVersion(versions, objectKey); ok && versioning == VersioningSuspended {
+			dropped = append(dropped, null)
+			versions = withoutVersions(versions, null)
+		}
+		if archived != nil {
+			versions = append(versions, *archived)
+		}
+		return append(versions, marker)
+	})
+	if err != nil {
+		if archived != nil {
+			removeVersionData(dirPath, bucketName, *archived)
+		}
+		return DeleteOutcome{}, err
+	}
+	for _, version := range dropped {
+		removeVersionData(dirPath, bucketName, version)
+	}
+
+	if objectPath != "" {
+		if err := os.Remove(objectPath); err != nil && !os.IsNotExist(err) {
+			return DeleteOutcome{}, errors.New("error deleting object: " + err.Error())
+		}
+		RemoveEmptyParents(dirPath, bucketName, objectPath)
+	}
+	return DeleteOutcome{VersionID: marker.Object.VersionID, DeleteMarker: true}, nil
+}
+
+// DeleteObjectVersion permanently deletes one version of an object. When the current version
+// is removed, the newest remaining version takes its place unless it is a delete marker. The
+// record changes are journaled as a single entry.
+func DeleteObjectVersion(dirPath, bucketName, objectKey, versionID string) (DeleteOutcome, error) {
+	current, err := ReadObjectInfo(dirPath, bucketName, objectKey)
+	hasCurrent := err == nil
+	if err != nil && err != ErrObjectNotFound {
+		return DeleteOutcome{}, err
+	}
+	versions, err := listKeyVersions(dirPath, bucketName, objectKey)
+	if err != nil {
+		return DeleteOutcome{}, err
+	}
+	objectPath, err := ObjectPath(dirPath, bucketName, objectKey)
+	if err != nil {
+		return DeleteOutcome{}, err
+	}
+
+	// Find the version to remove, then the version taking the place of a missing current one
+	outcome := DeleteOutcome{VersionID: versionID}
+	removeCurrent := hasCurrent && current.VersionID == versionID
+	var removed []models.ObjectVersion
+	if !removeCurrent {
+		version, ok := models.ObjectVersion{}, false
+		for i := len(versions) - 1; i >= 0 && !ok; i-- {
+			if versions[i].Object.ObjectKey == objectKey && versions[i].Object.VersionID == versionID {
+				version, ok = versions[i], true
+			}
+		}
+		if !ok {
+			return DeleteOutcome{}, ErrVersionNotFound
+		}
+		removed = append(removed, version)
+		outcome.DeleteMarker = version.IsDeleteMarker
+	}
+	promoted, ok := latestVersion(withoutVersions(versions, removed...), objectKey)
+	promote := ok && !promoted.IsDeleteMarker && (removeCurrent || !hasCurrent)
+
+	changes := newMetadataChanges(dirPath)
+	if promote {
+		versionPath := VersionPath(dirPath, bucketName, objectKey, promoted.Object.VersionID)
+		if err := placeFile(dirPath, bucketName, versionPath, objectPath); err != nil {
+			return DeleteOutcome{}, errors.New("error restoring version: " + err.Error())
+		}
+		changes.putObject(bucketName, promoted.Object)
+	} else if removeCurrent {
+		changes.deleteObjects(bucketName, objectKey)
+	}
+
+	if promote || len(removed) > 0 {
+		err = updateVersions(dirPath, bucketName, objectKey, changes, func(versions []models.ObjectVersion) []models.ObjectVersion {
+			if promote {
+				return withoutVersions(versions, append(removed, promoted)...)
+			}
+			return withoutVersions(versions, removed...)
+		})
+	} else {
+		err = changes.apply()
+	}
+	if err != nil {
+		return DeleteOutcome{}, err
+	}
+
+	for _, version := range removed {
+		removeVersionData(dirPath, bucketName, version)
+	}
+	// The directory of the key goes once its last version was restored or removed
+	os.Remove(keyVersionsDir(dirPath, bucketName, objectKey))
+	if removeCurrent && !promote {
+		if err := os.Remove(objectPath); err != nil && !os.IsNotExist(err) {
+			return DeleteOutcome{}, errors.New("error deleting object: " + err.Error())
+		}
+		RemoveEmptyParents(dirPath, bucketName, objectPath)
+	}
+	return outcome, nil
+}
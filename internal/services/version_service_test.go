@@ -0,0 +1,105 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"triple-s/internal/models"
+)
+
+func TestReplaceObjectKeepsCurrentVersionReadable(t *testing.T) {
+	dir := t.TempDir() + "/"
+	if err := os.Mkdir(dir+"bucket", os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	objectPath := dir + "bucket/key"
+	if err := os.WriteFile(objectPath, []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := PutObjectInfo(dir, "bucket", models.Object{ObjectKey: "key", Size: 3, VersionID: "v1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The current data stays readable while the new data is moved into place, and a failed
+	// commit leaves no trace of the archived version
+	commitErr := errors.New("disk full")
+	err := ReplaceObject(dir, "bucket", "key", VersioningEnabled, func() (models.Object, error) {
+		if data, err := os.ReadFile(objectPath); err != nil || string(data) != "old" {
+			t.Errorf("object during the overwrite: %q, %v", data, err)
+		}
+		return models.Object{}, commitErr
+	})
+	if err != commitErr {
+		t.Fatalf("got %v, want the commit error", err)
+	}
+	if data, err := os.ReadFile(objectPath); err != nil || string(data) != "old" {
+		t.Errorf("object after a failed commit: %q, %v", data, err)
+	}
+	if versions, err := ListVersionInfo(dir, "bucket"); err != nil || len(versions) != 0 {
+		t.Errorf("versions after a failed commit: %v, %v", versions, err)
+	}
+
+	// Like StagedObject.Commit, new data is renamed over the object rather than written in place
+	if err := os.WriteFile(dir+"new", []byte("new"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	err = ReplaceObject(dir, "bucket", "key", VersioningEnabled, func() (models.Object, error) {
+		return models.Object{ObjectKey: "key", Size: 3, VersionID: "v2"}, os.Rename(dir+"new", objectPath)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions, err := ListVersionInfo(dir, "bucket")
+	if err != nil || len(versions) != 1 || versions[0].Object.VersionID != "v1" {
+		t.Fatalf("versions after the overwrite: %v, %v", versions, err)
+	}
+	if data, err := os.ReadFile(VersionPath(dir, "bucket", "key", "v1")); err != nil || string(data) != "old" {
+		t.Errorf("archived version: %q, %v", data, err)
+	}
+	if object, err := ReadObjectInfo(dir, "bucket", "key"); err != nil || object.VersionID != "v2" {
+		t.Errorf("current version after the overwrite: %v, %v", object, err)
+	}
+}
+
+func TestVersionRecordsArePerKey(t *testing.T) {
+	dir := t.TempDir() + "/"
+	if err := os.Mkdir(dir+"bucket", os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	var markers []string
+	for _, key := range []string{"b", "a", "a"} {
+		outcome, err := CreateDeleteMarker(dir, "bucket", key, VersioningEnabled)
+		if err != nil {
+			t.Fatal(err)
+		}
+		markers = append(markers, outcome.VersionID)
+	}
+
+	// Each key lists its own versions, and the bucket listing sorts them by key
+	if versions, err := listKeyVersions(dir, "bucket", "b"); err != nil || len(versions) != 1 || versions[0].Object.VersionID != markers[0] {
+		t.Errorf("versions of b: %v, %v", versions, err)
+	}
+	versions, err := ListVersionInfo(dir, "bucket")
+	if err != nil || len(versions) != 3 {
+		t.Fatalf("versions of the bucket: %v, %v", versions, err)
+	}
+	for i, want := range []string{markers[1], markers[2], markers[0]} {
+		if versions[i].Object.VersionID != want {
+			t.Errorf("version %d: %s, want %s", i, versions[i].Object.VersionID, want)
+		}
+	}
+
+	// Removing the last version of a key removes its directory
+	for _, versionID := range markers[1:] {
+		if _, err := DeleteObjectVersion(dir, "bucket", "a", versionID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(keyVersionsDir(dir, "bucket", "a")); !os.IsNotExist(err) {
+		t.Errorf("versions directory of a after removing its versions: %v", err)
+	}
+	if _, err := os.Stat(versionsFile(dir, "bucket", "b")); err != nil {
+		t.Errorf("versions of b: %v", err)
+	}
+}
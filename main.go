@@ -4,25 +4,37 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"triple-s/internal/handlers"
+	"triple-s/internal/services"
 )
 
 var (
-	portNumber    string
-	directoryPath string
+	portNumber         string
+	directoryPath      string
+	uploadExpiry       time.Duration
+	lifecycleInterval  time.Duration
+	adminAddress       string
+	metadataFormat     string
+	checkpointInterval time.Duration
+	authMode           string
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "presign" {
+		os.Exit(runPresign(os.Args[2:]))
+	}
+
 	parseFlags()
 	if directoryPath[len(directoryPath)-1] != '/' {
 		directoryPath += "/"
 	}
-	mux := http.NewServeMux()
 	args := os.Args[1:]
 	for _, v := range args {
 		if v == "--help" || v == "--h" {
@@ -30,8 +42,48 @@ func main() {
 			return
 		}
 	}
-	// Handle root requests for bucket actions
-	mux.HandleFunc("/", rootHandler)
+	if err := services.SetMetadataFormat(metadataFormat); err != nil {
+		log.Fatal(err)
+	}
+	if err := services.SetAuthMode(authMode); err != nil {
+		log.Fatal(err)
+	}
+	if !services.AuthEnabled(directoryPath) {
+		log.Println("Authentication is off: requests are accepted without signatures until an access key is created; use -auth on to require them")
+	}
+	// Replay the metadata changes journaled before a crash before serving requests
+	if err := services.OpenMetadata(directoryPath); err != nil {
+		log.Fatal("Error opening metadata: ", err)
+	}
+	// Remove the data of uploads interrupted by a crash before serving requests
+	services.CleanupStagedObjects(directoryPath)
+
+	// Handle every request for bucket actions, verifying request signatures first. No
+	// ServeMux is involved, since it would redirect keys with empty segments such as "a//b".
+	handler := handlers.Authenticate(directoryPath, http.HandlerFunc(rootHandler))
+
+	// Periodically abort multipart uploads that were never completed
+	go cleanupUploads()
+
+	// Periodically apply the lifecycle rules of every bucket
+	go applyLifecycle()
+
+	// Periodically sync the metadata to disk and empty the journal
+	go checkpointMetadata()
+
+	// Serve the admin API on its own address. It has no authentication, so only loopback
+	// addresses are accepted.
+	if adminAddress != "" {
+		if err := checkAdminAddress(adminAddress); err != nil {
+			log.Fatal(err)
+		}
+		adminMux := http.NewServeMux()
+		adminMux.HandleFunc("/", adminHandler)
+		go func() {
+			log.Printf("Admin API running on %s...\n", adminAddress)
+			log.Fatal(http.ListenAndServe(adminAddress, adminMux))
+		}()
+	}
 
 	// Start server on the configured port
 	correctPort, _ := strconv.Atoi(portNumber)
@@ -40,24 +92,37 @@ func main() {
 		portNumber = "8080"
 	}
 	log.Printf("Server running on port %s...\n", portNumber)
-	log.Fatal(http.ListenAndServe(":"+portNumber, mux))
+	log.Fatal(http.ListenAndServe(":"+portNumber, handler))
 }
 
 var helpUsage string = `Simple Storage Service.
 
 **Usage:**
-    triple-s [-port <N>] [-dir <S>]  
+    triple-s [-port <N>] [-dir <S>] [-upload-expiry <D>] [-lifecycle-interval <D>] [-admin-addr <A>] [-metadata <F>] [-checkpoint-interval <D>] [-auth <M>]
+    triple-s presign [options] <bucket>/<key>
     triple-s --help
 
 **Options:**
-- --help     Show this screen.
-- --port N   Port number
-- --dir S    Path to the directory`
+- --help                  Show this screen.
+- --port N                Port number
+- --dir S                 Path to the directory
+- --upload-expiry D       Age after which unfinished multipart uploads are aborted
+- --lifecycle-interval D  Time between two applications of the bucket lifecycle rules
+- --admin-addr A          Loopback address of the admin API, such as 127.0.0.1:9090
+- --metadata F            Metadata format: csv (default), log or index
+- --checkpoint-interval D Time between two checkpoints of the metadata journal
+- --auth M                Authentication: auto (default), on or off`
 
 // parseFlags reads command-line flags for configuration
 func parseFlags() {
 	flag.StringVar(&portNumber, "port", "8080", "Port number for the server")
 	flag.StringVar(&directoryPath, "dir", "data/", "Directory path to store bucket data")
+	flag.DurationVar(&uploadExpiry, "upload-expiry", 7*24*time.Hour, "Age after which unfinished multipart uploads are aborted")
+	flag.DurationVar(&lifecycleInterval, "lifecycle-interval", time.Hour, "Time between two applications of the bucket lifecycle rules")
+	flag.StringVar(&adminAddress, "admin-addr", "", "Loopback address of the admin API, disabled when empty")
+	flag.StringVar(&metadataFormat, "metadata", services.MetadataCSV, "Metadata format, csv, log or index")
+	flag.DurationVar(&checkpointInterval, "checkpoint-interval", time.Minute, "Time between two checkpoints of the metadata journal")
+	flag.StringVar(&authMode, "auth", services.AuthAuto, "Authentication, auto, on or off")
 	flag.Usage = func() {
 		fmt.Println(helpUsage)
 	}
@@ -67,14 +132,22 @@ func parseFlags() {
 }
 
 func rootHandler(w http.ResponseWriter, r *http.Request) {
-	pathComponents := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	// The first path segment names the bucket, everything after it is the object key
+	bucketName, objectKey, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
 
-	if len(pathComponents) == 1 && pathComponents[0] != "" {
+	if bucketName != "" && objectKey == "" {
 		bucketHandler(w, r)
-	} else if len(pathComponents) == 2 {
-		objectHandler(w, r, pathComponents[0], pathComponents[1])
+	} else if bucketName != "" {
+		if !handlers.ValidateObjectKey(objectKey) {
+			http.Error(w, "Invalid object key", http.StatusBadRequest)
+			return
+		}
+		objectHandler(w, r, bucketName, objectKey)
 	} else {
 		if r.URL.Path == "/" {
+			if !handlers.Authorize(w, r, directoryPath, "", "") {
+				return
+			}
 			switch r.Method {
 			case http.MethodGet:
 				handlers.HandleGetBuckets(w, r, directoryPath)
@@ -87,15 +160,67 @@ func rootHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// cleanupUploads aborts abandoned multipart uploads once an hour
+func cleanupUploads() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		services.CleanupAbandonedUploads(directoryPath, uploadExpiry)
+		<-ticker.C
+	}
+}
+
 // bucketHandler handles actions related to the bucket
 func bucketHandler(w http.ResponseWriter, r *http.Request) {
+	if !handlers.Authorize(w, r, directoryPath, strings.Trim(r.URL.Path, "/"), "") {
+		return
+	}
+
+	query := r.URL.Query()
+	if query.Has("acl") {
+		bucketACLHandler(w, r)
+		return
+	}
+	if query.Has("policy") {
+		bucketPolicyHandler(w, r)
+		return
+	}
+	if query.Has("tagging") {
+		bucketTaggingHandler(w, r)
+		return
+	}
+	if query.Has("lifecycle") {
+		bucketLifecycleHandler(w, r)
+		return
+	}
+	if query.Has("versioning") {
+		bucketVersioningHandler(w, r)
+		return
+	}
+
 	switch r.Method {
 	case http.MethodGet:
-		handlers.HandleGetBuckets(w, r, directoryPath)
+		if query.Has("uploads") {
+			handlers.HandleListMultipartUploads(w, r, directoryPath)
+		} else if query.Has("versions") {
+			handlers.HandleListObjectVersions(w, r, directoryPath)
+		} else if query.Get("list-type") == "2" {
+			handlers.HandleListObjectsV2(w, r, directoryPath)
+		} else {
+			handlers.HandleListObjects(w, r, directoryPath)
+		}
 	case http.MethodPut:
 		handlers.HandlePutBuckets(w, r, directoryPath)
+	case http.MethodPost:
+		if query.Has("delete") {
+			handlers.HandleDeleteObjects(w, r, directoryPath)
+		} else {
+			http.Error(w, "Unsupported method", http.StatusMethodNotAllowed)
+		}
 	case http.MethodDelete:
 		handlers.HandleDeleteBuckets(w, r, directoryPath)
+	case http.MethodHead:
+		handlers.HandleHeadBuckets(w, r, directoryPath)
 	default:
 		http.Error(w, "Unsupported method", http.StatusMethodNotAllowed)
 	}
@@ -103,14 +228,213 @@ func bucketHandler(w http.ResponseWriter, r *http.Request) {
 
 // objectHandler handles actions related to an object inside a bucket
 func objectHandler(w http.ResponseWriter, r *http.Request, bucketName, objectKey string) {
+	if !handlers.Authorize(w, r, directoryPath, bucketName, objectKey) {
+		return
+	}
+
+	query := r.URL.Query()
+	if query.Has("acl") {
+		objectACLHandler(w, r, bucketName, objectKey)
+		return
+	}
+	if query.Has("tagging") {
+		objectTaggingHandler(w, r, bucketName, objectKey)
+		return
+	}
+
 	switch r.Method {
 	case http.MethodGet:
-		handlers.HandlerGetObject(w, r, directoryPath, bucketName, objectKey)
+		if query.Has("uploadId") {
+			handlers.HandlerListParts(w, r, directoryPath, bucketName, objectKey)
+		} else {
+			handlers.HandlerGetObject(w, r, directoryPath, bucketName, objectKey)
+		}
 	case http.MethodPut:
-		handlers.HandlerPutObject(w, r, directoryPath, bucketName, objectKey)
+		if query.Has("uploadId") {
+			handlers.HandlerUploadPart(w, r, directoryPath, bucketName, objectKey)
+		} else if r.Header.Get("x-amz-copy-source") != "" {
+			handlers.HandlerCopyObject(w, r, directoryPath, bucketName, objectKey)
+		} else {
+			handlers.HandlerPutObject(w, r, directoryPath, bucketName, objectKey)
+		}
+	case http.MethodPost:
+		if query.Has("uploads") {
+			handlers.HandlerCreateMultipartUpload(w, r, directoryPath, bucketName, objectKey)
+		} else if query.Has("uploadId") {
+			handlers.HandlerCompleteMultipartUpload(w, r, directoryPath, bucketName, objectKey)
+		} else {
+			http.Error(w, "Unsupported method", http.StatusMethodNotAllowed)
+		}
 	case http.MethodDelete:
-		handlers.HandlerDeleteObject(w, r, directoryPath, bucketName, objectKey)
+		if query.Has("uploadId") {
+			handlers.HandlerAbortMultipartUpload(w, r, directoryPath, bucketName, objectKey)
+		} else {
+			handlers.HandlerDeleteObject(w, r, directoryPath, bucketName, objectKey)
+		}
+	case http.MethodHead:
+		handlers.HandlerHeadObject(w, r, directoryPath, bucketName, objectKey)
 	default:
 		http.Error(w, "Unsupported method", http.StatusMethodNotAllowed)
 	}
 }
+
+// bucketTaggingHandler handles the ?tagging sub-resource of a bucket
+func bucketTaggingHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		handlers.HandleGetBucketTagging(w, r, directoryPath)
+	case http.MethodPut:
+		handlers.HandlePutBucketTagging(w, r, directoryPath)
+	case http.MethodDelete:
+		handlers.HandleDeleteBucketTagging(w, r, directoryPath)
+	default:
+		http.Error(w, "Unsupported method", http.StatusMethodNotAllowed)
+	}
+}
+
+// bucketACLHandler handles the ?acl sub-resource of a bucket
+func bucketACLHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		handlers.HandleGetBucketACL(w, r, directoryPath)
+	case http.MethodPut:
+		handlers.HandlePutBucketACL(w, r, directoryPath)
+	default:
+		http.Error(w, "Unsupported method", http.StatusMethodNotAllowed)
+	}
+}
+
+// bucketPolicyHandler handles the ?policy sub-resource of a bucket
+func bucketPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		handlers.HandleGetBucketPolicy(w, r, directoryPath)
+	case http.MethodPut:
+		handlers.HandlePutBucketPolicy(w, r, directoryPath)
+	case http.MethodDelete:
+		handlers.HandleDeleteBucketPolicy(w, r, directoryPath)
+	default:
+		http.Error(w, "Unsupported method", http.StatusMethodNotAllowed)
+	}
+}
+
+// bucketLifecycleHandler handles the ?lifecycle sub-resource of a bucket
+func bucketLifecycleHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		handlers.HandleGetBucketLifecycle(w, r, directoryPath)
+	case http.MethodPut:
+		handlers.HandlePutBucketLifecycle(w, r, directoryPath)
+	case http.MethodDelete:
+		handlers.HandleDeleteBucketLifecycle(w, r, directoryPath)
+	default:
+		http.Error(w, "Unsupported method", http.StatusMethodNotAllowed)
+	}
+}
+
+// bucketVersioningHandler handles the ?versioning sub-resource of a bucket
+func bucketVersioningHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		handlers.HandleGetBucketVersioning(w, r, directoryPath)
+	case http.MethodPut:
+		handlers.HandlePutBucketVersioning(w, r, directoryPath)
+	default:
+		http.Error(w, "Unsupported method", http.StatusMethodNotAllowed)
+	}
+}
+
+// objectTaggingHandler handles the ?tagging sub-resource of an object
+func objectTaggingHandler(w http.ResponseWriter, r *http.Request, bucketName, objectKey string) {
+	switch r.Method {
+	case http.MethodGet:
+		handlers.HandlerGetObjectTagging(w, r, directoryPath, bucketName, objectKey)
+	case http.MethodPut:
+		handlers.HandlerPutObjectTagging(w, r, directoryPath, bucketName, objectKey)
+	case http.MethodDelete:
+		handlers.HandlerDeleteObjectTagging(w, r, directoryPath, bucketName, objectKey)
+	default:
+		http.Error(w, "Unsupported method", http.StatusMethodNotAllowed)
+	}
+}
+
+// objectACLHandler handles the ?acl sub-resource of an object
+func objectACLHandler(w http.ResponseWriter, r *http.Request, bucketName, objectKey string) {
+	switch r.Method {
+	case http.MethodGet:
+		handlers.HandlerGetObjectACL(w, r, directoryPath, bucketName, objectKey)
+	case http.MethodPut:
+		handlers.HandlerPutObjectACL(w, r, directoryPath, bucketName, objectKey)
+	default:
+		http.Error(w, "Unsupported method", http.StatusMethodNotAllowed)
+	}
+}
+
+// applyLifecycle applies the bucket lifecycle rules at every lifecycle interval
+func applyLifecycle() {
+	if lifecycleInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(lifecycleInterval)
+	defer ticker.Stop()
+	for {
+		services.RunLifecycle(directoryPath, time.Now())
+		<-ticker.C
+	}
+}
+
+// checkpointMetadata syncs the journaled metadata changes to disk at every checkpoint interval
+func checkpointMetadata() {
+	if checkpointInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(checkpointInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := services.CheckpointMetadata(directoryPath); err != nil {
+			log.Println("Error checkpointing metadata:", err)
+		}
+	}
+}
+
+// checkAdminAddress refuses admin API addresses reachable from other hosts, including the
+// wildcard address of ":9090"
+func checkAdminAddress(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("invalid admin address %s: %v", address, err)
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("admin address %s is not a loopback address; the admin API is unauthenticated", address)
+	}
+	return nil
+}
+
+// adminHandler routes the admin API managing users and their access keys
+func adminHandler(w http.ResponseWriter, r *http.Request) {
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	switch {
+	case len(segments) == 1 && segments[0] == "users" && r.Method == http.MethodGet:
+		handlers.HandleListUsers(w, r, directoryPath)
+	case len(segments) == 2 && segments[0] == "users" && r.Method == http.MethodPut:
+		handlers.HandleCreateUser(w, r, directoryPath, segments[1])
+	case len(segments) == 2 && segments[0] == "users" && r.Method == http.MethodDelete:
+		handlers.HandleDeleteUser(w, r, directoryPath, segments[1])
+	case len(segments) == 3 && segments[0] == "users" && segments[2] == "keys" && r.Method == http.MethodGet:
+		handlers.HandleListAccessKeys(w, r, directoryPath, segments[1])
+	case len(segments) == 3 && segments[0] == "users" && segments[2] == "keys" && r.Method == http.MethodPost:
+		handlers.HandleCreateAccessKey(w, r, directoryPath, segments[1])
+	case len(segments) == 1 && segments[0] == "keys" && r.Method == http.MethodGet:
+		handlers.HandleListAccessKeys(w, r, directoryPath, "")
+	case len(segments) == 2 && segments[0] == "keys" && r.Method == http.MethodPost:
+		handlers.HandleUpdateAccessKey(w, r, directoryPath, segments[1])
+	case len(segments) == 2 && segments[0] == "keys" && r.Method == http.MethodDelete:
+		handlers.HandleDeleteAccessKey(w, r, directoryPath, segments[1])
+	default:
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestCheckAdminAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		ok      bool
+	}{
+		{"127.0.0.1:9090", true},
+		{"[::1]:9090", true},
+		{"localhost:9090", true},
+		{":9090", false},
+		{"0.0.0.0:9090", false},
+		{"192.168.1.10:9090", false},
+		{"admin.example.com:9090", false},
+		{"127.0.0.1", false},
+	}
+	for _, test := range tests {
+		if err := checkAdminAddress(test.address); (err == nil) != test.ok {
+			t.Errorf("%s: got %v, want accepted %v", test.address, err, test.ok)
+		}
+	}
+}
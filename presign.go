@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"triple-s/internal/services"
+)
+
+var presignUsage string = `Generate a presigned URL for an object.
+
+**Usage:**
+    triple-s presign [-endpoint <URL>] [-method <M>] [-expires <D>] [-access-key <K>] [-secret-key <S>] [-dir <S>] <bucket>/<key>
+
+**Options:**
+- --endpoint URL    Base URL of the server
+- --method M        HTTP method the URL is valid for: GET, PUT, HEAD or DELETE
+- --expires D       Validity of the URL, at most 168h
+- --access-key K    Access key ID signing the URL
+- --secret-key S    Secret access key, looked up in the credentials file when omitted
+- --dir S           Path to the directory holding credentials.csv
+- --region R        Region of the credential scope`
+
+// runPresign implements the presign subcommand, printing a presigned URL for an object
+func runPresign(args []string) int {
+	flags := flag.NewFlagSet("presign", flag.ContinueOnError)
+	endpoint := flags.String("endpoint", "http://localhost:8080", "Base URL of the server")
+	method := flags.String("method", "GET", "HTTP method the URL is valid for")
+	expires := flags.Duration("expires", time.Hour, "Validity of the URL")
+	accessKey := flags.String("access-key", os.Getenv("AWS_ACCESS_KEY_ID"), "Access key ID signing the URL")
+	secretKey := flags.String("secret-key", os.Getenv("AWS_SECRET_ACCESS_KEY"), "Secret access key")
+	dir := flags.String("dir", "data/", "Directory path holding the credentials file")
+	region := flags.String("region", "us-east-1", "Region of the credential scope")
+	flags.Usage = func() {
+		fmt.Println(presignUsage)
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	bucketName, objectKey, found := strings.Cut(strings.TrimPrefix(flags.Arg(0), "/"), "/")
+	if !found || bucketName == "" || objectKey == "" {
+		fmt.Fprintln(os.Stderr, "The object must be given as <bucket>/<key>")
+		return 2
+	}
+	*method = strings.ToUpper(*method)
+	switch *method {
+	case "GET", "PUT", "HEAD", "DELETE":
+	default:
+		fmt.Fprintln(os.Stderr, "The method must be GET, PUT, HEAD or DELETE")
+		return 2
+	}
+	if *accessKey == "" {
+		fmt.Fprintln(os.Stderr, "An access key is required")
+		return 2
+	}
+
+	// Without an explicit secret, the key is looked up in the server's credentials file
+	if *secretKey == "" {
+		if !strings.HasSuffix(*dir, "/") {
+			*dir += "/"
+		}
+		secret, err := services.LookupSecret(*dir, *accessKey)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Cannot find the secret of access key", *accessKey+":", err)
+			return 1
+		}
+		*secretKey = secret
+	}
+
+	presignedURL, err := services.PresignURL(*endpoint, *method, bucketName, objectKey, *accessKey, *secretKey, *region, *expires, time.Now())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println(presignedURL)
+	return 0
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"triple-s/internal/handlers"
+	"triple-s/internal/services"
+)
+
+const (
+	stressWorkers = 16
+	stressRounds  = 25
+	stressShared  = 4
+)
+
+// newStressServer serves a fresh data directory through the same handler chain as main
+func newStressServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	directoryPath = t.TempDir() + "/"
+	server := httptest.NewServer(handlers.Authenticate(directoryPath, http.HandlerFunc(rootHandler)))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// forEachMetadataFormat runs a test once for every metadata store implementation
+func forEachMetadataFormat(t *testing.T, test func(t *testing.T)) {
+	for _, format := range []string{services.MetadataCSV, services.MetadataLog, services.MetadataIndex} {
+		t.Run(format, func(t *testing.T) {
+			if err := services.SetMetadataFormat(format); err != nil {
+				t.Fatal(err)
+			}
+			test(t)
+		})
+	}
+}
+
+// send performs a request and fails the test on transport errors or unexpected statuses
+func send(t *testing.T, method, url, body string, want int) {
+	t.Helper()
+	request, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	io.Copy(io.Discard, response.Body)
+	response.Body.Close()
+	if response.StatusCode != want {
+		t.Errorf("%s %s: status %d, want %d", method, url, response.StatusCode, want)
+	}
+}
+
+// hammer runs the work function from many goroutines at once
+func hammer(work func(worker, round int)) {
+	var wg sync.WaitGroup
+	for worker := 0; worker < stressWorkers; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for round := 0; round < stressRounds; round++ {
+				work(worker, round)
+			}
+		}(worker)
+	}
+	wg.Wait()
+}
+
+// checkObjectData verifies that the stored file of an object matches its recorded size and ETag
+func checkObjectData(t *testing.T, bucketName, objectKey string) {
+	t.Helper()
+	object, err := services.ReadObjectInfo(directoryPath, bucketName, objectKey)
+	if err != nil {
+		t.Errorf("metadata of %s: %v", objectKey, err)
+		return
+	}
+	data, err := os.ReadFile(directoryPath + bucketName + "/" + objectKey)
+	if err != nil {
+		t.Errorf("data of %s: %v", objectKey, err)
+		return
+	}
+	digest := md5.Sum(data)
+	if int64(len(data)) != object.Size || hex.EncodeToString(digest[:]) != object.ETag {
+		t.Errorf("%s: stored data does not match its metadata", objectKey)
+	}
+}
+
+func TestConcurrentPutsAndDeletes(t *testing.T) {
+	forEachMetadataFormat(t, func(t *testing.T) {
+		server := newStressServer(t)
+		send(t, http.MethodPut, server.URL+"/stress", "", http.StatusOK)
+
+		// Every worker writes its own keys, deletes every third one and overwrites shared keys
+		hammer(func(worker, round int) {
+			key := fmt.Sprintf("worker-%d/object-%d", worker, round)
+			send(t, http.MethodPut, server.URL+"/stress/"+key, strings.Repeat("x", worker+round), http.StatusOK)
+			send(t, http.MethodPut, server.URL+fmt.Sprintf("/stress/shared-%d", round%stressShared), fmt.Sprintf("%d-%d", worker, round), http.StatusOK)
+			if round%3 == 0 {
+				send(t, http.MethodDelete, server.URL+"/stress/"+key, "", http.StatusOK)
+			}
+		})
+
+		objects, err := services.ListObjectInfo(directoryPath, "stress", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen := make(map[string]bool)
+		for _, object := range objects {
+			if seen[object.ObjectKey] {
+				t.Errorf("duplicate metadata record for %s", object.ObjectKey)
+			}
+			seen[object.ObjectKey] = true
+		}
+
+		for worker := 0; worker < stressWorkers; worker++ {
+			for round := 0; round < stressRounds; round++ {
+				key := fmt.Sprintf("worker-%d/object-%d", worker, round)
+				if round%3 == 0 {
+					if seen[key] {
+						t.Errorf("deleted object %s still has metadata", key)
+					}
+					continue
+				}
+				if !seen[key] {
+					t.Errorf("metadata of %s was lost", key)
+					continue
+				}
+				checkObjectData(t, "stress", key)
+			}
+		}
+		for i := 0; i < stressShared; i++ {
+			checkObjectData(t, "stress", fmt.Sprintf("shared-%d", i))
+		}
+		if want := stressWorkers*stressRounds - stressWorkers*((stressRounds+2)/3) + stressShared; len(objects) != want {
+			t.Errorf("%d objects recorded, want %d", len(objects), want)
+		}
+	})
+}
+
+func TestConcurrentVersionedPuts(t *testing.T) {
+	forEachMetadataFormat(t, func(t *testing.T) {
+		server := newStressServer(t)
+		send(t, http.MethodPut, server.URL+"/versioned", "", http.StatusOK)
+		send(t, http.MethodPut, server.URL+"/versioned?versioning",
+			"<VersioningConfiguration><Status>Enabled</Status></VersioningConfiguration>", http.StatusOK)
+
+		// Concurrent overwrites of the same keys must each leave a version behind
+		hammer(func(worker, round int) {
+			send(t, http.MethodPut, server.URL+fmt.Sprintf("/versioned/key-%d", round%stressShared), fmt.Sprintf("%d-%d", worker, round), http.StatusOK)
+		})
+
+		versions, err := services.ListVersionInfo(directoryPath, "versioned")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := stressWorkers*stressRounds - stressShared; len(versions) != want {
+			t.Errorf("%d noncurrent versions recorded, want %d", len(versions), want)
+		}
+		for _, version := range versions {
+			path := services.VersionPath(directoryPath, "versioned", version.Object.ObjectKey, version.Object.VersionID)
+			if _, err := os.Stat(path); err != nil {
+				t.Errorf("data of version %s of %s: %v", version.Object.VersionID, version.Object.ObjectKey, err)
+			}
+		}
+		for i := 0; i < stressShared; i++ {
+			checkObjectData(t, "versioned", fmt.Sprintf("key-%d", i))
+		}
+	})
+}
+
+func TestConcurrentSiblingPutsAndDeletes(t *testing.T) {
+	forEachMetadataFormat(t, func(t *testing.T) {
+		server := newStressServer(t)
+		send(t, http.MethodPut, server.URL+"/siblings", "", http.StatusOK)
+
+		// Deleting the last object under a prefix removes its directories while other workers
+		// write sibling keys into them; every write must still succeed
+		hammer(func(worker, round int) {
+			url := server.URL + fmt.Sprintf("/siblings/shared/dir/object-%d", worker)
+			send(t, http.MethodPut, url, fmt.Sprintf("%d-%d", worker, round), http.StatusOK)
+			if round < stressRounds-1 {
+				send(t, http.MethodDelete, url, "", http.StatusOK)
+			}
+		})
+
+		for worker := 0; worker < stressWorkers; worker++ {
+			checkObjectData(t, "siblings", fmt.Sprintf("shared/dir/object-%d", worker))
+		}
+	})
+}
+
+func TestConcurrentPartsAndAborts(t *testing.T) {
+	server := newStressServer(t)
+	send(t, http.MethodPut, server.URL+"/uploads", "", http.StatusOK)
+	uploadIDs := make([]string, stressRounds)
+	for round := range uploadIDs {
+		uploadID, err := services.CreateMultipartUpload(directoryPath, "uploads", "video.mp4", "", nil, nil, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		uploadIDs[round] = uploadID
+	}
+
+	// One worker aborts each upload while the others upload parts to it; a part arriving
+	// after the abort must be refused rather than recreate the upload
+	hammer(func(worker, round int) {
+		url := server.URL + "/uploads/video.mp4?uploadId=" + uploadIDs[round]
+		if worker == 0 {
+			send(t, http.MethodDelete, url, "", http.StatusNoContent)
+			return
+		}
+		request, err := http.NewRequest(http.MethodPut, url+fmt.Sprintf("&partNumber=%d", worker), strings.NewReader(strings.Repeat("x", 64<<10)))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		response.Body.Close()
+		if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusNotFound {
+			t.Errorf("part %d of upload %d: status %d", worker, round, response.StatusCode)
+		}
+	})
+
+	for _, uploadID := range uploadIDs {
+		path := directoryPath + "uploads/" + services.InternalDirName + "/multipart/" + uploadID
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("aborted upload %s left %s behind", uploadID, path)
+		}
+	}
+}